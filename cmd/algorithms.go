@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/salab/iccheck/pkg/search"
+	"github.com/salab/iccheck/pkg/utils/cli"
+)
+
+var algorithmsCmd = &cobra.Command{
+	Use:   "algorithms",
+	Short: "Lists available algorithms and their parameters",
+	Long: fmt.Sprintf(`ICCheck %v
+Lists available clone search algorithms, and parameters which can be set by --algorithm-param or config files.`, cli.GetFormattedVersion()),
+	Version:      cli.GetFormattedVersion(),
+	SilenceUsage: true,
+	Args:         cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for i, a := range search.Algorithms() {
+			if i > 0 {
+				_, _ = fmt.Fprintln(w)
+			}
+			_, _ = fmt.Fprintf(w, "%s\n  %s\n", a.Name, a.Description)
+			if len(a.Params) == 0 {
+				continue
+			}
+			_, _ = fmt.Fprintln(w, "\n  PARAMETER\tTYPE\tRANGE\tDEFAULT\tDESCRIPTION")
+			for _, p := range a.Params {
+				rng := p.Range()
+				if rng == "" {
+					rng = "-"
+				}
+				_, _ = fmt.Fprintf(w, "  %s\t%s\t%s\t%v\t%s\n", p.Name, p.Type, rng, p.Default, p.Description)
+			}
+		}
+		_, _ = fmt.Fprintf(w, "\n%s<path>\n  External algorithm running the executable, which receives parameters as strings. See README for the protocol.\n", search.ExecPrefix)
+		return w.Flush()
+	},
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+
+	"github.com/salab/iccheck/pkg/diskcache"
+	"github.com/salab/iccheck/pkg/utils/cli"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manages the persistent cache",
+	Long: fmt.Sprintf(`ICCheck %v
+Manages the persistent cache of per-file data (such as line bigrams), which speeds up subsequent runs.`, cli.GetFormattedVersion()),
+	Version: cli.GetFormattedVersion(),
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Removes least recently used cache entries",
+	Long: fmt.Sprintf(`ICCheck %v
+prune removes the least recently used cache entries until the cache fits in --cache-max-size.
+The cache is also pruned automatically after runs which added new entries.`, cli.GetFormattedVersion()),
+	Version:      cli.GetFormattedVersion(),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := setLogLevel(); err != nil {
+			return err
+		}
+		dir, err := getCacheDir()
+		if err != nil {
+			return err
+		}
+		maxSize := int64(cacheMaxSizeMB) * 1024 * 1024
+		if cachePruneAll {
+			maxSize = 0
+		}
+		removed, freed, err := diskcache.Prune(dir, maxSize)
+		if err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("Removed %d cache entries (%.1f MB).", removed, float64(freed)/1024/1024), "dir", dir)
+		return nil
+	},
+}
+
+var (
+	cacheDir       string
+	cacheMaxSizeMB int
+	noCache        bool
+
+	cachePruneAll bool
+)
+
+func init() {
+	cachePruneCmd.Flags().BoolVar(&cachePruneAll, "all", false, "Removes all cache entries")
+	cacheCmd.AddCommand(cachePruneCmd)
+}
+
+func getCacheDir() (string, error) {
+	if cacheDir != "" {
+		return cacheDir, nil
+	}
+	return diskcache.DefaultDir()
+}
+
+var getDiskCache = sync.OnceValues(func() (*diskcache.Cache, error) {
+	if noCache {
+		return nil, nil
+	}
+	dir, err := getCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return diskcache.New(dir, int64(cacheMaxSizeMB)*1024*1024), nil
+})
+
+// pruneDiskCache prunes the cache if this process added new entries.
+func pruneDiskCache() {
+	cache, err := getDiskCache()
+	if err != nil {
+		return
+	}
+	if err = cache.PruneIfNeeded(); err != nil {
+		slog.Warn("Failed to prune cache", "err", err)
+	}
+}
+
+// exit prunes the cache before exiting with the code, since deferred functions and hooks do not run with os.Exit.
+func exit(code int) {
+	pruneDiskCache()
+	os.Exit(code)
+}
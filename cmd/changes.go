@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/salab/iccheck/iccheck"
+)
+
+// changes are the changes to check, resolved from command line flags.
+type changes struct {
+	*iccheck.Changes
+	// configDir is the directory to read config files from
+	configDir string
+}
+
+func resolveChanges() (*changes, error) {
+	ch := changes{Changes: &iccheck.Changes{}}
+	var err error
+	switch {
+	case patchFile != "":
+		ch.To, ch.configDir, err = resolvePatchTargetTree()
+		if err != nil {
+			return nil, err
+		}
+		ch.Patch, err = readPatchFile()
+		ch.PatchStrip = patchStrip
+	case fromDir != "" || toDir != "":
+		ch.From, ch.To, err = resolveDirTrees()
+		ch.configDir = toDir
+	default:
+		ch.configDir, err = getRepoDir()
+		if err != nil {
+			return nil, err
+		}
+		ch.From, ch.To, err = resolveGitTrees(ch.configDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+func readPatchFile() ([]byte, error) {
+	// Read into memory, since stdin can only be read once
+	var r io.Reader
+	if patchFile == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(patchFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening patch file %v", patchFile)
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading patch file %v", patchFile)
+	}
+	return b, nil
+}
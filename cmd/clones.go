@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/salab/iccheck/iccheck"
+	"github.com/salab/iccheck/pkg/baseline"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/cli"
+)
+
+var clonesCmd = &cobra.Command{
+	Use:   "clones",
+	Short: "Detects all code clones in a tree",
+	Long: fmt.Sprintf(`ICCheck %v
+clones detects all code clone sets in a tree, without requiring any changes.
+Useful for finding refactoring targets, or for keeping track of duplicated code.
+Use --include and --ignore to filter the files to detect clones in.`, cli.GetFormattedVersion()),
+	Version:      cli.GetFormattedVersion(),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeoutSeconds))
+		defer cancel()
+
+		// Prepare
+		if err := setLogLevel(); err != nil {
+			return err
+		}
+		var (
+			tree      domain.Tree
+			configDir string
+			err       error
+		)
+		if clonesDir != "" {
+			configDir = clonesDir
+			tree, err = domain.NewFileSystemTree(clonesDir)
+			if err != nil {
+				return errors.Wrapf(err, "opening directory %v", clonesDir)
+			}
+		} else {
+			configDir, err = getRepoDir()
+			if err != nil {
+				return err
+			}
+			repo, err := git.PlainOpen(configDir)
+			if err != nil {
+				return errors.Wrapf(err, "opening repository at %v", configDir)
+			}
+			tree, err = iccheck.ResolveTree(repo, clonesRef)
+			if err != nil {
+				return errors.Wrapf(err, "resolving tree")
+			}
+		}
+		analyzer, opts, err := repoAnalyzer(configDir)
+		if err != nil {
+			return err
+		}
+
+		// Detect clones and report
+		report := startReport("clones", nil, tree, opts)
+		result, err := analyzer.DetectClones(ctx, tree, clonesMinLines, clonesMinSimilarity)
+		if err != nil {
+			return err
+		}
+		cloneSets := result.CloneSets
+		slog.Info(fmt.Sprintf("%d clone set(s) were found.", len(cloneSets)), "tree", tree)
+
+		if err = report.print(cloneSets, result.Coverage, baseline.NewFingerprinter(nil, tree)); err != nil {
+			return err
+		}
+
+		// If any clones are found, exit with specified code
+		if len(cloneSets) > 0 && failCode != 0 {
+			return &exitCodeError{code: failCode}
+		}
+		return nil
+	},
+}
+
+var (
+	clonesRef           string
+	clonesDir           string
+	clonesMinLines      int
+	clonesMinSimilarity float64
+)
+
+func init() {
+	clonesCmd.Flags().StringVar(&clonesRef, "ref", "HEAD", `Git ref to detect clones in.
+Can accept special value "WORKTREE" to specify the current worktree,
+or "INDEX" to specify the staged contents of the git index.`)
+	clonesCmd.Flags().StringVar(&clonesDir, "dir", "", `Directory to detect clones in, instead of a git ref.
+Does not need to be a git repository.`)
+	clonesCmd.Flags().IntVar(&clonesMinLines, "min-lines", 5, "Minimum number of lines of clones to report.")
+	clonesCmd.Flags().Float64Var(&clonesMinSimilarity, "threshold", 0, `Minimum similarity (0 to 1) of clones to report.
+Clones are also subject to the threshold of the algorithm itself (see --algorithm-param).`)
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/salab/iccheck/pkg/config"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/search"
+)
+
+// schemaRoot is the root of commands whose flags can be set by project config files.
+// Set in init to avoid initialization cycle of RootCmd.
+var schemaRoot *cobra.Command
+
+func init() {
+	schemaRoot = RootCmd
+	RootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return applyUserConfig(cmd)
+	}
+}
+
+// explicitFlags are the names of flags set explicitly by command line arguments or env,
+// which take precedence over project config files.
+var explicitFlags = make(map[string]bool)
+
+// configSchema describes the keys which can be set by project config files - all flags of all commands.
+// Repository config files can only set config.RepoKeys of them.
+func configSchema() *config.Schema {
+	flags := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		flags.AddFlagSet(cmd.PersistentFlags())
+		flags.AddFlagSet(cmd.Flags())
+		for _, subCmd := range cmd.Commands() {
+			visit(subCmd)
+		}
+	}
+	visit(schemaRoot)
+	return &config.Schema{
+		Flags:      flags,
+		Algorithms: search.AlgorithmNames(),
+		ValidateAlgorithm: func(name string) error {
+			_, err := search.GetAlgorithm(name)
+			return err
+		},
+		Enums: map[string][]string{
+			"format":     {"console", "json", "ndjson", "github", "sarif"},
+			"log-level":  {"debug", "info", "warn", "error"},
+			"on-timeout": {"fail", "partial"},
+			"snippets":   {"auto", "always", "never"},
+			"color":      {"auto", "always", "never"},
+		},
+		ValidateParam: search.ValidateParam,
+		ResolveAlgorithm: func(name string, src config.Source) (string, error) {
+			return resolveConfigAlgorithm(name, src)
+		},
+		ResolveParam: func(algorithm, name, value string, src config.Source) (string, string, error) {
+			return search.ResolveParam(algorithm, name, value, func(name string) (string, error) {
+				return resolveConfigAlgorithm(name, src)
+			})
+		},
+	}
+}
+
+// resolveConfigAlgorithm resolves relative paths of external algorithms against the directory of the config file.
+// External algorithms are rejected in repository config files, since they would run arbitrary programs
+// of whoever can change the repository (such as authors of pull requests checked in CI).
+func resolveConfigAlgorithm(name string, src config.Source) (string, error) {
+	if !strings.HasPrefix(name, search.ExecPrefix) {
+		return name, nil
+	}
+	if !src.Trusted {
+		return "", errors.Errorf("external algorithm %v is not allowed in repository config files, "+
+			"use --algorithm, ICCHECK_ALGORITHM env or the user-level config file instead", name)
+	}
+	return search.ResolveExecPath(name, src.Dir), nil
+}
+
+func readProjectConfig(repoDir string) (*config.File, error) {
+	conf, err := config.Load(repoDir, configSchema())
+	if err != nil {
+		return nil, errors.Wrap(err, "reading config file")
+	}
+	return conf, nil
+}
+
+// applyUserConfig sets flags of the command from the user-level config file, unless they are set explicitly.
+// config.RepoKeys are not set here, but resolved per repository by resolveRepoOptions,
+// so that each value of the config files is applied only once.
+func applyUserConfig(cmd *cobra.Command) error {
+	cmd.Flags().Visit(func(f *pflag.Flag) { explicitFlags[f.Name] = true })
+	if cmd == lspCmd {
+		// Language server reads config files per repository, see resolveRepoOptions
+		return nil
+	}
+	conf, err := readProjectConfig("")
+	if err != nil {
+		return err
+	}
+	return conf.Apply(cmd.Flags(), func(name string) bool {
+		return explicitFlags[name] || slices.Contains(config.RepoKeys, name)
+	})
+}
+
+// repoOptions are options which can be configured per repository by the project config file.
+type repoOptions struct {
+	algorithm            string
+	algorithmParams      map[string]string
+	detectMicro          bool
+	snapBoundaries       bool
+	disableDefaultIgnore bool
+	ignores              []string
+	includes             []string
+	ignoreConfigs        []*domain.IgnoreConfig
+}
+
+// resolveRepoOptions resolves options from flags and the project config files of the repository.
+// Flags set explicitly take precedence over the config files.
+// This is the only place the repository config file is read and applied.
+func resolveRepoOptions(repoDir string) (*repoOptions, error) {
+	conf, err := readProjectConfig(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		o      repoOptions
+		params []string
+	)
+	fs := pflag.NewFlagSet("repo", pflag.ContinueOnError)
+	fs.StringVar(&o.algorithm, "algorithm", algorithm, "")
+	fs.StringArrayVar(&params, "algorithm-param", algorithmParam, "")
+	fs.BoolVar(&o.detectMicro, "micro", detectMicro, "")
+	fs.BoolVar(&o.snapBoundaries, "snap-boundaries", snapBoundaries, "")
+	fs.BoolVar(&o.disableDefaultIgnore, "disable-default-ignore", disableDefaultIgnore, "")
+	fs.StringArrayVar(&o.ignores, "ignore", ignoreCLIOptions, "")
+	fs.StringArrayVar(&o.includes, "include", includeCLIOptions, "")
+	if err = conf.Apply(fs, func(name string) bool { return explicitFlags[name] }); err != nil {
+		return nil, err
+	}
+	o.ignoreConfigs = conf.Ignores()
+
+	// Parameters given by --algorithm-param override those in the config file
+	cliParams, err := parseAlgorithmParams(params)
+	if err != nil {
+		return nil, err
+	}
+	o.algorithmParams = make(map[string]string)
+	maps.Copy(o.algorithmParams, conf.AlgorithmParams(o.algorithm))
+	maps.Copy(o.algorithmParams, cliParams)
+	if err = search.ValidateParams(o.algorithm, o.algorithmParams); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
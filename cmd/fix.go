@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/fix"
+	"github.com/salab/iccheck/pkg/utils/cli"
+)
+
+var fixCmd = &cobra.Command{
+	Use:   "fix",
+	Short: "Applies changes to clones missing consistent changes",
+	Long: fmt.Sprintf(`ICCheck %v
+fix propagates changes to clones which are likely missing consistent changes.
+The change of a changed clone is transplanted onto each missing clone in the same clone set,
+aligning lines and adjusting indentation.
+
+Changes are written to the target tree (worktree, or --to-dir) by default.
+With --dry-run, a unified diff is printed instead, which can be applied by 'git apply'.
+Missing clones whose surrounding lines differ too much from the changed clone are left as is.`, cli.GetFormattedVersion()),
+	Version:      cli.GetFormattedVersion(),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeoutSeconds))
+		defer cancel()
+
+		// Prepare
+		if err := setLogLevel(); err != nil {
+			return err
+		}
+		ch, err := resolveChanges()
+		if err != nil {
+			return err
+		}
+		var writeDir string
+		if !fixDryRun {
+			writeDir, err = fixWriteDir(ch)
+			if err != nil {
+				return err
+			}
+		}
+		analyzer, _, err := repoAnalyzer(ch.configDir)
+		if err != nil {
+			return err
+		}
+
+		// Search for inconsistent changes
+		result, err := analyzer.Analyze(ctx, ch.Changes)
+		if err != nil {
+			return err
+		}
+		cloneSets := result.CloneSets
+
+		// Suggest changes
+		suggester, err := ch.Suggester()
+		if err != nil {
+			return err
+		}
+		suggestions, err := suggester.Suggest(cloneSets)
+		if err != nil {
+			return errors.Wrap(err, "suggesting changes")
+		}
+		missingChanges := lo.SumBy(cloneSets, func(set *domain.CloneSet) int { return len(set.Missing) })
+		slog.Info(fmt.Sprintf("Changes could be suggested for %d out of %d clone(s) missing consistent change.", len(suggestions), missingChanges))
+
+		if fixInteractive {
+			suggestions, err = selectSuggestions(suggestions)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Output or apply the changes
+		if fixDryRun {
+			patch, skipped, err := suggester.Patch(suggestions)
+			if err != nil {
+				return err
+			}
+			logSkippedSuggestions(skipped)
+			fmt.Print(patch)
+			return nil
+		}
+		contents, skipped, err := suggester.Apply(suggestions)
+		if err != nil {
+			return err
+		}
+		logSkippedSuggestions(skipped)
+		for filename, content := range contents {
+			path := filepath.Join(writeDir, filename)
+			stat, err := os.Stat(path)
+			if err != nil {
+				return errors.Wrapf(err, "checking %v", path)
+			}
+			if err = os.WriteFile(path, content, stat.Mode()); err != nil {
+				return errors.Wrapf(err, "writing %v", path)
+			}
+		}
+		slog.Info(fmt.Sprintf("Applied changes to %d clone(s) in %d file(s).", len(suggestions)-len(skipped), len(contents)))
+		return nil
+	},
+}
+
+var (
+	fixDryRun      bool
+	fixInteractive bool
+)
+
+func init() {
+	fixCmd.Flags().BoolVar(&fixDryRun, "dry-run", false, `Prints the changes as a unified diff, instead of writing them to files.
+The output can be applied by 'git apply'.`)
+	fixCmd.Flags().BoolVarP(&fixInteractive, "interactive", "i", false, `Asks whether to accept or skip the change for each missing clone.`)
+}
+
+// fixWriteDir determines the directory to write changes to.
+// Changes can only be written to trees backed by the file system.
+func fixWriteDir(ch *changes) (string, error) {
+	if toDir != "" {
+		return toDir, nil
+	}
+	if _, ok := ch.To.(*domain.GoGitWorktree); ok {
+		return ch.configDir, nil
+	}
+	return "", errors.Errorf("cannot write changes to %v, use --dry-run to output a patch instead", ch.To)
+}
+
+// selectSuggestions asks the user whether to accept each suggestion.
+func selectSuggestions(suggestions []*fix.Suggestion) ([]*fix.Suggestion, error) {
+	var accepted []*fix.Suggestion
+	stdin := bufio.NewReader(os.Stdin)
+	for i, sug := range suggestions {
+		fmt.Fprintf(os.Stderr, "\n[%d/%d] %s:%d-%d (following %s:%d-%d)\n%s",
+			i+1, len(suggestions),
+			sug.Missing.Filename, sug.Missing.StartL, sug.Missing.EndL,
+			sug.Template.Filename, sug.Template.StartL, sug.Template.EndL,
+			sug.Patch)
+		answer, err := askChoice(stdin, "Apply this change [y,n,q]? ", "y", "n", "q")
+		if err != nil {
+			return nil, err
+		}
+		switch answer {
+		case "y":
+			accepted = append(accepted, sug)
+		case "q":
+			return accepted, nil
+		}
+	}
+	return accepted, nil
+}
+
+// askChoice repeatedly prompts on stderr until one of the choices is answered.
+func askChoice(r *bufio.Reader, prompt string, choices ...string) (string, error) {
+	for {
+		fmt.Fprint(os.Stderr, prompt)
+		answer, err := r.ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if lo.Contains(choices, answer) {
+			return answer, nil
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "reading answer")
+		}
+	}
+}
+
+func logSkippedSuggestions(skipped []*fix.Suggestion) {
+	for _, sug := range skipped {
+		slog.Warn("Skipped change overlapping with another change", "file", sug.Missing.Filename, "start", sug.Missing.StartL, "end", sug.Missing.EndL)
+	}
+}
+
+// attachSuggestedPatches sets the suggested patch of each missing clone, where available.
+func attachSuggestedPatches(ch *changes, cloneSets []*domain.CloneSet) error {
+	suggester, err := ch.Suggester()
+	if err != nil {
+		return err
+	}
+	suggestions, err := suggester.Suggest(cloneSets)
+	if err != nil {
+		return errors.Wrap(err, "suggesting patches")
+	}
+	for _, sug := range suggestions {
+		sug.Missing.SuggestedPatch = sug.Patch
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+
+	"github.com/salab/iccheck/pkg/history"
+	"github.com/salab/iccheck/pkg/printer"
+	"github.com/salab/iccheck/pkg/utils/cli"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Finds inconsistent changes in each commit of a commit range",
+	Long: fmt.Sprintf(`ICCheck %v
+history finds inconsistent changes in each commit of a commit range, compared to its parent.
+It also reports whether each missing change was fixed by a following commit in the range,
+or is still outstanding at the end of the range.
+--timeout-seconds applies to the analysis of each commit.`, cli.GetFormattedVersion()),
+	Version:      cli.GetFormattedVersion(),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Prepare
+		if err := setLogLevel(); err != nil {
+			return err
+		}
+		repoDir, err := getRepoDir()
+		if err != nil {
+			return err
+		}
+		repo, err := git.PlainOpen(repoDir)
+		if err != nil {
+			return errors.Wrapf(err, "opening repository at %v", repoDir)
+		}
+		analyzer, _, err := repoAnalyzer(repoDir)
+		if err != nil {
+			return err
+		}
+		p, ok := getPrinter().(printer.HistoryPrinter)
+		if !ok {
+			return errors.Errorf("format %v is not supported for history command", formatType)
+		}
+
+		commits, err := history.ListCommits(repo, historyRange, !historyAllCommits)
+		if err != nil {
+			return errors.Wrap(err, "listing commits")
+		}
+		slog.Info(fmt.Sprintf("Analyzing %d commit(s) in %v ...", len(commits), historyRange))
+
+		reports, err := analyzer.AnalyzeHistory(context.Background(), commits, time.Second*time.Duration(timeoutSeconds))
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(string(p.PrintHistory(reports)))
+
+		// If any missing changes are still outstanding, exit with specified code
+		outstanding := lo.SumBy(reports, func(r *history.CommitReport) int {
+			return lo.SumBy(r.CloneSets, (*history.CloneSet).Outstanding)
+		})
+		if outstanding > 0 && failCode != 0 {
+			return &exitCodeError{code: failCode}
+		}
+		return nil
+	},
+}
+
+var (
+	historyRange      string
+	historyAllCommits bool
+)
+
+func init() {
+	historyCmd.Flags().StringVar(&historyRange, "range", "", `Commit range to analyze, in the form of 'from..to' (e.g. v1.2.0..v1.3.0).`)
+	lo.Must0(historyCmd.MarkFlagRequired("range"))
+	historyCmd.Flags().BoolVar(&historyAllCommits, "all", false, `Analyze all non-merge commits in the range, instead of only the first-parent chain.`)
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/sourcegraph/jsonrpc2"
 	"github.com/spf13/cobra"
 
+	"github.com/salab/iccheck/iccheck"
 	"github.com/salab/iccheck/pkg/lsp"
 	"github.com/salab/iccheck/pkg/utils/cli"
 )
@@ -28,9 +29,8 @@ Starts ICCheck Language Server.`, cli.GetFormattedVersion()),
 		// Code is partially copied from https://github.com/vito/bass/blob/main/cmd/bass/lsp.go
 		ctx := context.Background()
 		handler := lsp.NewHandler(
-			algorithm,
 			time.Duration(lspTimeoutSeconds)*time.Second,
-			searchConfig,
+			lspAnalyzer,
 		)
 		// rwc := lo.Must(newSocketRWC(8080))
 		conn := jsonrpc2.NewConn(
@@ -51,6 +51,12 @@ func init() {
 	lspCmd.Flags().IntVar(&lspTimeoutSeconds, "timeout-seconds", 15, "Timeout for detecting clones in seconds (default: 15)")
 }
 
+// lspAnalyzer returns the analyzer of the repository, honoring its project config file.
+func lspAnalyzer(repoDir string) (*iccheck.Analyzer, error) {
+	analyzer, _, err := repoAnalyzer(repoDir)
+	return analyzer, err
+}
+
 type stdRWC struct{}
 
 func (stdRWC) Read(p []byte) (int, error) {
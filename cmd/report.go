@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/salab/iccheck/pkg/baseline"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/printer"
+	"github.com/salab/iccheck/pkg/utils/ds"
+)
+
+// runReport prints the results of a run to stdout.
+// With --format ndjson, the header record is printed at the start of the run, before searching.
+type runReport struct {
+	start  time.Time
+	ndjson *printer.NDJSONPrinter // nil unless --format ndjson
+
+	// Trees to read code snippets from, from can be nil
+	from, to domain.Tree
+	// changes are used to print the change of changed clones in code snippets, can be nil
+	changes *changes
+
+	// Printed in the ndjson summary record
+	queries      int
+	changedFiles int
+}
+
+// startReport starts reporting of a run. from can be nil.
+func startReport(command string, from, to domain.Tree, opts *repoOptions) *runReport {
+	r := &runReport{start: time.Now(), from: from, to: to}
+	if formatType == "ndjson" && writeBaselineFile == "" { // Nothing is reported when writing a baseline
+		r.ndjson = printer.NewNDJSONPrinter()
+		fmt.Print(string(r.ndjson.PrintHeader(&printer.RunHeader{
+			Command:         command,
+			From:            from,
+			To:              to,
+			Algorithm:       opts.algorithm,
+			AlgorithmParams: opts.algorithmParams,
+			DetectMicro:     opts.detectMicro,
+			SnapBoundaries:  opts.snapBoundaries,
+			TimeoutSeconds:  timeoutSeconds,
+			OnTimeout:       onTimeout,
+			StartedAt:       r.start,
+		})))
+	}
+	return r
+}
+
+// print prints clone sets. fp is used to calculate stable IDs of clone sets with --format ndjson.
+func (r *runReport) print(cloneSets []*domain.CloneSet, coverage *domain.Coverage, fp *baseline.Fingerprinter) error {
+	if r.ndjson == nil {
+		p, err := r.printer()
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(p.PrintClones(cloneSets, coverage)))
+		return nil
+	}
+
+	fingerprints, err := ds.MapError(cloneSets, fp.Fingerprints)
+	if err != nil {
+		return errors.Wrap(err, "calculating clone set IDs")
+	}
+	fmt.Print(string(r.ndjson.PrintCloneSets(cloneSets, fingerprints)))
+	fmt.Print(string(r.ndjson.PrintSummary(&printer.RunSummary{
+		Queries:      r.queries,
+		ChangedFiles: r.changedFiles,
+		Duration:     time.Since(r.start),
+		Coverage:     coverage,
+	})))
+	return nil
+}
+
+// printer returns the printer for --format, printing code snippets in console format if enabled.
+func (r *runReport) printer() (printer.Printer, error) {
+	if formatType != "console" {
+		return getPrinter(), nil
+	}
+	showSnippets, err := resolveAutoFlag("snippets", snippets, stdoutIsTerminal)
+	if err != nil {
+		return nil, err
+	}
+	if !showSnippets {
+		return getPrinter(), nil
+	}
+	useColor, err := resolveAutoFlag("color", color, func() bool {
+		return stdoutIsTerminal() && os.Getenv("NO_COLOR") == ""
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &printer.SnippetOptions{
+		From:    r.from,
+		To:      r.to,
+		Context: max(0, contextLines),
+		Color:   useColor,
+		Width:   terminalWidth(),
+	}
+	if r.changes != nil {
+		filePatches, err := r.changes.FilePatches()
+		if err != nil {
+			return nil, err
+		}
+		opts.Changes = printer.PatchChanges(filePatches, r.changes.To)
+	}
+	return printer.NewConsoleSnippetPrinter(opts), nil
+}
@@ -19,6 +19,8 @@ import (
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 
+	"github.com/salab/iccheck/iccheck"
+	"github.com/salab/iccheck/pkg/baseline"
 	"github.com/salab/iccheck/pkg/domain"
 	"github.com/salab/iccheck/pkg/printer"
 	"github.com/salab/iccheck/pkg/search"
@@ -33,6 +35,8 @@ var RootCmd = &cobra.Command{
 Finds inconsistent changes in your git changes.`, cli.GetFormattedVersion()),
 	Version:      cli.GetFormattedVersion(),
 	SilenceUsage: true,
+	// Errors are printed by Execute, except for exitCodeError
+	SilenceErrors: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeoutSeconds))
 		defer cancel()
@@ -41,55 +45,59 @@ Finds inconsistent changes in your git changes.`, cli.GetFormattedVersion()),
 		if err := setLogLevel(); err != nil {
 			return err
 		}
-		repoDir, err := getRepoDir()
+		ch, err := resolveChanges()
 		if err != nil {
 			return err
 		}
-		repo, err := git.PlainOpen(repoDir)
-		if err != nil {
-			return errors.Wrapf(err, "opening repository at %v", repoDir)
-		}
-		searchConf, err := searchConfig(repoDir)
+		analyzer, opts, err := repoAnalyzer(ch.configDir)
 		if err != nil {
 			return err
 		}
 
-		if fromRef == "" && toRef != "" {
-			// Only --to ref was given - a reasonable default would be to compare from parent of that ref.
-			fromRef = toRef + "^"
-		} else if fromRef != "" && toRef == "" {
-			return errors.New("only one of --from was set, this is invalid - do not set for automatic discovery or set both")
-		} else if fromRef == "" && toRef == "" {
-			fromRef, toRef, err = autoDetermineRefs(repo)
-			if err != nil {
-				return errors.Wrapf(err, "determining refs")
-			}
-		}
-
-		fromTree, err := resolveTree(repo, fromRef)
-		if err != nil {
-			return errors.Wrap(err, "resolving base tree")
-		}
-		toTree, err := resolveTree(repo, toRef)
-		if err != nil {
-			return errors.Wrap(err, "resolving target tree")
-		}
+		report := startReport("iccheck", ch.From, ch.To, opts)
+		report.changes = ch
 
 		// Search for inconsistent changes
-		queries, changedFiles, err := search.DiffTrees(ctx, fromTree, toTree)
+		result, err := analyzer.Analyze(ctx, ch.Changes)
 		if err != nil {
 			return err
 		}
-		slog.Info(fmt.Sprintf("%d changed text chunk(s) were found within %d changed file(s).", len(queries), changedFiles), "from", fromTree, "to", toTree)
-		for i, q := range queries {
-			slog.Debug(fmt.Sprintf("Query#%d", i), "query", q)
+		report.queries, report.changedFiles = result.Queries, result.ChangedFiles
+		coverage := result.Coverage
+
+		// Baseline
+		// If all clones in a set went through some changes, no need to record or notify
+		cloneSets := result.Inconsistent()
+		fingerprinter := baseline.NewFingerprinter(ch.From, ch.To)
+		if writeBaselineFile != "" {
+			if coverage != nil {
+				return errors.Errorf("refusing to write baseline from incomplete results (%v), try increasing --timeout-seconds", coverage)
+			}
+			if err = baseline.Write(writeBaselineFile, cloneSets, fingerprinter); err != nil {
+				return err
+			}
+			slog.Info(fmt.Sprintf("Wrote %d clone set(s) to baseline file %v.", len(cloneSets), writeBaselineFile))
+			return nil
 		}
-		cloneSets, err := search.Search(ctx, algorithm, queries, toTree, searchConf)
-		if err != nil {
-			return err
+		if baselineFile != "" {
+			bl, err := baseline.Read(baselineFile)
+			if err != nil {
+				return err
+			}
+			allSets := len(cloneSets)
+			cloneSets, err = bl.Filter(cloneSets, fingerprinter)
+			if err != nil {
+				return errors.Wrap(err, "filtering clone sets with baseline")
+			}
+			slog.Info(fmt.Sprintf("%d clone set(s) were suppressed by baseline %v.", allSets-len(cloneSets), baselineFile))
+		}
+		if suggestPatch {
+			if err = attachSuggestedPatches(ch, cloneSets); err != nil {
+				return err
+			}
 		}
-		reportClones(cloneSets)
-		return nil
+
+		return reportClones(report, cloneSets, coverage, fingerprinter)
 	},
 }
 
@@ -97,14 +105,43 @@ Finds inconsistent changes in your git changes.`, cli.GetFormattedVersion()),
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	err := RootCmd.Execute()
+	var exitErr *exitCodeError
+	if errors.As(err, &exitErr) {
+		exit(exitErr.code)
+	}
 	if err != nil {
-		os.Exit(1)
+		RootCmd.PrintErrln("Error:", err.Error())
+		exit(1)
 	}
+	pruneDiskCache()
+}
+
+// exitCodeError is returned by commands to exit with the code after printing results,
+// such as --fail-code when inconsistent changes are found.
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
 }
 
 var (
-	fromRef string
-	toRef   string
+	fromRef    string
+	toRef      string
+	stagedOnly bool
+
+	fromDir string
+	toDir   string
+
+	patchFile  string
+	patchStrip int
+
+	searchDeleted bool
+
+	baselineFile      string
+	writeBaselineFile string
+	suggestPatch      bool
 )
 
 var (
@@ -112,6 +149,11 @@ var (
 	formatType     string
 	failCode       int
 	timeoutSeconds int
+	onTimeout      string
+
+	snippets     string
+	color        string
+	contextLines int
 )
 
 var (
@@ -123,17 +165,52 @@ var (
 	disableDefaultIgnore bool
 	includeCLIOptions    []string
 
-	detectMicro bool
+	detectMicro    bool
+	snapBoundaries bool
 )
 
-func searchConfig(repoDir string) (*search.Config, error) {
-	ignore, err := readIgnoreRules(repoDir)
+// repoAnalyzer returns the analyzer configured by flags and the project config file of the repository.
+func repoAnalyzer(repoDir string) (*iccheck.Analyzer, *repoOptions, error) {
+	opts, err := resolveRepoOptions(repoDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	analyzer, err := newAnalyzer(repoDir, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return analyzer, opts, nil
+}
+
+func newAnalyzer(repoDir string, opts *repoOptions) (*iccheck.Analyzer, error) {
+	ignore, err := readIgnoreRules(repoDir, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	if onTimeout != "fail" && onTimeout != "partial" {
+		return nil, errors.Errorf("invalid --on-timeout value %q, expected fail or partial", onTimeout)
+	}
+
+	cache, err := getDiskCache()
+	if err != nil {
+		slog.Warn("Persistent cache is disabled", "err", err)
+	}
+
+	return iccheck.NewAnalyzer(
+		iccheck.WithAlgorithm(opts.algorithm, opts.algorithmParams),
+		iccheck.WithMatcher(ignore),
+		iccheck.WithDiskCache(cache),
+		iccheck.WithDetectMicro(opts.detectMicro),
+		iccheck.WithSnapBoundaries(opts.snapBoundaries),
+		iccheck.WithPartialResults(onTimeout == "partial"),
+		iccheck.WithSearchDeleted(searchDeleted),
+	)
+}
+
+func parseAlgorithmParams(strs []string) (map[string]string, error) {
 	params := make(map[string]string)
-	for _, str := range algorithmParam {
+	for _, str := range strs {
 		if str == "" {
 			continue // Empty str is included for some reason
 		}
@@ -144,35 +221,66 @@ func searchConfig(repoDir string) (*search.Config, error) {
 		key, value := parts[0], parts[1]
 		params[key] = value
 	}
-
-	return &search.Config{
-		Matcher:     ignore,
-		DetectMicro: detectMicro,
-		AlgoParams:  params,
-	}, nil
+	return params, nil
 }
 
 func init() {
+	// Common to root command and "fix" command
+	changeFlags := pflag.NewFlagSet("changes", pflag.ContinueOnError)
+	changeFlags.StringVarP(&fromRef, "from", "f", "", "Base git ref to compare against. Usually earlier in time.")
+	changeFlags.StringVarP(&toRef, "to", "t", "", `Target git ref to compare from. Usually later in time.
+Can accept special value "WORKTREE" to specify the current worktree,
+or "INDEX" to specify the staged contents of the git index.`)
+	changeFlags.BoolVar(&stagedOnly, "staged", false, `Only check staged changes, ignoring unstaged worktree edits.
+Equivalent to --from HEAD --to INDEX. Useful in pre-commit hooks.`)
+	changeFlags.StringVar(&fromDir, "from-dir", "", `Base directory to compare against, instead of a git ref.
+Does not need to be a git repository. Must be set together with --to-dir.`)
+	changeFlags.StringVar(&toDir, "to-dir", "", `Target directory to compare from, instead of a git ref.
+Does not need to be a git repository. Must be set together with --from-dir.`)
+	changeFlags.StringVar(&patchFile, "patch", "", `Unified diff file (such as output of 'git diff') to use as changes, instead of comparing two trees.
+Specify "-" to read from stdin. The patch is expected to be already applied to the target (--to or --to-dir, default: WORKTREE).`)
+	changeFlags.IntVar(&patchStrip, "patch-strip", 1, `Number of leading path components to strip from file paths in --patch, like 'patch -p'.
+The default works for patches produced by git.`)
+	RootCmd.Flags().AddFlagSet(changeFlags)
+	fixCmd.Flags().AddFlagSet(changeFlags)
+
 	// Root command specific
-	RootCmd.Flags().StringVarP(&fromRef, "from", "f", "", "Base git ref to compare against. Usually earlier in time.")
-	RootCmd.Flags().StringVarP(&toRef, "to", "t", "", `Target git ref to compare from. Usually later in time.
-Can accept special value "WORKTREE" to specify the current worktree.`)
+	RootCmd.Flags().BoolVar(&searchDeleted, "search-deleted", false, `Also search for copies of deleted code (and deleted files) still remaining in the target tree.`)
+	RootCmd.Flags().StringVar(&baselineFile, "baseline", "", `Baseline file of already-known clone sets, created by --write-baseline.
+Only missing changes not in the baseline are reported (and trigger --fail-code).`)
+	RootCmd.Flags().StringVar(&writeBaselineFile, "write-baseline", "", `Writes all detected clone sets to the given baseline file and exits, instead of reporting them.`)
+	RootCmd.Flags().BoolVar(&suggestPatch, "suggest-patch", false, `Suggests a patch for each missing clone, by applying the change of its changed clone (json format only).
+See also "iccheck fix" command.`)
 
 	// Common to root command and "search" command
 	searchFlags := pflag.NewFlagSet("search", pflag.ContinueOnError)
 	searchFlags.StringVarP(&repoDir, "repo", "r", "", "Source git directory (supports bare)")
-	searchFlags.StringVar(&formatType, "format", "console", "Format type (console, json, github)")
+	searchFlags.StringVar(&formatType, "format", "console", "Format type (console, json, ndjson, github, sarif)")
 	searchFlags.IntVar(&failCode, "fail-code", 0, "Exit code if it detects any inconsistent changes")
 	searchFlags.IntVar(&timeoutSeconds, "timeout-seconds", 60, "Timeout for detecting clones in seconds")
+	searchFlags.StringVar(&onTimeout, "on-timeout", "fail", `Behavior when --timeout-seconds expires (fail, partial).
+"partial" reports clones found in the files searched so far, and marks the report as incomplete.`)
+	searchFlags.StringVar(&snippets, "snippets", "auto", `Whether to print code snippets of clones in console format (auto, always, never).
+Changes of changed clones are printed side by side with missing clones. "auto" prints snippets if stdout is a terminal.`)
+	searchFlags.StringVar(&color, "color", "auto", `Whether to color code snippets in console format (auto, always, never).
+"auto" colors snippets if stdout is a terminal and NO_COLOR env is not set.`)
+	searchFlags.IntVar(&contextLines, "context", 3, "Number of lines to print before and after each clone in code snippets")
 
 	RootCmd.Flags().AddFlagSet(searchFlags)
 	searchCmd.Flags().AddFlagSet(searchFlags)
+	historyCmd.Flags().AddFlagSet(searchFlags)
+	clonesCmd.Flags().AddFlagSet(searchFlags)
+	fixCmd.Flags().AddFlag(searchFlags.Lookup("repo"))
+	fixCmd.Flags().AddFlag(searchFlags.Lookup("timeout-seconds"))
 
 	// Common to all commands
 	pfs := RootCmd.PersistentFlags()
 	pfs.StringVar(&logLevel, "log-level", "", "Log level (debug, info, warn, error)")
-	pfs.StringVar(&algorithm, "algorithm", "fleccs", "Clone search algorithm to use (fleccs, ncdsearch)")
-	pfs.StringArrayVar(&algorithmParam, "algorithm-param", nil, "(Advanced) Parameters of the algorithm, consult code for syntax.")
+	pfs.StringVar(&algorithm, "algorithm", "fleccs", fmt.Sprintf(`Clone search algorithm to use (%s).
+Specify "%s<path>" to run an external algorithm, see README for the protocol.`, strings.Join(search.AlgorithmNames(), ", "), search.ExecPrefix))
+	pfs.StringArrayVar(&algorithmParam, "algorithm-param", nil, `(Advanced) Parameter of the algorithm in 'name=value' form.
+Run 'iccheck algorithms' to list available parameters.
+Example: --algorithm-param threshold=0.8`)
 
 	pfs.StringArrayVar(&ignoreCLIOptions, "ignore", nil, `Regexp of file paths (and its contents) to ignore.
 If specifying both file paths and contents ignore regexp, split them by ':'.
@@ -184,6 +292,11 @@ If specified, only matching files will be considered.
 Example (include only src directory): --include '^src/'`)
 
 	pfs.BoolVar(&detectMicro, "micro", false, "Splits query to detect micro-clones (has performance implications!)")
+	pfs.StringVar(&cacheDir, "cache-dir", "", `Directory of the persistent cache (default: $XDG_CACHE_HOME/iccheck, or the OS-specific user cache directory).`)
+	pfs.IntVar(&cacheMaxSizeMB, "cache-max-size", 1024, "Maximum size of the persistent cache in megabytes")
+	pfs.BoolVar(&noCache, "no-cache", false, "Disables the persistent cache")
+	pfs.BoolVar(&snapBoundaries, "snap-boundaries", false, `Expands or shrinks detected clones to enclosing blocks (functions, if statements, ...),
+so that they do not cut blocks in half. Uses bracket and indentation heuristics.`)
 
 	// Disable "completion" command
 	RootCmd.CompletionOptions.DisableDefaultCmd = true
@@ -191,6 +304,11 @@ Example (include only src directory): --include '^src/'`)
 	// Add child commands
 	RootCmd.AddCommand(lspCmd)
 	RootCmd.AddCommand(searchCmd)
+	RootCmd.AddCommand(historyCmd)
+	RootCmd.AddCommand(clonesCmd)
+	RootCmd.AddCommand(fixCmd)
+	RootCmd.AddCommand(cacheCmd)
+	RootCmd.AddCommand(algorithmsCmd)
 
 	// Automatic env from viper
 	// see: https://github.com/spf13/viper/issues/397
@@ -220,8 +338,12 @@ func presetRequiredFlags(cmd *cobra.Command) {
 	})
 }
 
+func stdoutIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
 func autoDetermineLogLevel() string {
-	if term.IsTerminal(int(os.Stdout.Fd())) {
+	if stdoutIsTerminal() {
 		return "info"
 	} else {
 		// Suppress verbose logging messages by default, if output is not a tty - likely a pipe or a file.
@@ -248,8 +370,8 @@ func setLogLevel() error {
 	return nil
 }
 
-func readIgnoreRules(repoDir string) (*domain.MatcherRules, error) {
-	ignore, err := domain.ReadMatcherRules(repoDir, disableDefaultIgnore, ignoreCLIOptions, includeCLIOptions)
+func readIgnoreRules(repoDir string, opts *repoOptions) (*domain.MatcherRules, error) {
+	ignore, err := domain.ReadMatcherRulesWithConfigs(repoDir, opts.disableDefaultIgnore, opts.ignoreConfigs, opts.ignores, opts.includes)
 	if err != nil {
 		return nil, errors.Wrapf(err, "reading ignore rules")
 	}
@@ -287,6 +409,88 @@ func getRepoDir() (string, error) {
 	return repoDir, nil
 }
 
+func resolvePatchTargetTree() (toTree domain.Tree, configDir string, err error) {
+	if fromRef != "" || fromDir != "" || stagedOnly || searchDeleted {
+		return nil, "", errors.New("--patch cannot be used together with --from, --from-dir, --staged or --search-deleted")
+	}
+	if toDir != "" {
+		toTree, err = domain.NewFileSystemTree(toDir)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "resolving target directory")
+		}
+		return toTree, toDir, nil
+	}
+
+	repoDir, err := getRepoDir()
+	if err != nil {
+		return nil, "", err
+	}
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "opening repository at %v", repoDir)
+	}
+	// The patch is usually already applied to the current worktree
+	ref := lo.Ternary(toRef != "", toRef, iccheck.WorktreeRef)
+	toTree, err = iccheck.ResolveTree(repo, ref)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "resolving target tree")
+	}
+	return toTree, repoDir, nil
+}
+
+func resolveDirTrees() (fromTree, toTree domain.Tree, err error) {
+	if fromDir == "" || toDir == "" {
+		return nil, nil, errors.New("--from-dir and --to-dir must be set together")
+	}
+	if fromRef != "" || toRef != "" || stagedOnly {
+		return nil, nil, errors.New("--from-dir and --to-dir cannot be used together with --from, --to or --staged")
+	}
+	fromTree, err = domain.NewFileSystemTree(fromDir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "resolving base directory")
+	}
+	toTree, err = domain.NewFileSystemTree(toDir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "resolving target directory")
+	}
+	return fromTree, toTree, nil
+}
+
+func resolveGitTrees(repoDir string) (fromTree, toTree domain.Tree, err error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "opening repository at %v", repoDir)
+	}
+
+	if stagedOnly {
+		if fromRef != "" || toRef != "" {
+			return nil, nil, errors.New("--staged cannot be used together with --from or --to")
+		}
+		// Pre-commit mode: only consider changes that are about to be committed.
+		fromRef, toRef = "HEAD", iccheck.IndexRef
+	} else if fromRef == "" && toRef != "" {
+		// Only --to ref was given - a reasonable default would be to compare from parent of that ref.
+		fromRef = toRef + "^"
+	} else if fromRef != "" && toRef == "" {
+		return nil, nil, errors.New("only one of --from was set, this is invalid - do not set for automatic discovery or set both")
+	} else if fromRef == "" && toRef == "" {
+		fromRef, toRef, err = autoDetermineRefs(repo)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "determining refs")
+		}
+	}
+
+	fromTree, err = iccheck.ResolveTree(repo, fromRef)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "resolving base tree")
+	}
+	toTree, err = iccheck.ResolveTree(repo, toRef)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "resolving target tree")
+	}
+	return fromTree, toTree, nil
+}
+
 func autoDetermineRefs(repo *git.Repository) (from, to string, err error) {
 	_, wtErr := repo.Worktree()
 	isBare := errors.Is(wtErr, git.ErrIsBareRepository)
@@ -303,7 +507,7 @@ func autoDetermineRefs(repo *git.Repository) (from, to string, err error) {
 		}
 		if !st.IsClean() {
 			// If there are local changes, then a reasonable default would be from HEAD to the current worktree.
-			return "HEAD", worktreeRef, nil
+			return "HEAD", iccheck.WorktreeRef, nil
 		}
 	}
 
@@ -364,27 +568,7 @@ func determineDefaultBranch(repo *git.Repository) (string, error) {
 	return "", errors.New("origin did not contain HEAD symbolic ref, something could be off here?")
 }
 
-const worktreeRef = "WORKTREE"
-
-func resolveTree(repo *git.Repository, ref string) (domain.Tree, error) {
-	// Special refs
-	if ref == worktreeRef {
-		return domain.NewGoGitWorkTree(repo)
-	}
-
-	// Normal git ref
-	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
-	if err != nil {
-		return nil, errors.Wrapf(err, "resolving hash revision from %v", ref)
-	}
-	commit, err := repo.CommitObject(*hash)
-	if err != nil {
-		return nil, errors.Wrapf(err, "resolving commit from hash %v", *hash)
-	}
-	return domain.NewGoGitCommitTree(commit, ref), nil
-}
-
-func reportClones(cloneSets []*domain.CloneSet) {
+func reportClones(report *runReport, cloneSets []*domain.CloneSet, coverage *domain.Coverage, fp *baseline.Fingerprinter) error {
 	// If all clones in a set went through some changes, no need to notify
 	cloneSets = lo.Filter(cloneSets, func(cs *domain.CloneSet, _ int) bool { return len(cs.Missing) > 0 })
 
@@ -396,14 +580,38 @@ func reportClones(cloneSets []*domain.CloneSet) {
 		slog.Info(fmt.Sprintf("%d clone(s) are likely missing consistent change.", missingChanges))
 	}
 
-	printer := getPrinter()
-	out := printer.PrintClones(cloneSets)
-	fmt.Print(string(out))
+	if err := report.print(cloneSets, coverage, fp); err != nil {
+		return err
+	}
 
 	// If any inconsistent changes are found, exit with specified code
 	if len(cloneSets) > 0 && failCode != 0 {
-		os.Exit(failCode)
+		return &exitCodeError{code: failCode}
+	}
+	return nil
+}
+
+// resolveAutoFlag resolves flag values of "auto", "always" or "never".
+func resolveAutoFlag(name, value string, auto func() bool) (bool, error) {
+	switch value {
+	case "auto":
+		return auto(), nil
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	default:
+		return false, errors.Errorf("invalid --%v value %q, expected auto, always or never", name, value)
+	}
+}
+
+// terminalWidth returns the width of the terminal, or a reasonable default if stdout is not a terminal.
+func terminalWidth() int {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		return 160
 	}
+	return width
 }
 
 func getPrinter() printer.Printer {
@@ -414,6 +622,10 @@ func getPrinter() printer.Printer {
 		return printer.NewJsonPrinter()
 	case "github":
 		return printer.NewGitHubPrinter()
+	case "sarif":
+		return printer.NewSarifPrinter()
+	case "ndjson":
+		return printer.NewNDJSONPrinter()
 	default:
 		panic(fmt.Sprintf("unknown format type: %s", formatType))
 	}
@@ -5,8 +5,9 @@ import (
 	"fmt"
 	"github.com/go-git/go-git/v5"
 	"github.com/pkg/errors"
+	"github.com/salab/iccheck/iccheck"
+	"github.com/salab/iccheck/pkg/baseline"
 	"github.com/salab/iccheck/pkg/domain"
-	"github.com/salab/iccheck/pkg/search"
 	"github.com/salab/iccheck/pkg/utils/cli"
 	"github.com/salab/iccheck/pkg/utils/files"
 	"github.com/samber/lo"
@@ -39,12 +40,12 @@ search is a low-level command to search for code clones.
 		if err != nil {
 			return errors.Wrapf(err, "opening repository at %v", repoDir)
 		}
-		searchConf, err := searchConfig(repoDir)
+		analyzer, opts, err := repoAnalyzer(repoDir)
 		if err != nil {
 			return err
 		}
 
-		searchTree, err := resolveTree(repo, searchRef)
+		searchTree, err := iccheck.ResolveTree(repo, searchRef)
 		if err != nil {
 			return errors.Wrapf(err, "resolving search tree")
 		}
@@ -74,12 +75,13 @@ search is a low-level command to search for code clones.
 		}
 
 		// Search for clones and report
-		cloneSets, err := search.Search(ctx, algorithm, []*domain.Source{query}, searchTree, searchConf)
+		report := startReport("search", nil, searchTree, opts)
+		report.queries = 1
+		result, err := analyzer.Search(ctx, []*domain.Source{query}, searchTree)
 		if err != nil {
 			return err
 		}
-		reportClones(cloneSets)
-		return nil
+		return reportClones(report, result.CloneSets, result.Coverage, baseline.NewFingerprinter(nil, searchTree))
 	},
 }
 
@@ -95,7 +97,8 @@ func init() {
 	// NOTE: We could allow searching raw directories via any query file (even outside the search tree),
 	// but unless we find a use-case it may not be worth implementing.
 	searchCmd.Flags().StringVar(&searchRef, "ref", "HEAD", `Git ref to search against.
-Can accept special value "WORKTREE" to specify the current worktree.`)
+Can accept special value "WORKTREE" to specify the current worktree,
+or "INDEX" to specify the staged contents of the git index.`)
 
 	searchCmd.Flags().StringVar(&queryFile, "file", "", "Path to query file (relative to git root).")
 	lo.Must0(searchCmd.MarkFlagRequired("file"))
@@ -0,0 +1,21 @@
+package iccheck
+
+import (
+	"github.com/salab/iccheck/pkg/search"
+)
+
+// Algorithm is a clone search algorithm, with its parameters and search function.
+type Algorithm = search.Algorithm
+
+// AlgorithmFunc searches for clones of queries in the search tree.
+type AlgorithmFunc = search.AlgorithmFunc
+
+// RegisterAlgorithm registers a third-party clone search algorithm,
+// which can then be selected by WithAlgorithm, --algorithm, or config files.
+// Algorithms are usually registered in init functions of the embedding program.
+//
+// Returned clones go through the same deduplication, grouping into clone sets, and missing change detection
+// as built-in algorithms. It returns an error if the name is already registered, or the parameters are invalid.
+func RegisterAlgorithm(a *Algorithm) error {
+	return search.RegisterAlgorithm(a)
+}
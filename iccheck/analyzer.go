@@ -0,0 +1,168 @@
+// Package iccheck is the Go API of ICCheck, which finds inconsistent changes:
+// clones of changed code which are likely missing the same change.
+// The command line interface and the language server are built on this package.
+//
+// A minimal example:
+//
+//	analyzer, err := iccheck.NewAnalyzer(iccheck.WithAlgorithm("token", nil))
+//	if err != nil { ... }
+//	changes, err := iccheck.RepoChanges(".", "main", iccheck.WorktreeRef)
+//	if err != nil { ... }
+//	result, err := analyzer.Analyze(ctx, changes)
+//	if err != nil { ... }
+//	for _, set := range result.Inconsistent() { ... }
+package iccheck
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/history"
+	"github.com/salab/iccheck/pkg/search"
+)
+
+// Analyzer finds inconsistent changes with the configured algorithm.
+// An Analyzer can be used concurrently, and reused for multiple analyses.
+type Analyzer struct {
+	algorithm     string
+	searchDeleted bool
+	conf          *search.Config
+}
+
+// NewAnalyzer returns an analyzer configured by the options.
+// Returns an error if the algorithm or its parameters are invalid.
+func NewAnalyzer(options ...ConfigFunc) (*Analyzer, error) {
+	c := applyConfig(options...)
+	if err := search.ValidateParams(c.algorithm, c.algoParams); err != nil {
+		return nil, err
+	}
+	if c.matcher == nil {
+		c.matcher = domain.DefaultMatcherRules()
+	}
+	return &Analyzer{
+		algorithm:     c.algorithm,
+		searchDeleted: c.searchDeleted,
+		conf: &search.Config{
+			Cache:          c.diskCache,
+			Matcher:        c.matcher,
+			DetectMicro:    c.detectMicro,
+			SnapBoundaries: c.snapBoundaries,
+			PartialResults: c.partialResults,
+			AlgoParams:     c.algoParams,
+		},
+	}, nil
+}
+
+// Algorithm returns the name of the clone search algorithm.
+func (a *Analyzer) Algorithm() string {
+	return a.algorithm
+}
+
+// Result is the result of an analysis.
+type Result struct {
+	// CloneSets are the clone sets found, sorted.
+	// For Analyzer.Analyze and Analyzer.Search, these include clone sets whose clones were all changed consistently.
+	CloneSets []*domain.CloneSet
+	// Queries is the number of regions searched for clones.
+	Queries int
+	// ChangedFiles is the number of changed files, for Analyzer.Analyze.
+	ChangedFiles int
+	// Coverage is non-nil if the search was interrupted with WithPartialResults,
+	// and tells how much of the search was completed.
+	Coverage *domain.Coverage
+}
+
+// Inconsistent returns the clone sets with clones missing consistent changes.
+func (r *Result) Inconsistent() []*domain.CloneSet {
+	return lo.Filter(r.CloneSets, func(cs *domain.CloneSet, _ int) bool { return len(cs.Missing) > 0 })
+}
+
+// Complete returns true if the search was not interrupted.
+func (r *Result) Complete() bool {
+	return r.Coverage == nil
+}
+
+// addIncomplete adds the coverage of an incomplete search to the result, and returns the other errors as is.
+func (r *Result) addIncomplete(err error) error {
+	incomplete, ok := domain.AsIncomplete(err)
+	if !ok {
+		return err
+	}
+	if r.Coverage == nil {
+		r.Coverage = &incomplete.Coverage
+	} else {
+		r.Coverage = lo.ToPtr(r.Coverage.Add(incomplete.Coverage))
+	}
+	return nil
+}
+
+// Analyze finds clones of the changed code in the target tree, and which of them are missing the changes.
+func (a *Analyzer) Analyze(ctx context.Context, ch *Changes) (*Result, error) {
+	queries, changedFiles, err := ch.Queries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info(fmt.Sprintf("%d changed text chunk(s) were found within %d changed file(s).", len(queries), changedFiles), "from", lo.Ternary[any](ch.Patch != nil, "patch", ch.From), "to", ch.To)
+	for i, q := range queries {
+		slog.Debug(fmt.Sprintf("Query#%d", i), "query", q)
+	}
+	result := &Result{Queries: len(queries), ChangedFiles: changedFiles}
+	result.CloneSets, err = search.Search(ctx, a.algorithm, queries, ch.To, a.conf)
+	if err = result.addIncomplete(err); err != nil {
+		return nil, err
+	}
+
+	if a.searchDeleted {
+		if ch.From == nil {
+			return nil, errors.New("searching for copies of deleted code requires the base tree, which patches do not have")
+		}
+		filePatches, err := ch.FilePatches()
+		if err != nil {
+			return nil, err
+		}
+		deletedSets, err := search.SearchDeleted(ctx, a.algorithm, filePatches, ch.From, ch.To, a.conf)
+		if err = result.addIncomplete(err); err != nil {
+			return nil, errors.Wrap(err, "searching for remaining copies of deleted code")
+		}
+		result.CloneSets = append(result.CloneSets, deletedSets...)
+	}
+	return result, nil
+}
+
+// Search searches for clones of the given regions of the tree.
+// All clones are regarded as missing consistent changes, except for the queries themselves.
+func (a *Analyzer) Search(ctx context.Context, queries []*domain.Source, tree domain.Tree) (*Result, error) {
+	result := &Result{Queries: len(queries)}
+	var err error
+	result.CloneSets, err = search.Search(ctx, a.algorithm, queries, tree, a.conf)
+	if err = result.addIncomplete(err); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DetectClones detects all clone sets in the tree, without requiring any changes (see search.DetectClones).
+// Clones smaller than minLines lines, or with similarity less than minSimilarity (0 to 1) are excluded.
+func (a *Analyzer) DetectClones(ctx context.Context, tree domain.Tree, minLines int, minSimilarity float64) (*Result, error) {
+	result := &Result{}
+	var err error
+	result.CloneSets, err = search.DetectClones(ctx, a.algorithm, tree, minLines, minSimilarity, a.conf)
+	if err = result.addIncomplete(err); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AnalyzeHistory finds inconsistent changes in each commit compared to its first parent,
+// and whether each missing change was fixed by a following commit (see history.Analyze).
+// timeout is applied to the analysis of each commit.
+func (a *Analyzer) AnalyzeHistory(ctx context.Context, commits []*object.Commit, timeout time.Duration) ([]*history.CommitReport, error) {
+	return history.Analyze(ctx, commits, a.algorithm, timeout, a.conf)
+}
@@ -0,0 +1,78 @@
+package iccheck
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+const testBefore = `package a
+
+func a() {
+	x := get()
+	if x == nil {
+		return
+	}
+	use(x)
+}
+
+func b() {
+	x := get()
+	if x == nil {
+		return
+	}
+	use(x)
+}
+`
+
+func writeTree(t *testing.T, content string) domain.Tree {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestAnalyzer_Analyze(t *testing.T) {
+	from := writeTree(t, testBefore)
+	to := writeTree(t, strings.Replace(testBefore, "if x == nil {", "if x == nil || x.Empty() {", 1))
+
+	analyzer, err := NewAnalyzer(WithAlgorithm("token", nil), WithDetectMicro(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := analyzer.Analyze(context.Background(), TreeChanges(from, to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ChangedFiles != 1 || !result.Complete() {
+		t.Errorf("unexpected result: changed files = %d, coverage = %v", result.ChangedFiles, result.Coverage)
+	}
+
+	sets := result.Inconsistent()
+	if len(sets) != 1 {
+		t.Fatalf("expected 1 inconsistent clone set, got %d", len(sets))
+	}
+	missing := sets[0].Missing
+	if len(missing) != 1 || missing[0].StartL < 11 {
+		t.Errorf("expected clone in b() to be missing the change, got %v", missing)
+	}
+}
+
+func TestNewAnalyzer_Invalid(t *testing.T) {
+	if _, err := NewAnalyzer(WithAlgorithm("foo", nil)); err == nil {
+		t.Errorf("expected error for unknown algorithm")
+	}
+	if _, err := NewAnalyzer(WithAlgorithm("fleccs", map[string]string{"threshold": "2"})); err == nil {
+		t.Errorf("expected error for out-of-range parameter")
+	}
+}
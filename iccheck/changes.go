@@ -0,0 +1,134 @@
+package iccheck
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/pkg/errors"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/fix"
+	"github.com/salab/iccheck/pkg/search"
+)
+
+// Special refs accepted by ResolveTree, in addition to normal git refs.
+const (
+	// WorktreeRef refers to the current worktree of the repository.
+	WorktreeRef = "WORKTREE"
+	// IndexRef refers to the staged contents of the git index.
+	IndexRef = "INDEX"
+)
+
+// ResolveTree resolves the tree of the git ref, or of the special refs WorktreeRef and IndexRef.
+func ResolveTree(repo *git.Repository, ref string) (domain.Tree, error) {
+	// Special refs
+	switch ref {
+	case WorktreeRef:
+		return domain.NewGoGitWorkTree(repo)
+	case IndexRef:
+		return domain.NewGoGitIndexTree(repo)
+	}
+
+	// Normal git ref
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving hash revision from %v", ref)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving commit from hash %v", *hash)
+	}
+	return domain.NewGoGitCommitTree(commit, ref), nil
+}
+
+// Changes are the changes to check for inconsistencies, from the base tree to the target tree.
+type Changes struct {
+	// From is the base tree. Nil if the changes are given by Patch.
+	From domain.Tree
+	// To is the target tree, which is searched for clones.
+	To domain.Tree
+	// Patch is a unified diff (such as output of 'git diff') already applied to To, used instead of From.
+	Patch []byte
+	// PatchStrip is the number of leading path components to strip from file paths in Patch, like 'patch -p'.
+	PatchStrip int
+
+	// filePatches caches the result of FilePatches, if calculated
+	filePatches     []fdiff.FilePatch
+	filePatchesDone bool
+}
+
+// TreeChanges returns the changes from the base tree to the target tree.
+func TreeChanges(from, to domain.Tree) *Changes {
+	return &Changes{From: from, To: to}
+}
+
+// PatchChanges returns the changes of the unified diff, which is already applied to the target tree.
+func PatchChanges(patch []byte, strip int, to domain.Tree) *Changes {
+	return &Changes{To: to, Patch: patch, PatchStrip: strip}
+}
+
+// RepoChanges opens the git repository at repoDir, and returns the changes from fromRef to toRef.
+// Refs are resolved by ResolveTree.
+func RepoChanges(repoDir, fromRef, toRef string) (*Changes, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening repository at %v", repoDir)
+	}
+	from, err := ResolveTree(repo, fromRef)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving base tree")
+	}
+	to, err := ResolveTree(repo, toRef)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving target tree")
+	}
+	return TreeChanges(from, to), nil
+}
+
+// Queries calculates search queries from the changed regions in the target tree,
+// and returns them with the number of changed files.
+func (ch *Changes) Queries(ctx context.Context) ([]*domain.Source, int, error) {
+	if ch.Patch != nil {
+		return search.DiffPatch(ctx, bytes.NewReader(ch.Patch), ch.PatchStrip, ch.To)
+	}
+	filePatches, err := ch.FilePatches()
+	if err != nil {
+		return nil, 0, err
+	}
+	return search.PatchQueries(filePatches), len(filePatches), nil
+}
+
+// FilePatches returns the file-level changes from the base to the target tree.
+// They are calculated once, and shared by Queries, Suggester and the search for deleted code.
+func (ch *Changes) FilePatches() ([]fdiff.FilePatch, error) {
+	if ch.filePatchesDone {
+		return ch.filePatches, nil
+	}
+	var filePatches []fdiff.FilePatch
+	var err error
+	if ch.Patch != nil {
+		filePatches, err = domain.ParseUnifiedDiff(bytes.NewReader(ch.Patch), ch.PatchStrip)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing patch")
+		}
+	} else {
+		filePatches, err = domain.DiffTrees(ch.From, ch.To)
+		if err != nil {
+			return nil, errors.Wrap(err, "diffing trees")
+		}
+	}
+	ch.filePatches, ch.filePatchesDone = filePatches, true
+	return filePatches, nil
+}
+
+// Suggester returns the suggester of changes to missing clones, by applying the changes to changed clones.
+func (ch *Changes) Suggester() (*fix.Suggester, error) {
+	filePatches, err := ch.FilePatches()
+	if err != nil {
+		return nil, err
+	}
+	return fix.NewSuggester(filePatches, ch.To), nil
+}
@@ -0,0 +1,90 @@
+package iccheck
+
+import (
+	"github.com/salab/iccheck/pkg/diskcache"
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+// DefaultAlgorithm is the clone search algorithm used unless WithAlgorithm is given.
+const DefaultAlgorithm = "fleccs"
+
+type config struct {
+	algorithm      string
+	algoParams     map[string]string
+	matcher        *domain.MatcherRules
+	diskCache      *diskcache.Cache
+	detectMicro    bool
+	snapBoundaries bool
+	partialResults bool
+	searchDeleted  bool
+}
+
+func defaultConfig() *config {
+	return &config{
+		algorithm: DefaultAlgorithm,
+	}
+}
+
+func applyConfig(options ...ConfigFunc) *config {
+	c := defaultConfig()
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+type ConfigFunc func(c *config)
+
+// WithAlgorithm sets the clone search algorithm and its parameters.
+// Run 'iccheck algorithms' or see search.Algorithms for available algorithms and parameters.
+// params can be nil, in which case default values are used.
+func WithAlgorithm(name string, params map[string]string) ConfigFunc {
+	return func(c *config) {
+		c.algorithm = name
+		c.algoParams = params
+	}
+}
+
+// WithMatcher sets the rules of files and lines to ignore (see domain.ReadMatcherRules).
+// If not set, only the default ignore rules are used.
+func WithMatcher(matcher *domain.MatcherRules) ConfigFunc {
+	return func(c *config) {
+		c.matcher = matcher
+	}
+}
+
+// WithDiskCache sets the persistent cache of per-file data, which speeds up subsequent analyses.
+func WithDiskCache(cache *diskcache.Cache) ConfigFunc {
+	return func(c *config) {
+		c.diskCache = cache
+	}
+}
+
+// WithDetectMicro splits changes into small queries to detect micro-clones (has performance implications).
+func WithDetectMicro(detectMicro bool) ConfigFunc {
+	return func(c *config) {
+		c.detectMicro = detectMicro
+	}
+}
+
+// WithSnapBoundaries expands or shrinks detected clones to enclosing blocks, so that they do not cut blocks in half.
+func WithSnapBoundaries(snapBoundaries bool) ConfigFunc {
+	return func(c *config) {
+		c.snapBoundaries = snapBoundaries
+	}
+}
+
+// WithPartialResults returns clones found so far when ctx is done (such as by a timeout), instead of failing.
+// Result.Coverage tells how much of the search was completed in that case.
+func WithPartialResults(partialResults bool) ConfigFunc {
+	return func(c *config) {
+		c.partialResults = partialResults
+	}
+}
+
+// WithSearchDeleted also searches for copies of deleted code still remaining in the target tree, in Analyzer.Analyze.
+func WithSearchDeleted(searchDeleted bool) ConfigFunc {
+	return func(c *config) {
+		c.searchDeleted = searchDeleted
+	}
+}
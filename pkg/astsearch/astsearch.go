@@ -0,0 +1,270 @@
+// Package astsearch searches for clones in Go source code by comparing syntax trees.
+// Clone boundaries snap to syntactic units such as statements and declarations, instead of fixed windows of lines.
+package astsearch
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/ds"
+)
+
+type Source struct {
+	Filename     string
+	StartL, EndL int
+}
+
+type Clone struct {
+	Filename  string
+	StartLine int
+	EndLine   int
+	// Similarity is the ratio of common syntax tree nodes, from 0 to 1
+	Similarity float64
+	// Source indicates from which query this clone was detected
+	Source Source
+}
+
+type Query struct {
+	Filename     string
+	StartL, EndL int
+
+	// labels are hashes of the syntax tree nodes of the query unit in pre-order
+	labels []uint64
+	// bag is the multiset of labels
+	bag map[uint64]int
+	// siblings is the number of sibling statements (or declarations) in the query unit
+	siblings int
+}
+
+func (q *Query) toSource() Source {
+	return Source{
+		Filename: q.Filename,
+		StartL:   q.StartL,
+		EndL:     q.EndL,
+	}
+}
+
+// IsSupported returns true if the file can be searched by this algorithm.
+func IsSupported(filename string) bool {
+	return strings.HasSuffix(filename, ".go")
+}
+
+func parseFile(filename string, content []byte) (*token.FileSet, *ast.File, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, content, parser.SkipObjectResolution)
+	return fset, file, err
+}
+
+// readContents finds the syntactic unit of the query.
+// Returns false if the query is not in a Go file, the file has syntax errors, or no statements are in the query lines.
+func (q *Query) readContents(c *config, queryTree domain.Searcher) (bool, error) {
+	if !IsSupported(q.Filename) {
+		return false, nil
+	}
+	f, err := queryTree.Open(q.Filename)
+	if err != nil {
+		return false, err
+	}
+	content, err := f.Content()
+	if err != nil {
+		return false, err
+	}
+
+	fset, file, err := parseFile(q.Filename, content)
+	if err != nil {
+		slog.Debug("failed to parse query file", "file", q.Filename, "err", err)
+		return false, nil
+	}
+	startL, endL, ok := codeLines(content, q.StartL, q.EndL)
+	if !ok {
+		return false, nil
+	}
+	unit := findUnit(c, fset, file, startL, endL)
+	if len(unit) == 0 {
+		return false, nil
+	}
+
+	q.labels = lo.FlatMap(unit, func(n ast.Node, _ int) []uint64 { return labels(c, n) })
+	q.bag = lo.CountValues(q.labels)
+	q.siblings = len(unit)
+	return true, nil
+}
+
+// similarity returns the Dice coefficient of the longest common subsequence.
+func similarity(a, b []uint64) float64 {
+	if len(a)+len(b) == 0 {
+		return 0
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return 2 * float64(prev[len(b)]) / float64(len(a)+len(b))
+}
+
+// upperBound returns the upper bound of similarity from the multisets of labels, which is cheaper to calculate.
+func upperBound(a, b map[uint64]int, lenA, lenB int) float64 {
+	common := 0
+	for h, count := range a {
+		common += min(count, b[h])
+	}
+	return 2 * float64(common) / float64(lenA+lenB)
+}
+
+func fileSearch(
+	ctx context.Context,
+	c *config,
+	queries []*Query,
+	searchTree domain.Searcher,
+	searchFilename string,
+	matcher *domain.MatcherRules,
+) ([]*Clone, error) {
+	if ctx.Err() != nil { // check for deadline
+		return nil, ctx.Err()
+	}
+
+	searchFile, err := searchTree.Open(searchFilename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening search target file %v", searchFilename)
+	}
+	fileContent, err := searchFile.Content()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading search target file %v", searchFilename)
+	}
+
+	// Check ignore settings
+	skipEntireFile, ignoreRule := matcher.Match(searchFilename, fileContent)
+	if skipEntireFile {
+		return nil, nil
+	}
+
+	fset, file, err := parseFile(searchFilename, fileContent)
+	if err != nil {
+		slog.Debug("failed to parse search target file", "file", searchFilename, "err", err)
+		return nil, nil
+	}
+	lists := allLists(file)
+	listLabels := ds.Map(lists, func(list []ast.Node) [][]uint64 {
+		return ds.Map(list, func(n ast.Node) []uint64 { return labels(c, n) })
+	})
+
+	var clones []*Clone
+	for _, q := range queries {
+		if ctx.Err() != nil { // check for deadline
+			return nil, ctx.Err()
+		}
+
+		type match struct {
+			startL, endL int
+			similarity   float64
+		}
+		var matches []match
+		// Compare against each run of sibling statements, with the same number of statements as the query
+		for i, list := range lists {
+			for start := 0; start+q.siblings <= len(list); start++ {
+				windowLabels := slices.Concat(listLabels[i][start : start+q.siblings]...)
+				if 2*float64(min(len(q.labels), len(windowLabels)))/float64(len(q.labels)+len(windowLabels)) < c.similarityThreshold {
+					continue
+				}
+				if upperBound(q.bag, lo.CountValues(windowLabels), len(q.labels), len(windowLabels)) < c.similarityThreshold {
+					continue
+				}
+				sim := similarity(q.labels, windowLabels)
+				if sim < c.similarityThreshold {
+					continue
+				}
+				startL, _ := nodeLines(fset, list[start])
+				_, endL := nodeLines(fset, list[start+q.siblings-1])
+				matches = append(matches, match{startL, endL, sim})
+			}
+		}
+
+		// Report the most similar one from overlapping matches
+		slices.SortStableFunc(matches, ds.SortDesc(func(m match) float64 { return m.similarity }))
+		var fileClones []*Clone
+		for _, m := range matches {
+			if lo.SomeBy(fileClones, func(cl *Clone) bool { return cl.StartLine <= m.endL && m.startL <= cl.EndLine }) {
+				continue
+			}
+			// CanSkip takes 0-indexed lines
+			if canSkip, _ := ignoreRule.CanSkip(m.startL-1, m.endL-m.startL+1); canSkip {
+				continue
+			}
+			fileClones = append(fileClones, &Clone{
+				Filename:   searchFilename,
+				StartLine:  m.startL,
+				EndLine:    m.endL,
+				Similarity: m.similarity,
+				Source:     q.toSource(),
+			})
+		}
+		clones = append(clones, fileClones...)
+	}
+
+	return clones, nil
+}
+
+// Search searches for clones of queries in Go files.
+// Queries which cannot be searched by syntax trees (non-Go files, syntax errors, or no statements in the query lines)
+// are returned as unsupported, so that the caller can search them with other algorithms.
+func Search(
+	ctx context.Context,
+	queriesTree domain.Searcher,
+	queries []*Query,
+	searchTree domain.Searcher,
+	matcher *domain.MatcherRules,
+	options ...ConfigFunc,
+) (clones []*Clone, unsupported []*Query, err error) {
+	c := applyConfig(options...)
+
+	// Parse the queries
+	var supported []*Query
+	for _, q := range queries {
+		ok, err := q.readContents(c, queriesTree)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "reading contents of query")
+		}
+		if ok {
+			supported = append(supported, q)
+		} else {
+			unsupported = append(unsupported, q)
+		}
+	}
+	if len(supported) == 0 {
+		return nil, unsupported, nil
+	}
+
+	// List all Go file names from search root directory
+	searchFiles, err := searchTree.Files()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "listing search tree files")
+	}
+	searchFiles = lo.Filter(searchFiles, func(filename string, _ int) bool { return IsSupported(filename) })
+
+	clones, err = domain.SearchFiles(ctx, searchFiles, c.partialResults, func(ctx context.Context, searchFile string) ([]*Clone, error) {
+		return fileSearch(ctx, c, supported, searchTree, searchFile, matcher)
+	})
+	if _, ok := domain.AsIncomplete(err); err != nil && !ok {
+		return nil, nil, err
+	}
+
+	slices.SortFunc(clones, ds.SortDesc(func(c *Clone) float64 { return c.Similarity }))
+	return clones, unsupported, err
+}
@@ -0,0 +1,137 @@
+package astsearch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+// astTestFile has two functions, where the if statement of sumPositive (L6-L8)
+// is copied to countValid (L16-L18) with renamed identifiers and a dropped condition.
+const astTestFile = `package m
+
+func sumPositive(items []int) int {
+	total := 0
+	for _, item := range items {
+		if item > 0 && item < 100 {
+			total += item
+		}
+	}
+	return total
+}
+
+func countValid(values []int) int {
+	acc := 0
+	for _, v := range values {
+		if v > 0 {
+			acc += v
+		}
+	}
+	return acc
+}
+`
+
+func TestFindUnit(t *testing.T) {
+	fset, file, err := parseFile("m.go", []byte(astTestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		startL, endL   int
+		minNodes       int
+		wantL1, wantL2 int // Lines of the unit, or 0 if none
+	}{
+		// Snapping to statement boundaries
+		{"single statement", 7, 7, 0, 7, 7},
+		{"if condition snaps to the if statement", 6, 6, 0, 6, 8},
+		{"partial if statement", 6, 7, 0, 6, 8},
+		{"closing brace snaps to the if statement", 8, 8, 0, 6, 8},
+		{"parent statement is included", 5, 6, 0, 5, 9},
+		{"run of siblings", 4, 5, 0, 4, 9},
+		{"whole function", 3, 11, 0, 3, 11},
+		{"across functions", 10, 14, 0, 3, 21},
+		{"package clause", 1, 1, 0, 0, 0},
+		// Expansion of small units by min-nodes
+		{"small statement is expanded", 7, 7, 5, 6, 8},
+		{"expanded until enough nodes", 7, 7, 15, 5, 9},
+		{"large enough", 6, 6, 5, 6, 8},
+		{"expanded up to the top-level declaration", 7, 7, 1000, 3, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := applyConfig(WithMinNodes(tt.minNodes))
+			unit := findUnit(c, fset, file, tt.startL, tt.endL)
+			if tt.wantL1 == 0 {
+				if len(unit) != 0 {
+					t.Errorf("expected no unit, got %d node(s)", len(unit))
+				}
+				return
+			}
+			if len(unit) == 0 {
+				t.Fatalf("expected unit L%d-L%d, got none", tt.wantL1, tt.wantL2)
+			}
+			startL, _ := nodeLines(fset, unit[0])
+			_, endL := nodeLines(fset, unit[len(unit)-1])
+			if startL != tt.wantL1 || endL != tt.wantL2 {
+				t.Errorf("expected unit L%d-L%d, got L%d-L%d", tt.wantL1, tt.wantL2, startL, endL)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	const broken = "package m\n\nfunc broken() {\n\tif x > 0 {\n}\n"
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"m.go":      astTestFile,
+		"broken.go": broken,
+		"m.py":      "x = 1\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := domain.ReadMatcherRules(dir, false, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searcher := domain.NewSearcherFromTree(tree)
+
+	queries := []*Query{
+		{Filename: "m.go", StartL: 6, EndL: 6},
+		{Filename: "m.py", StartL: 1, EndL: 1},
+		{Filename: "broken.go", StartL: 4, EndL: 4},
+		{Filename: "m.go", StartL: 2, EndL: 2}, // Blank line
+	}
+	clones, unsupported, err := Search(context.Background(), searcher, queries, searcher, matcher, WithMinNodes(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Non-Go files, syntax errors and lines without statements fall back to other algorithms
+	if len(unsupported) != 3 || unsupported[0] != queries[1] || unsupported[1] != queries[2] || unsupported[2] != queries[3] {
+		t.Errorf("expected m.py, broken.go and blank line queries to be unsupported, got %+v", unsupported)
+	}
+	// The query line snaps to the if statement, and the clone is found at the if statement of the other function.
+	// broken.go is skipped as a search target.
+	expected := map[[2]int]bool{{6, 8}: false, {16, 18}: false}
+	for _, c := range clones {
+		if _, ok := expected[[2]int{c.StartLine, c.EndLine}]; ok && c.Filename == "m.go" {
+			expected[[2]int{c.StartLine, c.EndLine}] = true
+		} else {
+			t.Errorf("unexpected clone %+v", c)
+		}
+	}
+	for lines, found := range expected {
+		if !found {
+			t.Errorf("expected clone at L%d-L%d to be found", lines[0], lines[1])
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package astsearch
+
+const DefaultSimilarityThreshold = 0.8
+const DefaultMinNodes = 16
+const DefaultNormalize = true
+
+type config struct {
+	similarityThreshold float64
+	minNodes            int
+	normalize           bool
+	partialResults      bool
+}
+
+func defaultConfig() *config {
+	return &config{
+		similarityThreshold: DefaultSimilarityThreshold,
+		minNodes:            DefaultMinNodes,
+		normalize:           DefaultNormalize,
+	}
+}
+
+func applyConfig(options ...ConfigFunc) *config {
+	c := defaultConfig()
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+type ConfigFunc func(c *config)
+
+// WithSimilarityThreshold sets the minimum similarity (0 to 1) of syntax trees to detect as clones.
+func WithSimilarityThreshold(similarityThreshold float64) ConfigFunc {
+	return func(c *config) {
+		c.similarityThreshold = similarityThreshold
+	}
+}
+
+// WithMinNodes sets the minimum number of syntax tree nodes of queries.
+// Smaller queries (such as a single assignment) are expanded to the enclosing statement, since they would match too many locations.
+func WithMinNodes(minNodes int) ConfigFunc {
+	return func(c *config) {
+		c.minNodes = minNodes
+	}
+}
+
+// WithNormalize sets whether to normalize identifiers and literals.
+func WithNormalize(normalize bool) ConfigFunc {
+	return func(c *config) {
+		c.normalize = normalize
+	}
+}
+
+// WithPartialResults sets whether to return results of the files already searched, when ctx is done.
+// See domain.SearchFiles.
+func WithPartialResults(partial bool) ConfigFunc {
+	return func(c *config) {
+		c.partialResults = partial
+	}
+}
@@ -0,0 +1,211 @@
+package astsearch
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strings"
+
+	"github.com/cespare/xxhash"
+	"github.com/samber/lo"
+
+	"github.com/salab/iccheck/pkg/utils/ds"
+)
+
+func toNodes[T ast.Node](s []T) []ast.Node {
+	return ds.Map(s, func(n T) ast.Node { return n })
+}
+
+// childLists returns lists of sibling statements directly under the node.
+// Function literals (such as goroutines and callbacks) are also descended into.
+func childLists(n ast.Node) [][]ast.Node {
+	switch n := n.(type) {
+	case *ast.FuncDecl:
+		if n.Body == nil {
+			return nil
+		}
+		return [][]ast.Node{toNodes(n.Body.List)}
+	case *ast.BlockStmt:
+		return [][]ast.Node{toNodes(n.List)}
+	case *ast.IfStmt:
+		if n.Else != nil {
+			return [][]ast.Node{toNodes(n.Body.List), {n.Else}}
+		}
+		return [][]ast.Node{toNodes(n.Body.List)}
+	case *ast.ForStmt:
+		return [][]ast.Node{toNodes(n.Body.List)}
+	case *ast.RangeStmt:
+		return [][]ast.Node{toNodes(n.Body.List)}
+	case *ast.SwitchStmt:
+		return [][]ast.Node{toNodes(n.Body.List)}
+	case *ast.TypeSwitchStmt:
+		return [][]ast.Node{toNodes(n.Body.List)}
+	case *ast.SelectStmt:
+		return [][]ast.Node{toNodes(n.Body.List)}
+	case *ast.CaseClause:
+		return [][]ast.Node{toNodes(n.Body)}
+	case *ast.CommClause:
+		return [][]ast.Node{toNodes(n.Body)}
+	case *ast.LabeledStmt:
+		return [][]ast.Node{{n.Stmt}}
+	default:
+		var lists [][]ast.Node
+		ast.Inspect(n, func(n ast.Node) bool {
+			if lit, ok := n.(*ast.FuncLit); ok {
+				lists = append(lists, toNodes(lit.Body.List))
+				return false
+			}
+			return true
+		})
+		return lists
+	}
+}
+
+// allLists returns all lists of sibling declarations and statements in the file.
+func allLists(file *ast.File) [][]ast.Node {
+	var lists [][]ast.Node
+	var walk func(list []ast.Node)
+	walk = func(list []ast.Node) {
+		lists = append(lists, list)
+		for _, n := range list {
+			for _, children := range childLists(n) {
+				walk(children)
+			}
+		}
+	}
+	walk(toNodes(file.Decls))
+	return lists
+}
+
+func nodeLines(fset *token.FileSet, n ast.Node) (startL, endL int) {
+	return fset.Position(n.Pos()).Line, fset.Position(n.End()).Line
+}
+
+// codeLines trims blank lines and comment lines from the both ends of the range.
+func codeLines(content []byte, startL, endL int) (int, int, bool) {
+	lines := strings.Split(string(content), "\n")
+	isCode := func(l int) bool {
+		if l < 1 || len(lines) < l {
+			return false
+		}
+		line := strings.TrimSpace(lines[l-1])
+		return line != "" && !strings.HasPrefix(line, "//")
+	}
+	for startL <= endL && !isCode(startL) {
+		startL++
+	}
+	for startL <= endL && !isCode(endL) {
+		endL--
+	}
+	return startL, endL, startL <= endL
+}
+
+// findUnit finds the syntactic unit for the query lines, which is the deepest run of sibling statements (or declarations) covering the lines.
+// Units with too few nodes are expanded to the enclosing statement.
+func findUnit(c *config, fset *token.FileSet, file *ast.File, startL, endL int) []ast.Node {
+	var unit []ast.Node
+	var parents []ast.Node
+	list := toNodes(file.Decls)
+	for {
+		overlapping := lo.Filter(list, func(n ast.Node, _ int) bool {
+			s, e := nodeLines(fset, n)
+			return s <= endL && startL <= e
+		})
+		if len(overlapping) == 0 {
+			break
+		}
+		firstL, _ := nodeLines(fset, overlapping[0])
+		_, lastL := nodeLines(fset, overlapping[len(overlapping)-1])
+		if unit != nil && !(firstL <= startL && endL <= lastL) {
+			// The query also covers the parent statement itself (e.g. the condition of an if statement)
+			parents = parents[:len(parents)-1]
+			break
+		}
+		unit = overlapping
+		if len(overlapping) > 1 || !(firstL <= startL && endL <= lastL) {
+			break
+		}
+
+		// Descend into the only statement
+		n := overlapping[0]
+		lists := lo.Filter(childLists(n), func(l []ast.Node, _ int) bool {
+			return lo.SomeBy(l, func(n ast.Node) bool {
+				s, e := nodeLines(fset, n)
+				return s <= endL && startL <= e
+			})
+		})
+		if len(lists) != 1 {
+			break
+		}
+		parents = append(parents, n)
+		list = lists[0]
+	}
+
+	for len(unit) > 0 && len(parents) > 0 && lo.SumBy(unit, func(n ast.Node) int { return len(labels(c, n)) }) < c.minNodes {
+		unit = []ast.Node{parents[len(parents)-1]}
+		parents = parents[:len(parents)-1]
+	}
+	return unit
+}
+
+// labels returns hashes of the node labels in pre-order.
+func labels(c *config, n ast.Node) []uint64 {
+	// Names of fields, methods, and called functions are kept, since they rarely get renamed in copies
+	keepNames := make(map[*ast.Ident]struct{})
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			keepNames[n.Sel] = struct{}{}
+		case *ast.KeyValueExpr:
+			if key, ok := n.Key.(*ast.Ident); ok {
+				keepNames[key] = struct{}{}
+			}
+		case *ast.CallExpr:
+			if fun, ok := n.Fun.(*ast.Ident); ok {
+				keepNames[fun] = struct{}{}
+			}
+		}
+		return true
+	})
+
+	var hashes []uint64
+	ast.Inspect(n, func(n ast.Node) bool {
+		if n != nil {
+			hashes = append(hashes, xxhash.Sum64String(label(c, n, keepNames)))
+		}
+		return true
+	})
+	return hashes
+}
+
+func label(c *config, n ast.Node, keepNames map[*ast.Ident]struct{}) string {
+	switch n := n.(type) {
+	case *ast.Ident:
+		// Predeclared identifiers such as nil, true, len, and error are kept too
+		_, keep := keepNames[n]
+		if !c.normalize || keep || types.Universe.Lookup(n.Name) != nil {
+			return "Ident:" + n.Name
+		}
+		return "Ident"
+	case *ast.BasicLit:
+		if !c.normalize {
+			return "BasicLit:" + n.Value
+		}
+		return "BasicLit:" + n.Kind.String()
+	case *ast.BinaryExpr:
+		return "BinaryExpr:" + n.Op.String()
+	case *ast.UnaryExpr:
+		return "UnaryExpr:" + n.Op.String()
+	case *ast.AssignStmt:
+		return "AssignStmt:" + n.Tok.String()
+	case *ast.IncDecStmt:
+		return "IncDecStmt:" + n.Tok.String()
+	case *ast.BranchStmt:
+		return "BranchStmt:" + n.Tok.String()
+	case *ast.GenDecl:
+		return "GenDecl:" + n.Tok.String()
+	default:
+		return reflect.TypeOf(n).Elem().Name()
+	}
+}
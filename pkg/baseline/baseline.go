@@ -0,0 +1,192 @@
+// Package baseline records fingerprints of already-known clone sets,
+// so that only new findings are reported in later runs.
+package baseline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cespare/xxhash"
+	"github.com/pkg/errors"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/files"
+	"github.com/samber/lo"
+)
+
+// version is the version of the baseline file format, and the fingerprint calculation.
+const version = 1
+
+// Baseline is the content of a baseline file.
+type Baseline struct {
+	Version int      `json:"version"`
+	Entries []*Entry `json:"entries"`
+}
+
+// Entry is a known clone set.
+// Clone locations are informational only, to help reviewing the baseline file - only Fingerprints are used for matching.
+type Entry struct {
+	// Fingerprints of each missing clone, in the same order as Missing
+	Fingerprints []string `json:"fingerprints"`
+	Missing      []string `json:"missing"`
+	Changed      []string `json:"changed"`
+}
+
+// Read reads a baseline file.
+func Read(path string) (*Baseline, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading baseline file %v", path)
+	}
+	var bl Baseline
+	if err = json.Unmarshal(b, &bl); err != nil {
+		return nil, errors.Wrapf(err, "decoding baseline file %v", path)
+	}
+	if bl.Version != version {
+		return nil, errors.Errorf("unsupported baseline file version %d in %v, please re-create with --write-baseline", bl.Version, path)
+	}
+	return &bl, nil
+}
+
+// Write writes the given clone sets and their fingerprints into a baseline file.
+func Write(path string, sets []*domain.CloneSet, fp *Fingerprinter) error {
+	entries, err := ds.MapError(sets, func(set *domain.CloneSet) (*Entry, error) {
+		fingerprints, err := fp.Fingerprints(set)
+		if err != nil {
+			return nil, err
+		}
+		return &Entry{
+			Fingerprints: fingerprints,
+			Missing:      ds.Map(set.Missing, cloneLocation),
+			Changed:      ds.Map(set.Changed, cloneLocation),
+		}, nil
+	})
+	if err != nil {
+		return err
+	}
+	// Sort for smaller diffs when the baseline file is updated
+	slices.SortFunc(entries, ds.SortCompose(
+		ds.SortAsc(func(e *Entry) string { return lo.FirstOr(e.Missing, "") }),
+		ds.SortAsc(func(e *Entry) string { return lo.FirstOr(e.Fingerprints, "") }),
+	))
+
+	b, err := json.MarshalIndent(&Baseline{Version: version, Entries: entries}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding baseline")
+	}
+	if err = os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "writing baseline file %v", path)
+	}
+	return nil
+}
+
+func cloneLocation(c *domain.Clone) string {
+	return fmt.Sprintf("%s:%d-%d", filepath.ToSlash(c.Filename), c.StartL, c.EndL)
+}
+
+// Filter removes missing clones recorded in the baseline from clone sets,
+// and returns clone sets with at least one new missing clone.
+func (b *Baseline) Filter(sets []*domain.CloneSet, fp *Fingerprinter) ([]*domain.CloneSet, error) {
+	known := make(map[string]struct{})
+	for _, e := range b.Entries {
+		for _, f := range e.Fingerprints {
+			known[f] = struct{}{}
+		}
+	}
+	var ret []*domain.CloneSet
+	for _, set := range sets {
+		fingerprints, err := fp.Fingerprints(set)
+		if err != nil {
+			return nil, err
+		}
+		newMissing := lo.Filter(set.Missing, func(_ *domain.Clone, i int) bool {
+			_, ok := known[fingerprints[i]]
+			return !ok
+		})
+		if len(newMissing) == 0 {
+			continue
+		}
+		filtered := *set
+		filtered.Missing = newMissing
+		ret = append(ret, &filtered)
+	}
+	return ret, nil
+}
+
+// Fingerprinter calculates fingerprints of clone sets from the contents of clones,
+// so that fingerprints are stable against line shifts.
+type Fingerprinter struct {
+	// fromTree is used to read Changed clones of Deleted clone sets. Can be nil if there are no such sets.
+	fromTree domain.Tree
+	toTree   domain.Tree
+
+	contents map[string][]byte
+}
+
+func NewFingerprinter(fromTree, toTree domain.Tree) *Fingerprinter {
+	return &Fingerprinter{
+		fromTree: fromTree,
+		toTree:   toTree,
+		contents: make(map[string][]byte),
+	}
+}
+
+// Fingerprints calculates fingerprints of each missing clone in the clone set, in the same order as Missing.
+// A fingerprint is calculated from the contents of the missing clone and all changed clones in the set,
+// so that it is not affected by other clones joining or leaving the set.
+// Leading and trailing whitespaces of each line are ignored, so that indentation changes do not affect fingerprints.
+func (f *Fingerprinter) Fingerprints(set *domain.CloneSet) ([]string, error) {
+	changedTree := lo.Ternary(set.Deleted, f.fromTree, f.toTree)
+	if changedTree == nil {
+		return nil, errors.New("base tree is required to fingerprint clone sets of deleted code")
+	}
+	changed, err := ds.MapError(set.Changed, func(c *domain.Clone) (string, error) { return f.cloneHash(changedTree, c) })
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(changed)
+
+	return ds.MapError(set.Missing, func(c *domain.Clone) (string, error) {
+		missing, err := f.cloneHash(f.toTree, c)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		buf.WriteString(lo.Ternary(set.Deleted, "deleted", lo.Ternary(set.Standalone, "standalone", "changed")))
+		buf.WriteString("\x00m" + missing)
+		for _, h := range changed {
+			buf.WriteString("\x00c" + h)
+		}
+		return fmt.Sprintf("%016x", xxhash.Sum64(buf.Bytes())), nil
+	})
+}
+
+func (f *Fingerprinter) cloneHash(tree domain.Tree, c *domain.Clone) (string, error) {
+	key := tree.String() + "\x00" + c.Filename
+	content, ok := f.contents[key]
+	if !ok {
+		var err error
+		content, err = files.ReadAll(tree.Reader(c.Filename))
+		if err != nil {
+			return "", errors.Wrapf(err, "reading %v from %v", c.Filename, tree)
+		}
+		f.contents[key] = content
+	}
+
+	indices := files.LineStartIndices(content)
+	h := xxhash.New()
+	for l := c.StartL; l <= c.EndL && l <= len(indices); l++ {
+		end := len(content)
+		if l < len(indices) {
+			end = indices[l]
+		}
+		line := strings.TrimSpace(string(content[indices[l-1]:end]))
+		_, _ = h.Write([]byte(line + "\n"))
+	}
+	return fmt.Sprintf("%016x", h.Sum64()), nil
+}
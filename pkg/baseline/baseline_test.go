@@ -0,0 +1,67 @@
+package baseline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+func TestBaseline_Filter(t *testing.T) {
+	const content = `func a() {
+	x := 1
+	return x
+}
+
+func b() {
+	x := 1
+	return x
+}
+`
+	oldDir, newDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(oldDir, "a.go"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Lines are shifted, and indentation is changed
+	if err := os.WriteFile(filepath.Join(newDir, "a.go"), []byte("// comment\n\n"+content+"\nfunc b() {\n  x := 1\n  return x\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldTree, err := domain.NewFileSystemTree(oldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTree, err := domain.NewFileSystemTree(newDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	oldSets := []*domain.CloneSet{{
+		Changed: []*domain.Clone{{Filename: "a.go", StartL: 1, EndL: 4}},
+		Missing: []*domain.Clone{{Filename: "a.go", StartL: 6, EndL: 9}},
+	}}
+	if err = Write(path, oldSets, NewFingerprinter(nil, oldTree)); err != nil {
+		t.Fatal(err)
+	}
+	bl, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSets := []*domain.CloneSet{{
+		Changed: []*domain.Clone{{Filename: "a.go", StartL: 3, EndL: 6}},
+		Missing: []*domain.Clone{
+			{Filename: "a.go", StartL: 8, EndL: 11},  // known
+			{Filename: "a.go", StartL: 13, EndL: 16}, // same content as the known clone, except for indentation
+			{Filename: "a.go", StartL: 1, EndL: 2},   // new
+		},
+	}}
+	got, err := bl.Filter(newSets, NewFingerprinter(nil, newTree))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || len(got[0].Missing) != 1 || got[0].Missing[0].StartL != 1 {
+		t.Errorf("expected only the new missing clone to remain, got %+v", got)
+	}
+}
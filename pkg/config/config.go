@@ -0,0 +1,417 @@
+// Package config reads project configuration files (.iccheck.yaml), which can set command line flags,
+// typed algorithm parameters, and ignore rules.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/strs"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// fileBaseName is the base name of repository config files, with extension .yaml or .yml.
+	fileBaseName = ".iccheck"
+	// userDirName and userFileBaseName are the directory (in the user config directory) and the base name
+	// of the user-level config file, with extension .yaml or .yml.
+	userDirName      = "iccheck"
+	userFileBaseName = "config"
+)
+
+// userConfigDir returns the user config directory. Replaced in tests.
+var userConfigDir = os.UserConfigDir
+
+const (
+	// keyAlgorithm is the flag of the algorithm name, validated by Schema.ValidateAlgorithm in addition to Schema.Enums.
+	keyAlgorithm = "algorithm"
+	// keyAlgorithmParams holds typed parameters of each algorithm, keyed by algorithm name.
+	keyAlgorithmParams = "algorithm-params"
+	// keyIgnore holds ignore rules, either in --ignore syntax, or in the same form as .iccheckignore.yaml.
+	keyIgnore = "ignore"
+	// keyAlgorithmParam is the flag of untyped algorithm parameters, which is superseded by keyAlgorithmParams.
+	keyAlgorithmParam = "algorithm-param"
+)
+
+// RepoKeys are the keys which repository config files can set, tuning clone detection in the repository.
+// Repository config files are not trusted, so that they cannot change what is checked, where results are written,
+// or how results affect the exit code. Such keys can only be set by the user-level config file, flags or env.
+var RepoKeys = []string{
+	keyAlgorithm,
+	keyAlgorithmParams,
+	keyIgnore,
+	"include",
+	"disable-default-ignore",
+	"micro",
+	"snap-boundaries",
+}
+
+// Schema describes the allowed contents of config files.
+type Schema struct {
+	// Flags are all command line flags, which can be set by their names.
+	Flags *pflag.FlagSet
+	// Algorithms are the names of available algorithms.
+	Algorithms []string
+	// ValidateAlgorithm validates algorithm names not in Algorithms, such as names of external algorithms.
+	// Can be nil, in which case algorithm names must be one of Algorithms.
+	ValidateAlgorithm func(name string) error
+	// Enums lists allowed values of flags, if restricted.
+	Enums map[string][]string
+	// ValidateParam validates a parameter of an algorithm. Can be nil, in which case parameters are not validated.
+	ValidateParam func(algorithm, name, value string) error
+	// ResolveAlgorithm resolves a validated algorithm name in the config file of src,
+	// such as to resolve relative paths against the directory of the file, or to reject names not allowed in the file.
+	// Can be nil, in which case names are used as is.
+	ResolveAlgorithm func(name string, src Source) (string, error)
+	// ResolveParam resolves a validated parameter of an algorithm in the config file of src, in the same way as ResolveAlgorithm.
+	// Returns the resolved name and value. Can be nil, in which case parameters are used as is.
+	ResolveParam func(algorithm, name, value string, src Source) (string, string, error)
+}
+
+// Source describes where a config file is read from.
+type Source struct {
+	// Dir is the directory of the config file.
+	Dir string
+	// Trusted is true for the user-level config file, and false for repository config files,
+	// which can be changed by anyone able to change the repository.
+	Trusted bool
+}
+
+// File is the configuration read from config files.
+type File struct {
+	// Paths are the paths of the files read, in the order of precedence.
+	Paths []string
+	// values are the values of flags in string forms, which can be set by pflag.Value.Set one by one.
+	values map[string][]string
+	// algorithmParams are the parameters of each algorithm.
+	algorithmParams map[string]map[string]string
+	// ignores are ignore rules given in the same form as .iccheckignore.yaml.
+	ignores []*domain.IgnoreConfig
+}
+
+// Load reads the config files in the following locations, and validates them against the schema.
+// Values in the former file take precedence over the latter, key by key.
+//  1. ${repoDir}/.iccheck.{yaml,yml}
+//  2. ${userConfigDir}/iccheck/config.{yaml,yml}, where userConfigDir is the OS-specific user config directory
+//     ($XDG_CONFIG_HOME or ~/.config on Linux, ~/Library/Application Support on macOS, %AppData% on Windows)
+//
+// repoDir can be empty, in which case only the user-level config file is read.
+func Load(repoDir string, schema *Schema) (*File, error) {
+	type file struct {
+		path    string
+		trusted bool
+	}
+	var files []file
+	if repoDir != "" {
+		files = append(files, file{path: findFile(repoDir, fileBaseName)})
+	}
+	if configDir, err := userConfigDir(); err == nil {
+		files = append(files, file{path: findFile(filepath.Join(configDir, userDirName), userFileBaseName), trusted: true})
+	}
+
+	f := &File{
+		values:          make(map[string][]string),
+		algorithmParams: make(map[string]map[string]string),
+	}
+	for _, file := range files {
+		path := file.path
+		if path == "" {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		loaded, err := parse(content, schema, Source{Dir: filepath.Dir(path), Trusted: file.trusted})
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+		f.Paths = append(f.Paths, path)
+		f.merge(loaded)
+	}
+	return f, nil
+}
+
+// findFile returns the path of the config file of the base name in dir, or empty string if none exists.
+func findFile(dir, baseName string) string {
+	for _, ext := range []string{".yaml", ".yml"} {
+		path := filepath.Join(dir, baseName+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// merge merges values of the other file with lower precedence.
+func (f *File) merge(other *File) {
+	if _, ok := f.values[keyIgnore]; !ok {
+		f.ignores = other.ignores
+	}
+	for key, value := range other.values {
+		if _, ok := f.values[key]; !ok {
+			f.values[key] = value
+		}
+	}
+	for algorithm, params := range other.algorithmParams {
+		if _, ok := f.algorithmParams[algorithm]; !ok {
+			f.algorithmParams[algorithm] = params
+		}
+	}
+}
+
+// Apply sets values of flags in the flag set, except for the flags skip returns true for.
+// Flags not in the flag set are ignored, since config files can hold flags of any command.
+func (f *File) Apply(flags *pflag.FlagSet, skip func(name string) bool) error {
+	for _, name := range lo.Keys(f.values) {
+		flag := flags.Lookup(name)
+		if flag == nil || skip(name) {
+			continue
+		}
+		for _, value := range f.values[name] {
+			if err := flag.Value.Set(value); err != nil {
+				return fmt.Errorf("setting %q from config file: %w", name, err)
+			}
+		}
+		flag.Changed = true
+	}
+	return nil
+}
+
+// AlgorithmParams returns the parameters of the algorithm, or nil if none are set.
+func (f *File) AlgorithmParams(algorithm string) map[string]string {
+	return f.algorithmParams[algorithm]
+}
+
+// Ignores returns ignore rules given in the same form as .iccheckignore.yaml.
+// Ignore rules in --ignore syntax are set to the "ignore" flag by Apply instead.
+func (f *File) Ignores() []*domain.IgnoreConfig {
+	return f.ignores
+}
+
+func parse(content []byte, schema *Schema, src Source) (*File, error) {
+	f := &File{
+		values:          make(map[string][]string),
+		algorithmParams: make(map[string]map[string]string),
+	}
+	var root yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(content)).Decode(&root); err != nil {
+		if errors.Is(err, io.EOF) {
+			return f, nil // Empty file
+		}
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return f, nil
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, nodeError(doc, "expected a mapping of keys to values")
+	}
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		keyNode, valueNode := doc.Content[i], doc.Content[i+1]
+		key := keyNode.Value
+		var err error
+		switch key {
+		case keyAlgorithmParams:
+			err = f.parseAlgorithmParams(valueNode, schema, src)
+		case keyIgnore:
+			err = f.parseIgnore(valueNode)
+		case keyAlgorithmParam:
+			err = nodeError(keyNode, "%q is not supported in config files, use %q instead", key, keyAlgorithmParams)
+		default:
+			err = f.parseFlag(keyNode, valueNode, schema, src)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *File) parseFlag(keyNode, valueNode *yaml.Node, schema *Schema, src Source) error {
+	key := keyNode.Value
+	flag := schema.Flags.Lookup(key)
+	if flag == nil || flag.Hidden || key == "help" || key == "version" {
+		return nodeError(keyNode, "unknown key %q%s", key, suggest(key, allKeys(schema)))
+	}
+	if !src.Trusted && !slices.Contains(RepoKeys, key) {
+		return nodeError(keyNode, "%q cannot be set by repository config files, use the user-level config file, flags or env instead", key)
+	}
+
+	var values []string
+	switch flag.Value.Type() {
+	case "stringArray", "stringSlice":
+		switch valueNode.Kind {
+		case yaml.ScalarNode:
+			values = []string{valueNode.Value}
+		case yaml.SequenceNode:
+			for _, item := range valueNode.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nodeError(item, "%q expects a list of strings", key)
+				}
+				values = append(values, item.Value)
+			}
+		default:
+			return nodeError(valueNode, "%q expects a list of strings", key)
+		}
+	default:
+		if valueNode.Kind != yaml.ScalarNode {
+			return nodeError(valueNode, "%q expects a %s value", key, flag.Value.Type())
+		}
+		values = []string{valueNode.Value}
+	}
+
+	// Check values by setting them to a scratch flag of the same type
+	for i, value := range values {
+		if err := checkValue(flag, value); err != nil {
+			return nodeError(valueNode, "invalid value %q for %q: expected a %s value", value, key, flag.Value.Type())
+		}
+		if allowed, ok := schema.Enums[key]; ok && !slices.Contains(allowed, value) {
+			return nodeError(valueNode, "invalid value %q for %q: expected one of %s", value, key, strings.Join(allowed, ", "))
+		}
+		if key == keyAlgorithm {
+			resolved, err := schema.resolveAlgorithm(value, src)
+			if err != nil {
+				return nodeError(valueNode, "invalid value %q for %q: %v", value, key, err)
+			}
+			values[i] = resolved
+		}
+	}
+	f.values[key] = values
+	return nil
+}
+
+func (f *File) parseAlgorithmParams(node *yaml.Node, schema *Schema, src Source) error {
+	if node.Kind != yaml.MappingNode {
+		return nodeError(node, "%q expects a mapping of algorithm names to parameters", keyAlgorithmParams)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		algoNode, paramsNode := node.Content[i], node.Content[i+1]
+		algorithm := algoNode.Value
+		resolvedAlgorithm, err := schema.resolveAlgorithm(algorithm, src)
+		if err != nil {
+			return nodeError(algoNode, "%v", err)
+		}
+		if paramsNode.Kind != yaml.MappingNode {
+			return nodeError(paramsNode, "parameters of %q expect a mapping of names to values", algorithm)
+		}
+		params := make(map[string]string)
+		for j := 0; j+1 < len(paramsNode.Content); j += 2 {
+			nameNode, valueNode := paramsNode.Content[j], paramsNode.Content[j+1]
+			if valueNode.Kind != yaml.ScalarNode {
+				return nodeError(valueNode, "parameter %q of %q expects a number, string or boolean", nameNode.Value, algorithm)
+			}
+			name, value := nameNode.Value, valueNode.Value
+			if schema.ValidateParam != nil {
+				if err := schema.ValidateParam(algorithm, name, value); err != nil {
+					return nodeError(nameNode, "%v", err)
+				}
+			}
+			if schema.ResolveParam != nil {
+				if name, value, err = schema.ResolveParam(algorithm, name, value, src); err != nil {
+					return nodeError(nameNode, "%v", err)
+				}
+			}
+			params[name] = value
+		}
+		f.algorithmParams[resolvedAlgorithm] = params
+	}
+	return nil
+}
+
+func (f *File) parseIgnore(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return nodeError(node, "%q expects a list of ignore rules", keyIgnore)
+	}
+	for _, item := range node.Content {
+		switch item.Kind {
+		case yaml.ScalarNode:
+			// --ignore syntax
+			f.values[keyIgnore] = append(f.values[keyIgnore], item.Value)
+		case yaml.MappingNode:
+			var rule domain.IgnoreConfig
+			if err := item.Decode(&rule); err != nil {
+				return nodeError(item, "invalid ignore rule: %v", err)
+			}
+			check := rule // Compile modifies patterns
+			if _, err := check.Compile(); err != nil {
+				return nodeError(item, "invalid ignore rule: %v", err)
+			}
+			f.ignores = append(f.ignores, &rule)
+		default:
+			return nodeError(item, "ignore rule expects a string or a mapping with \"files\" and \"patterns\"")
+		}
+	}
+	// Mark as set, so that lower precedence files do not add to the rules
+	if _, ok := f.values[keyIgnore]; !ok {
+		f.values[keyIgnore] = nil
+	}
+	return nil
+}
+
+// resolveAlgorithm validates and resolves the algorithm name in the config file of src.
+func (s *Schema) resolveAlgorithm(name string, src Source) (string, error) {
+	if err := s.validateAlgorithm(name); err != nil {
+		return "", err
+	}
+	if s.ResolveAlgorithm == nil {
+		return name, nil
+	}
+	return s.ResolveAlgorithm(name, src)
+}
+
+func (s *Schema) validateAlgorithm(name string) error {
+	if slices.Contains(s.Algorithms, name) {
+		return nil
+	}
+	if s.ValidateAlgorithm != nil {
+		return s.ValidateAlgorithm(name)
+	}
+	return fmt.Errorf("unknown algorithm %q%s", name, suggest(name, s.Algorithms))
+}
+
+// checkValue checks if the value can be set to the flag, without modifying the flag.
+func checkValue(flag *pflag.Flag, value string) error {
+	scratch := pflag.NewFlagSet("scratch", pflag.ContinueOnError)
+	switch flag.Value.Type() {
+	case "bool":
+		scratch.Bool(flag.Name, false, "")
+	case "int":
+		scratch.Int(flag.Name, 0, "")
+	case "float64":
+		scratch.Float64(flag.Name, 0, "")
+	default:
+		return nil
+	}
+	return scratch.Set(flag.Name, value)
+}
+
+func allKeys(schema *Schema) []string {
+	var keys []string
+	schema.Flags.VisitAll(func(f *pflag.Flag) { keys = append(keys, f.Name) })
+	return append(keys, keyAlgorithmParams)
+}
+
+// suggest returns a suggestion message of the closest candidate, if any.
+func suggest(key string, candidates []string) string {
+	best, ok := strs.Closest(key, candidates)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+func nodeError(node *yaml.Node, format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", node.Line, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+type testFlags struct {
+	algorithm string
+	micro     bool
+	timeout   int
+	ignores   []string
+}
+
+func newTestSchema() (*Schema, *testFlags) {
+	var f testFlags
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVar(&f.algorithm, "algorithm", "fleccs", "")
+	flags.BoolVar(&f.micro, "micro", false, "")
+	flags.IntVar(&f.timeout, "timeout-seconds", 60, "")
+	flags.StringArrayVar(&f.ignores, "ignore", nil, "")
+	flags.StringArray("algorithm-param", nil, "")
+	flags.String("write-baseline", "", "")
+	return &Schema{
+		Flags:      flags,
+		Algorithms: []string{"fleccs", "ncdsearch"},
+		Enums:      map[string][]string{"algorithm": {"fleccs", "ncdsearch"}},
+		ValidateParam: func(algorithm, name, value string) error {
+			if name != "threshold" && name != "context-lines" {
+				return fmt.Errorf("unknown parameter %q of %v", name, algorithm)
+			}
+			return nil
+		},
+	}, &f
+}
+
+// setUserConfigDir sets the user config directory to a temporary directory, and returns the path of the user-level config file in it.
+func setUserConfigDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	userConfigDir = func() (string, error) { return dir, nil }
+	t.Cleanup(func() { userConfigDir = os.UserConfigDir })
+	return filepath.Join(dir, "iccheck", "config.yaml")
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	userFile, repo := setUserConfigDir(t), t.TempDir()
+	writeFile(t, userFile, `
+algorithm: ncdsearch
+timeout-seconds: 30
+algorithm-params:
+  fleccs:
+    threshold: 0.5
+`)
+	writeFile(t, filepath.Join(repo, ".iccheck.yml"), `
+algorithm: fleccs
+micro: true
+algorithm-params:
+  fleccs:
+    context-lines: 3
+ignore:
+  - '^dist/'
+  - files: ['\.go$']
+    patterns: ['^// Code generated']
+`)
+
+	schema, flags := newTestSchema()
+	f, err := Load(repo, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Apply(schema.Flags, func(name string) bool { return name == "micro" }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Repository config takes precedence key by key
+	if flags.algorithm != "fleccs" || flags.timeout != 30 {
+		t.Errorf("unexpected flags: algorithm=%v, timeout=%v", flags.algorithm, flags.timeout)
+	}
+	if flags.micro {
+		t.Errorf("expected skipped flag not to be set")
+	}
+	if params := f.AlgorithmParams("fleccs"); len(params) != 1 || params["context-lines"] != "3" {
+		t.Errorf("unexpected algorithm params: %v", params)
+	}
+	if len(flags.ignores) != 1 || flags.ignores[0] != "^dist/" {
+		t.Errorf("unexpected ignore flag: %v", flags.ignores)
+	}
+	if len(f.Ignores()) != 1 || f.Ignores()[0].Patterns[0] != "^// Code generated" {
+		t.Errorf("unexpected ignore rules: %v", f.Ignores())
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	setUserConfigDir(t)
+	tests := []struct {
+		name    string
+		content string
+		errMsg  string
+	}{
+		{"unknown key", "algoritm: fleccs\n", `line 1: unknown key "algoritm", did you mean "algorithm"?`},
+		{"invalid type", "micro: yes please\n", `line 1: invalid value "yes please" for "micro": expected a bool value`},
+		{"invalid enum", "algorithm: foo\n", `line 1: invalid value "foo" for "algorithm": expected one of fleccs, ncdsearch`},
+		{"unknown algorithm", "algorithm-params:\n  flecs:\n    threshold: 1\n", `line 2: unknown algorithm "flecs"`},
+		{"nested param", "algorithm-params:\n  fleccs:\n    threshold: [1]\n", `line 3: parameter "threshold" of "fleccs" expects`},
+		{"unknown param", "algorithm-params:\n  fleccs:\n    treshold: 1\n", `line 3: unknown parameter "treshold" of fleccs`},
+		{"untyped params", "algorithm-param: [a=b]\n", `use "algorithm-params" instead`},
+		{"not a mapping", "- a\n", "expected a mapping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := t.TempDir()
+			writeFile(t, filepath.Join(repo, ".iccheck.yaml"), tt.content)
+			schema, _ := newTestSchema()
+			_, err := Load(repo, schema)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error to contain %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestLoad_Resolve(t *testing.T) {
+	userFile, repo := setUserConfigDir(t), t.TempDir()
+	schema, flags := newTestSchema()
+	schema.Enums = nil
+	schema.ValidateAlgorithm = func(name string) error { return nil }
+	schema.ResolveAlgorithm = func(name string, src Source) (string, error) {
+		if !src.Trusted {
+			return "", fmt.Errorf("%v is not allowed", name)
+		}
+		return filepath.Join(src.Dir, name), nil
+	}
+	schema.ResolveParam = func(algorithm, name, value string, src Source) (string, string, error) {
+		return name, filepath.Join(src.Dir, value), nil
+	}
+
+	writeFile(t, userFile, `
+algorithm: bin/x
+algorithm-params:
+  bin/x:
+    threshold: a
+`)
+	f, err := Load(repo, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Apply(schema.Flags, func(string) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(userFile)
+	if want := filepath.Join(dir, "bin/x"); flags.algorithm != want {
+		t.Errorf("expected algorithm to be resolved to %v, got %v", want, flags.algorithm)
+	}
+	if params := f.AlgorithmParams(filepath.Join(dir, "bin/x")); params["threshold"] != filepath.Join(dir, "a") {
+		t.Errorf("expected params to be resolved, got %v", params)
+	}
+
+	// Repository config files are not trusted
+	writeFile(t, filepath.Join(repo, ".iccheck.yaml"), "algorithm: bin/x\n")
+	if _, err = Load(repo, schema); err == nil || !strings.Contains(err.Error(), "bin/x is not allowed") {
+		t.Errorf("expected algorithm in repository config file to be rejected, got %v", err)
+	}
+}
+
+func TestLoad_Untrusted(t *testing.T) {
+	userFile, repo := setUserConfigDir(t), t.TempDir()
+
+	// Repository config files can only tune clone detection
+	writeFile(t, filepath.Join(repo, ".iccheck.yaml"), "micro: true\nwrite-baseline: baseline.json\n")
+	schema, _ := newTestSchema()
+	_, err := Load(repo, schema)
+	if err == nil || !strings.Contains(err.Error(), `line 2: "write-baseline" cannot be set by repository config files`) {
+		t.Errorf("expected write-baseline in repository config file to be rejected, got %v", err)
+	}
+
+	// The user-level config file can set any flag
+	writeFile(t, filepath.Join(repo, ".iccheck.yaml"), "micro: true\n")
+	writeFile(t, userFile, "write-baseline: baseline.json\n")
+	f, err := Load(repo, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Apply(schema.Flags, func(string) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := schema.Flags.GetString("write-baseline"); v != "baseline.json" {
+		t.Errorf("expected write-baseline to be set by the user-level config file, got %q", v)
+	}
+}
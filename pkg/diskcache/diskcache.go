@@ -0,0 +1,260 @@
+// Package diskcache persists per-file data which is expensive to calculate, such as line bigrams, across runs.
+// Entries are keyed by git blob hashes of file contents, so that unchanged files are loaded instead of recalculated.
+package diskcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/files"
+	"github.com/salab/iccheck/pkg/utils/strs"
+)
+
+// bigramsDir contains the format version, so that entries of older formats are ignored (and pruned).
+const (
+	bigramsDirPrefix = "bigrams-v"
+	bigramsDir       = bigramsDirPrefix + "1"
+)
+
+var bigramsMagic = []byte("ICB1")
+
+// minContentSize is the minimum file size to cache, since small files are faster to calculate than to load.
+const minContentSize = 1024
+
+// touchInterval is the interval to update modification times of used entries, which are used for pruning the least recently used entries.
+const touchInterval = 24 * time.Hour
+
+// DefaultDir returns the default cache directory, such as $XDG_CACHE_HOME/iccheck.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "determining user cache directory")
+	}
+	return filepath.Join(dir, "iccheck"), nil
+}
+
+// Cache is a persistent cache in a directory.
+// nil *Cache is valid, and calculates everything without caching.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	written atomic.Int64
+}
+
+// New returns a cache stored in the directory, limited to roughly maxSize bytes.
+func New(dir string, maxSize int64) *Cache {
+	return &Cache{dir: dir, maxSize: maxSize}
+}
+
+func (c *Cache) entryPath(hash plumbing.Hash) string {
+	h := hash.String()
+	return filepath.Join(c.dir, bigramsDir, h[:2], h[2:])
+}
+
+// LengthsAndBigrams is the same as files.LengthsAndBigrams of the whole content, but loads the result from the cache if available.
+func (c *Cache) LengthsAndBigrams(content []byte) (lengths []int, bigrams []strs.BigramSet) {
+	if c == nil || len(content) < minContentSize {
+		return files.LengthsAndBigrams(content, 1, -1)
+	}
+
+	path := c.entryPath(plumbing.ComputeHash(plumbing.BlobObject, content))
+	lengths, bigrams, err := readBigrams(path)
+	if err == nil {
+		return lengths, bigrams
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		slog.Debug("failed to read cache entry", "path", path, "err", err)
+	}
+
+	lengths, bigrams = files.LengthsAndBigrams(content, 1, -1)
+	if err = writeBigrams(path, lengths, bigrams); err != nil {
+		slog.Debug("failed to write cache entry", "path", path, "err", err)
+	}
+	c.written.Add(1)
+	return lengths, bigrams
+}
+
+// Entry format: magic, number of lines, (line length, number of bigrams) for each line, then all bigrams in little endian.
+func readBigrams(path string) (lengths []int, bigrams []strs.BigramSet, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.HasPrefix(b, bigramsMagic) {
+		return nil, nil, errors.New("invalid magic")
+	}
+	r := bytes.NewReader(b[len(bigramsMagic):])
+	nLines, err := binary.ReadUvarint(r)
+	if err != nil || nLines > uint64(len(b)) {
+		return nil, nil, errors.New("invalid number of lines")
+	}
+	lengths = make([]int, nLines)
+	counts := make([]int, nLines)
+	total := 0
+	for i := range lengths {
+		length, err1 := binary.ReadUvarint(r)
+		count, err2 := binary.ReadUvarint(r)
+		if err1 != nil || err2 != nil {
+			return nil, nil, errors.New("truncated line header")
+		}
+		lengths[i], counts[i] = int(length), int(count)
+		total += int(count)
+	}
+	data := b[len(b)-r.Len():]
+	if len(data) != total*2 {
+		return nil, nil, errors.New("truncated bigrams")
+	}
+
+	// Same memory layout as strs.CompactBigrams
+	underlying := make([]uint16, total)
+	for i := range underlying {
+		underlying[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	bigrams = make([]strs.BigramSet, nLines)
+	idx := 0
+	for i, count := range counts {
+		bigrams[i] = underlying[idx : idx+count]
+		idx += count
+	}
+
+	touch(path)
+	return lengths, bigrams, nil
+}
+
+// touch updates the modification time of the entry, if not updated recently.
+func touch(path string) {
+	stat, err := os.Stat(path)
+	if err != nil || time.Since(stat.ModTime()) < touchInterval {
+		return
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+func writeBigrams(path string, lengths []int, bigrams []strs.BigramSet) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first, since other processes may read the entry concurrently
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	b := slices.Clone(bigramsMagic)
+	b = binary.AppendUvarint(b, uint64(len(lengths)))
+	for i := range lengths {
+		b = binary.AppendUvarint(b, uint64(lengths[i]))
+		b = binary.AppendUvarint(b, uint64(len(bigrams[i])))
+	}
+	for _, set := range bigrams {
+		for _, bigram := range set {
+			b = binary.LittleEndian.AppendUint16(b, bigram)
+		}
+	}
+	if _, err = f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// PruneIfNeeded prunes the cache if new entries were written in this process.
+func (c *Cache) PruneIfNeeded() error {
+	if c == nil || c.written.Load() == 0 {
+		return nil
+	}
+	removed, freed, err := Prune(c.dir, c.maxSize)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		slog.Debug("Pruned cache", "removed", removed, "freed", freed)
+	}
+	return nil
+}
+
+// Prune removes the least recently used entries until the total size of the cache becomes maxSize bytes or less,
+// and removes entries of older formats.
+func Prune(dir string, maxSize int64) (removed int, freed int64, err error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "reading cache directory %v", dir)
+	}
+
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var cacheFiles []file
+	for _, entry := range entries {
+		// Do not touch unknown files, in case the cache directory is shared with others
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), bigramsDirPrefix) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if entry.Name() != bigramsDir {
+				// Older formats
+				removed++
+				freed += info.Size()
+				return os.Remove(path)
+			}
+			cacheFiles = append(cacheFiles, file{path, info.Size(), info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return removed, freed, errors.Wrapf(err, "pruning cache directory %v", path)
+		}
+		if entry.Name() != bigramsDir {
+			_ = os.RemoveAll(path)
+		}
+	}
+
+	// Remove from the least recently used ones
+	slices.SortFunc(cacheFiles, ds.SortAsc(func(f file) int64 { return f.modTime.UnixNano() }))
+	var size int64
+	for _, f := range cacheFiles {
+		size += f.size
+	}
+	for _, f := range cacheFiles {
+		if size <= maxSize {
+			break
+		}
+		if err = os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, freed, errors.Wrapf(err, "removing %v", f.path)
+		}
+		size -= f.size
+		removed++
+		freed += f.size
+	}
+	return removed, freed, nil
+}
@@ -0,0 +1,38 @@
+package diskcache
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/utils/files"
+)
+
+func TestCache_LengthsAndBigrams(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte(strings.Repeat("func main() {\n\tfmt.Println(\"hello\")\n}\n\n", 50))
+	expectedLengths, expectedBigrams := files.LengthsAndBigrams(content, 1, -1)
+
+	// First call writes the entry, and second call reads it
+	for i := 0; i < 2; i++ {
+		c := New(dir, 1<<20)
+		lengths, bigrams := c.LengthsAndBigrams(content)
+		if !slices.Equal(lengths, expectedLengths) {
+			t.Fatalf("call %d: unexpected lengths", i)
+		}
+		if !slices.EqualFunc(bigrams, expectedBigrams, slices.Equal) {
+			t.Fatalf("call %d: unexpected bigrams", i)
+		}
+		if written := c.written.Load(); written != int64(1-i) {
+			t.Errorf("call %d: expected %d written entries, got %d", i, 1-i, written)
+		}
+	}
+
+	removed, _, err := Prune(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 removed entry, got %d", removed)
+	}
+}
@@ -45,6 +45,8 @@ type Clone struct {
 	Distance float64
 	// Sources indicate from which queries this co-change candidate was detected
 	Sources []*Source
+	// SuggestedPatch is a unified diff applying the change of a changed clone to this missing clone, if requested
+	SuggestedPatch string
 }
 
 func (c Clone) Key() string {
@@ -54,6 +56,13 @@ func (c Clone) Key() string {
 type CloneSet struct {
 	Changed []*Clone
 	Missing []*Clone
+	// Deleted indicates that Changed clones were deleted from the base tree
+	// (and therefore Changed clones' locations refer to the base tree),
+	// and Missing clones are their copies still remaining in the target tree.
+	Deleted bool
+	// Standalone indicates that this clone set was detected from the whole tree without any changes
+	// (such as by 'iccheck clones'), and all clones are stored in Missing.
+	Standalone bool
 }
 
 func (cs *CloneSet) Sort() {
@@ -7,8 +7,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/cespare/xxhash"
 	"github.com/salab/iccheck/pkg/utils/ds"
 	"github.com/salab/iccheck/pkg/utils/files"
 	"github.com/samber/lo"
@@ -41,7 +43,16 @@ var defaultIgnoreConfigs = []*IgnoreConfig{
 	},
 }
 
+// ignoreFileBaseName is the base name of ignore files, with extension .yaml or .yml.
+const ignoreFileBaseName = ".iccheckignore"
+
 func ReadMatcherRules(repoDir string, disableDefault bool, ignoreCLIOptions, includeCLIOptions []string) (*MatcherRules, error) {
+	return ReadMatcherRulesWithConfigs(repoDir, disableDefault, nil, ignoreCLIOptions, includeCLIOptions)
+}
+
+// ReadMatcherRulesWithConfigs is the same as ReadMatcherRules, and also adds ignoreConfigs,
+// which are rules from other sources such as the project config file.
+func ReadMatcherRulesWithConfigs(repoDir string, disableDefault bool, ignoreConfigs []*IgnoreConfig, ignoreCLIOptions, includeCLIOptions []string) (*MatcherRules, error) {
 	var matchers MatcherConfigs
 	if !disableDefault {
 		matchers.ignores = append(matchers.ignores, defaultIgnoreConfigs...)
@@ -50,14 +61,13 @@ func ReadMatcherRules(repoDir string, disableDefault bool, ignoreCLIOptions, inc
 	// Check if ignore file is present in the following locations:
 	// 1. ${repoDir}/.iccheckignore.{yaml,yml}
 	// 2. ~/.config/.iccheckignore.{yaml,yml}
-	const baseFileName = ".iccheckignore"
 	paths := []string{
-		filepath.Join(repoDir, baseFileName+".yaml"),
-		filepath.Join(repoDir, baseFileName+".yml"),
+		filepath.Join(repoDir, ignoreFileBaseName+".yaml"),
+		filepath.Join(repoDir, ignoreFileBaseName+".yml"),
 	}
 	if homeDir, err := os.UserHomeDir(); err == nil {
-		paths = append(paths, filepath.Join(homeDir, ".config", baseFileName+".yaml"))
-		paths = append(paths, filepath.Join(homeDir, ".config", baseFileName+".yml"))
+		paths = append(paths, filepath.Join(homeDir, ".config", ignoreFileBaseName+".yaml"))
+		paths = append(paths, filepath.Join(homeDir, ".config", ignoreFileBaseName+".yml"))
 	}
 	for _, path := range paths {
 		if f, err := os.Open(path); err == nil {
@@ -70,6 +80,8 @@ func ReadMatcherRules(repoDir string, disableDefault bool, ignoreCLIOptions, inc
 		// Ignore os.Open() error - file might not exist
 	}
 
+	matchers.ignores = append(matchers.ignores, ignoreConfigs...)
+
 	// Parse CLI options, if any
 	for _, ignoreCLIOption := range ignoreCLIOptions {
 		config, err := readIgnoreCLIOption(ignoreCLIOption)
@@ -83,11 +95,72 @@ func ReadMatcherRules(repoDir string, disableDefault bool, ignoreCLIOptions, inc
 	return matchers.Compile()
 }
 
+// DefaultMatcherRules returns the default ignore rules, without reading any ignore files.
+func DefaultMatcherRules() *MatcherRules {
+	matchers := MatcherConfigs{ignores: defaultIgnoreConfigs}
+	rules, err := matchers.Compile()
+	if err != nil {
+		panic(fmt.Sprintf("compiling default ignore rules: %v", err))
+	}
+	return rules
+}
+
 type IgnoreConfig struct {
 	Files    []string `yaml:"files"`
 	Patterns []string `yaml:"patterns"`
 }
 
+// IgnoreConfigForLines returns a rule to ignore the given lines in the file, wherever they are in the file.
+// Leading and trailing whitespaces of each line are not considered.
+func IgnoreConfigForLines(path string, lines []string) *IgnoreConfig {
+	patterns := ds.Map(lines, func(line string) string {
+		return `[ \t]*` + regexp.QuoteMeta(strings.TrimSpace(line)) + `[ \t]*`
+	})
+	return &IgnoreConfig{
+		Files:    []string{"^" + regexp.QuoteMeta(filepath.ToSlash(path)) + "$"},
+		Patterns: []string{"^" + strings.Join(patterns, `\r?\n`) + "$"},
+	}
+}
+
+// AppendIgnoreConfigs appends rules to the ignore file in repoDir, creating .iccheckignore.yaml if none exists.
+// The comment is written above the rules. Returns the path of the written file.
+func AppendIgnoreConfigs(repoDir string, comment string, configs []*IgnoreConfig) (string, error) {
+	path := filepath.Join(repoDir, ignoreFileBaseName+".yaml")
+	if _, err := os.Stat(path); err != nil {
+		ymlPath := filepath.Join(repoDir, ignoreFileBaseName+".yml")
+		if _, err = os.Stat(ymlPath); err == nil {
+			path = ymlPath
+		}
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var buf strings.Builder
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		buf.WriteString("\n")
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		buf.WriteString("# " + line + "\n")
+	}
+	out, err := yaml.Marshal(configs)
+	if err != nil {
+		return "", fmt.Errorf("encoding ignore rules: %w", err)
+	}
+	buf.Write(out)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(buf.String()); err != nil {
+		return "", fmt.Errorf("writing %s: %w", path, err)
+	}
+	return path, nil
+}
+
 func readIgnoreCLIOption(opt string) (*IgnoreConfig, error) {
 	parts := strings.Split(opt, ":")
 	if len(parts) != 1 && len(parts) != 2 {
@@ -239,6 +312,11 @@ func (m *MatcherRules) Match(path string, contents []byte) (skipEntireFile bool,
 		}
 	}
 
+	// Inline suppression comments
+	if suppressed := SuppressedLines(path, contents); len(suppressed) > 0 {
+		mergedIgnoreLines = ds.MergeMap(mergedIgnoreLines, suppressed)
+	}
+
 	return false, &IgnoreLineRule{
 		IgnoreLines: mergedIgnoreLines,
 		safeUntil:   -1,
@@ -247,22 +325,39 @@ func (m *MatcherRules) Match(path string, contents []byte) (skipEntireFile bool,
 
 type IgnoreLineRule struct {
 	IgnoreLines map[int]struct{}
-	safeUntil   int
+	// Lines from safeFrom to safeUntil (inclusive) are already checked not to be ignored
+	safeFrom  int
+	safeUntil int
+}
+
+// Hash returns a hash of ignored lines, so that results calculated with this rule can be cached.
+func (l *IgnoreLineRule) Hash() uint64 {
+	lines := lo.Keys(l.IgnoreLines)
+	sort.Ints(lines)
+	h := xxhash.New()
+	for _, line := range lines {
+		_, _ = h.Write([]byte(strconv.Itoa(line) + ","))
+	}
+	return h.Sum64()
 }
 
 // CanSkip determines if window size starting from startLine can be skipped.
 //
 // Caller expectations:
-//   - Callers are expected to monotonically increase startLine.
+//   - Callers are expected to monotonically increase startLine for efficiency.
+//     startLine may restart from a smaller value (such as for the next query), which triggers checking all lines again.
 //   - Next call to CanSkip() starts from skipUntil+1, if canSkip is true.
 func (l *IgnoreLineRule) CanSkip(startLine int, windowSize int) (canSkip bool, skipUntil int) {
 	searchUpper := startLine + windowSize - 1
+	if startLine < l.safeFrom || l.safeUntil+1 < startLine {
+		l.safeFrom, l.safeUntil = startLine, startLine-1
+	}
 	searchLower := max(startLine, l.safeUntil+1)
 	for i := searchUpper; i >= searchLower; i-- {
 		if _, ok := l.IgnoreLines[i]; ok {
 			return true, i + windowSize - 1
 		}
 	}
-	l.safeUntil = searchUpper
+	l.safeUntil = max(l.safeUntil, searchUpper)
 	return false, -1
 }
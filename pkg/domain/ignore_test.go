@@ -162,6 +162,12 @@ func TestIgnoreLineRule_CanSkip(t *testing.T) {
 	if canSkip != false {
 		t.Errorf("got %v, want %v", canSkip, false)
 	}
+
+	// Restarting from the beginning (e.g. for the next query) should check the lines again
+	canSkip, _ = ignoreLines.CanSkip(0, windowSize)
+	if canSkip != true {
+		t.Errorf("got %v, want %v", canSkip, true)
+	}
 }
 
 func TestIgnoreRules_Match(t *testing.T) {
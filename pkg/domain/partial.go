@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// Coverage is the number of files searched, out of all files to search.
+type Coverage struct {
+	SearchedFiles int
+	TotalFiles    int
+}
+
+func (c Coverage) Add(other Coverage) Coverage {
+	return Coverage{
+		SearchedFiles: c.SearchedFiles + other.SearchedFiles,
+		TotalFiles:    c.TotalFiles + other.TotalFiles,
+	}
+}
+
+// Percent returns the percentage of searched files.
+func (c Coverage) Percent() float64 {
+	if c.TotalFiles == 0 {
+		return 100
+	}
+	return 100 * float64(c.SearchedFiles) / float64(c.TotalFiles)
+}
+
+func (c Coverage) String() string {
+	return fmt.Sprintf("searched %d out of %d file(s) (%.1f%%)", c.SearchedFiles, c.TotalFiles, c.Percent())
+}
+
+// IncompleteError is returned together with partial results,
+// when a search was interrupted (such as by a timeout) before searching all files.
+type IncompleteError struct {
+	Coverage
+	Err error
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("search incomplete, %v: %v", e.Coverage, e.Err)
+}
+
+func (e *IncompleteError) Unwrap() error {
+	return e.Err
+}
+
+// AsIncomplete returns IncompleteError in the error chain, if any.
+func AsIncomplete(err error) (*IncompleteError, bool) {
+	var incomplete *IncompleteError
+	ok := errors.As(err, &incomplete)
+	return incomplete, ok
+}
+
+// SearchFiles calls fn for each file concurrently, and returns the flattened results.
+//
+// If partial is true and ctx is done before searching all files,
+// results from the files already searched are returned together with *IncompleteError.
+// Otherwise, the first error is returned.
+func SearchFiles[T any](
+	ctx context.Context,
+	filenames []string,
+	partial bool,
+	fn func(ctx context.Context, filename string) ([]T, error),
+) ([]T, error) {
+	var searched atomic.Int64
+	p := pool.NewWithResults[[]T]().
+		WithMaxGoroutines(runtime.NumCPU()).
+		WithErrors().
+		WithContext(ctx).
+		WithCancelOnError().
+		WithFirstError()
+	for _, filename := range filenames {
+		p.Go(func(ctx context.Context) ([]T, error) {
+			results, err := fn(ctx, filename)
+			if err != nil {
+				if partial && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+					return nil, nil // Discard results of this file, and keep the others
+				}
+				return nil, err
+			}
+			searched.Add(1)
+			return results, nil
+		})
+	}
+	results, err := p.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	if partial && ctx.Err() != nil && int(searched.Load()) < len(filenames) {
+		return lo.Flatten(results), &IncompleteError{
+			Coverage: Coverage{SearchedFiles: int(searched.Load()), TotalFiles: len(filenames)},
+			Err:      ctx.Err(),
+		}
+	}
+	return lo.Flatten(results), nil
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSearchFiles(t *testing.T) {
+	search := func(partial bool) ([]int, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		return SearchFiles(ctx, []string{"a", "b", "c"}, partial, func(ctx context.Context, filename string) ([]int, error) {
+			if filename == "a" {
+				return []int{1}, nil
+			}
+			cancel()
+			return nil, ctx.Err()
+		})
+	}
+
+	results, err := search(true)
+	incomplete, ok := AsIncomplete(err)
+	if !ok {
+		t.Fatalf("expected incomplete error, got %v", err)
+	}
+	if !reflect.DeepEqual([]int{1}, results) {
+		t.Errorf("expected results of searched files, got %v", results)
+	}
+	if expected := (Coverage{SearchedFiles: 1, TotalFiles: 3}); incomplete.Coverage != expected {
+		t.Errorf("expected coverage %v, got %v", expected, incomplete.Coverage)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled in the error chain, got %v", err)
+	}
+
+	results, err = search(false)
+	if _, ok := AsIncomplete(err); ok || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if results != nil {
+		t.Errorf("expected no results, got %v", results)
+	}
+}
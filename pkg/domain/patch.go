@@ -0,0 +1,198 @@
+package domain
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/pkg/errors"
+)
+
+const devNull = "/dev/null"
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// unifiedDiffParser keeps track of the file patch currently being parsed.
+type unifiedDiffParser struct {
+	strip   int
+	patches []fdiff.FilePatch
+
+	current  *worktreeFilePatch
+	fromPath string
+	toPath   string
+	isBinary bool
+	// Current line position (1-indexed) of the base and target file, used to fill gaps between hunks.
+	fromL, toL int
+}
+
+// ParseUnifiedDiff parses unified diff (such as output of 'git diff' or 'diff -u') into file patches.
+//
+// Unchanged regions between hunks are filled with placeholder Equal chunks
+// so that line numbers can be calculated from the returned chunks, in the same way as DiffTrees.
+//
+// strip specifies the number of leading path components to remove from file paths, like 'patch -p'.
+// Use 1 for patches produced by git, which have "a/" and "b/" prefixes.
+func ParseUnifiedDiff(r io.Reader, strip int) ([]fdiff.FilePatch, error) {
+	p := unifiedDiffParser{strip: strip}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "diff "):
+			p.flush()
+		case strings.HasPrefix(line, "--- "):
+			// Some diff tools (such as 'diff -u') do not output 'diff' lines
+			if p.current != nil {
+				p.flush()
+			}
+			p.fromPath = parseDiffFilePath(line[len("--- "):], p.strip)
+		case strings.HasPrefix(line, "+++ "):
+			p.toPath = parseDiffFilePath(line[len("+++ "):], p.strip)
+		case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+			p.isBinary = true
+		case strings.HasPrefix(line, "@@ "):
+			if err := p.readHunk(scanner, line); err != nil {
+				return nil, err
+			}
+		}
+		// Other extended header lines (index, mode, rename, similarity, ...) are not needed
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading patch")
+	}
+	p.flush()
+
+	return p.patches, nil
+}
+
+func parseDiffFilePath(s string, strip int) string {
+	// 'diff -u' appends timestamp after a tab
+	if idx := strings.IndexByte(s, '\t'); idx >= 0 {
+		s = s[:idx]
+	}
+	// git quotes paths containing special characters
+	if strings.HasPrefix(s, `"`) {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			s = unquoted
+		}
+	}
+	if s == devNull {
+		return ""
+	}
+	for i := 0; i < strip; i++ {
+		_, after, found := strings.Cut(strings.TrimLeft(s, "/"), "/")
+		if !found {
+			break
+		}
+		s = after
+	}
+	return s
+}
+
+func (p *unifiedDiffParser) ensureCurrent() {
+	if p.current != nil {
+		return
+	}
+	p.current = &worktreeFilePatch{}
+	if p.fromPath != "" {
+		p.current.from = &diffFile{p.fromPath}
+	}
+	if p.toPath != "" {
+		p.current.to = &diffFile{p.toPath}
+	}
+	p.fromL, p.toL = 1, 1
+}
+
+func (p *unifiedDiffParser) flush() {
+	if p.current == nil && (p.fromPath != "" || p.toPath != "") {
+		// A patch without hunks, such as binary or mode-only changes
+		p.ensureCurrent()
+	}
+	if p.current != nil {
+		if p.isBinary {
+			p.current.chunks = nil
+		}
+		p.patches = append(p.patches, p.current)
+	}
+	p.current = nil
+	p.fromPath, p.toPath = "", ""
+	p.isBinary = false
+}
+
+func (p *unifiedDiffParser) appendChunk(typ fdiff.Operation, content string) {
+	chunks := p.current.chunks
+	if len(chunks) > 0 {
+		if last := chunks[len(chunks)-1].(*worktreeChunk); last.typ == typ {
+			last.content += content
+			return
+		}
+	}
+	p.current.chunks = append(chunks, &worktreeChunk{content: content, typ: typ})
+}
+
+func (p *unifiedDiffParser) readHunk(scanner *bufio.Scanner, header string) error {
+	m := hunkHeaderRegexp.FindStringSubmatch(header)
+	if m == nil {
+		return errors.Errorf("invalid hunk header: %v", header)
+	}
+	fromStart, _ := strconv.Atoi(m[1])
+	fromLines := 1
+	if m[2] != "" {
+		fromLines, _ = strconv.Atoi(m[2])
+	}
+	toStart, _ := strconv.Atoi(m[3])
+	toLines := 1
+	if m[4] != "" {
+		toLines, _ = strconv.Atoi(m[4])
+	}
+	// Empty ranges denote the line just before the hunk
+	if fromLines == 0 {
+		fromStart++
+	}
+	if toLines == 0 {
+		toStart++
+	}
+
+	p.ensureCurrent()
+	if gap := max(fromStart-p.fromL, toStart-p.toL); gap > 0 {
+		p.appendChunk(fdiff.Equal, strings.Repeat("\n", gap))
+	}
+	p.fromL, p.toL = fromStart, toStart
+
+	for fromLines > 0 || toLines > 0 {
+		if !scanner.Scan() {
+			return errors.Errorf("unexpected end of patch in hunk %v", header)
+		}
+		line := scanner.Text()
+		if line == "" {
+			// Some tools strip the trailing whitespace of empty context lines
+			line = " "
+		}
+		switch line[0] {
+		case ' ':
+			p.appendChunk(fdiff.Equal, line[1:]+"\n")
+			fromLines--
+			toLines--
+			p.fromL++
+			p.toL++
+		case '-':
+			p.appendChunk(fdiff.Delete, line[1:]+"\n")
+			fromLines--
+			p.fromL++
+		case '+':
+			p.appendChunk(fdiff.Add, line[1:]+"\n")
+			toLines--
+			p.toL++
+		case '\\':
+			// "\ No newline at end of file"
+		default:
+			return errors.Errorf("unexpected line in hunk %v: %v", header, line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+)
+
+func TestParseUnifiedDiff(t *testing.T) {
+	const patch = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -2,3 +2,3 @@ package main
+ import "fmt"
+-func a() {}
++func b() {}
+
+@@ -10,2 +10,3 @@ func main() {
+ 	fmt.Println("a")
++	fmt.Println("b")
+ }
+diff --git a/new.txt b/new.txt
+new file mode 100644
+--- /dev/null
++++ b/new.txt
+@@ -0,0 +1,2 @@
++hello
++world
+\ No newline at end of file
+diff --git a/old.txt b/old.txt
+deleted file mode 100644
+--- a/old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+diff --git a/image.png b/image.png
+--- a/image.png
++++ b/image.png
+Binary files a/image.png and b/image.png differ
+`
+
+	type chunk struct {
+		typ   fdiff.Operation
+		lines int
+	}
+	type filePatch struct {
+		from, to string
+		binary   bool
+		chunks   []chunk
+	}
+	want := []filePatch{
+		{"main.go", "main.go", false, []chunk{
+			{fdiff.Equal, 2}, // gap and context line
+			{fdiff.Delete, 1}, {fdiff.Add, 1},
+			{fdiff.Equal, 7}, // context line, gap and context line
+			{fdiff.Add, 1},
+			{fdiff.Equal, 1},
+		}},
+		{"", "new.txt", false, []chunk{{fdiff.Add, 2}}},
+		{"old.txt", "", false, []chunk{{fdiff.Delete, 1}}},
+		{"image.png", "image.png", true, nil},
+	}
+
+	got, err := ParseUnifiedDiff(strings.NewReader(patch), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d file patches, want %d", len(got), len(want))
+	}
+	for i, fp := range got {
+		from, to := fp.Files()
+		var g filePatch
+		if from != nil {
+			g.from = from.Path()
+		}
+		if to != nil {
+			g.to = to.Path()
+		}
+		g.binary = fp.IsBinary()
+		for _, c := range fp.Chunks() {
+			g.chunks = append(g.chunks, chunk{c.Type(), strings.Count(c.Content(), "\n")})
+		}
+		if !reflect.DeepEqual(g, want[i]) {
+			t.Errorf("file patch #%d: got %+v, want %+v", i, g, want[i])
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package domain
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/cespare/xxhash"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/binary"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	"github.com/pkg/errors"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/files"
+	"github.com/samber/lo"
+)
+
+// renameScore is the minimum similarity (in percent) for a pair of deleted and inserted files
+// to be considered as a rename.
+// The same value as the commit-to-commit comparison (object.DefaultDiffTreeOptions) is used.
+var renameScore = int(object.DefaultDiffTreeOptions.RenameScore)
+
+// maxRenameCandidatePairs limits the number of file pairs to compare contents for.
+// Exact renames are always detected.
+const maxRenameCandidatePairs = 100_000
+
+// lineSimilarityIndex is a multiset of line hashes in a file.
+type lineSimilarityIndex struct {
+	lines  map[uint64]int
+	nLines int
+	size   int
+}
+
+func newLineSimilarityIndex(content []byte) *lineSimilarityIndex {
+	idx := &lineSimilarityIndex{lines: make(map[uint64]int), size: len(content)}
+	for len(content) > 0 {
+		var line []byte
+		line, content, _ = bytes.Cut(content, []byte{'\n'})
+		idx.lines[xxhash.Sum64(line)]++
+		idx.nLines++
+	}
+	return idx
+}
+
+// score returns similarity of two files in percent.
+func (i *lineSimilarityIndex) score(other *lineSimilarityIndex) int {
+	if i.nLines+other.nLines == 0 {
+		return 100
+	}
+	common := 0
+	for h, cnt := range i.lines {
+		common += min(cnt, other.lines[h])
+	}
+	return 2 * common * 100 / (i.nLines + other.nLines)
+}
+
+// detectRenames pairs deleted and inserted files in the changes, and converts them into modifications
+// if they have similar contents.
+//
+// This is a simplified version of go-git's object.DetectRenames, which only works on git tree objects.
+func detectRenames(base, target Tree, changes merkletrie.Changes) (merkletrie.Changes, error) {
+	var deleted, inserted []int
+	for i, c := range changes {
+		action, err := c.Action()
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving action for a change")
+		}
+		// Submodules have no contents to compare, and cannot be read from trees
+		switch {
+		case action == merkletrie.Delete && !nodeIsSubmodule(c.From):
+			deleted = append(deleted, i)
+		case action == merkletrie.Insert && !nodeIsSubmodule(c.To):
+			inserted = append(inserted, i)
+		}
+	}
+	if len(deleted) == 0 || len(inserted) == 0 {
+		return changes, nil
+	}
+
+	type pair struct {
+		from, to int
+		score    int
+	}
+	var pairs []pair
+
+	// Exact renames
+	paired := make(map[int]bool)
+	for _, d := range deleted {
+		fromHash := changes[d].From.Hash()[:20]
+		for _, i := range inserted {
+			if paired[i] {
+				continue
+			}
+			if bytes.Equal(fromHash, changes[i].To.Hash()[:20]) {
+				pairs = append(pairs, pair{d, i, 100})
+				paired[d], paired[i] = true, true
+				break
+			}
+		}
+	}
+
+	// Content renames
+	deleted = lo.Filter(deleted, func(i int, _ int) bool { return !paired[i] })
+	inserted = lo.Filter(inserted, func(i int, _ int) bool { return !paired[i] })
+	if len(deleted)*len(inserted) <= maxRenameCandidatePairs {
+		readIndex := func(tree Tree, path string) (*lineSimilarityIndex, error) {
+			content, err := files.ReadAll(tree.Reader(path))
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading %v", path)
+			}
+			if isBinary, _ := binary.IsBinary(bytes.NewReader(content)); isBinary {
+				return nil, nil
+			}
+			return newLineSimilarityIndex(content), nil
+		}
+		fromIndices, err := ds.MapError(deleted, func(d int) (*lineSimilarityIndex, error) {
+			return readIndex(base, changes[d].From.String())
+		})
+		if err != nil {
+			return nil, err
+		}
+		toIndices, err := ds.MapError(inserted, func(i int) (*lineSimilarityIndex, error) {
+			return readIndex(target, changes[i].To.String())
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var candidates []pair
+		for di, fromIdx := range fromIndices {
+			if fromIdx == nil {
+				continue
+			}
+			for ii, toIdx := range toIndices {
+				if toIdx == nil {
+					continue
+				}
+				// Quick check: file sizes are too different to be a match
+				if min(fromIdx.size, toIdx.size)*100 < renameScore*max(fromIdx.size, toIdx.size) {
+					continue
+				}
+				if score := fromIdx.score(toIdx); score >= renameScore {
+					candidates = append(candidates, pair{deleted[di], inserted[ii], score})
+				}
+			}
+		}
+		// Greedily take the most similar pairs first
+		slices.SortStableFunc(candidates, ds.SortDesc(func(p pair) int { return p.score }))
+		for _, p := range candidates {
+			if paired[p.from] || paired[p.to] {
+				continue
+			}
+			pairs = append(pairs, p)
+			paired[p.from], paired[p.to] = true, true
+		}
+	}
+
+	// Rebuild changes, replacing each pair of deletion and insertion with a single modification
+	ret := make(merkletrie.Changes, 0, len(changes)-len(pairs))
+	renamedTo := make(map[int]int, len(pairs))
+	for _, p := range pairs {
+		renamedTo[p.from] = p.to
+	}
+	for i, c := range changes {
+		if to, ok := renamedTo[i]; ok {
+			ret = append(ret, merkletrie.Change{From: c.From, To: changes[to].To})
+			continue
+		}
+		if paired[i] {
+			continue // Inserted side of a rename
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
@@ -0,0 +1,151 @@
+package domain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+func TestDiffTrees_detectRenames(t *testing.T) {
+	const content = `line 1
+line 2
+line 3
+line 4
+line 5
+`
+	baseDir, targetDir := t.TempDir(), t.TempDir()
+	writeFile := func(dir, name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(baseDir, "moved.txt", content)
+	writeFile(targetDir, "renamed.txt", strings.Replace(content, "line 3", "line three", 1))
+	writeFile(baseDir, "deleted.txt", "foo\n")
+	writeFile(targetDir, "added.txt", "bar\n")
+
+	base, err := NewFileSystemTree(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := NewFileSystemTree(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := DiffTrees(base, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, p := range patches {
+		from, to := p.Files()
+		var fromPath, toPath string
+		if from != nil {
+			fromPath = from.Path()
+		}
+		if to != nil {
+			toPath = to.Path()
+		}
+		got[fromPath] = toPath
+	}
+	want := map[string]string{
+		"moved.txt":   "renamed.txt",
+		"deleted.txt": "",
+		"":            "added.txt",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for from, to := range want {
+		if got[from] != to {
+			t.Errorf("got %v -> %v, want %v -> %v", from, got[from], from, to)
+		}
+	}
+}
+
+func TestDiffTrees_submodules(t *testing.T) {
+	const content = "line 1\nline 2\nline 3\nline 4\nline 5\n"
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The submodule commit does not exist in this repository
+	err = repo.Storer.SetIndex(&index.Index{Version: 2, Entries: []*index.Entry{
+		{Name: "moved.txt", Hash: blob, Mode: filemode.Regular, Size: uint32(len(content))},
+		{Name: "sub", Hash: plumbing.NewHash("0123456789abcdef0123456789abcdef01234567"), Mode: filemode.Submodule},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := NewGoGitIndexTree(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(targetDir, "renamed.txt"), []byte(strings.Replace(content, "line 3", "line three", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target, err := NewFileSystemTree(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := DiffTrees(base, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, p := range patches {
+		from, to := p.Files()
+		var fromPath, toPath string
+		if from != nil {
+			fromPath = from.Path()
+		}
+		if to != nil {
+			toPath = to.Path()
+		}
+		got[fromPath] = toPath
+		if fromPath == "sub" && len(p.Chunks()) != 0 {
+			t.Errorf("expected no chunks for the submodule, got %d", len(p.Chunks()))
+		}
+	}
+	want := map[string]string{
+		"moved.txt": "renamed.txt",
+		"sub":       "",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for from, to := range want {
+		if got[from] != to {
+			t.Errorf("got %v -> %v, want %v -> %v", from, got[from], from, to)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package domain
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/salab/iccheck/pkg/utils/ds"
+)
+
+// Inline suppression markers, written in comments of source files.
+const (
+	// suppressLine suppresses the line containing the marker (used as a trailing comment).
+	suppressLine = "ignore-line"
+	// suppressNextLine suppresses the line next to the marker.
+	suppressNextLine = "ignore-next-line"
+	// suppressStart suppresses all lines from the marker to the corresponding suppressEnd marker.
+	suppressStart = "ignore-start"
+	suppressEnd   = "ignore-end"
+	// suppressDivergent marks the next line (or the same line, if used as a trailing comment)
+	// as intentionally divergent from its clones. Text after the marker is not read, and can be used to note the reason.
+	suppressDivergent = "divergent"
+)
+
+// commentPrefixes lists line comment (or block comment start) prefixes for each file extension.
+// Files with unknown extensions are checked against all prefixes in defaultCommentPrefixes.
+// To contributors: Feel free to add more languages.
+var commentPrefixes = map[string][]string{
+	".c":     {"//", "/*"},
+	".h":     {"//", "/*"},
+	".cc":    {"//", "/*"},
+	".cpp":   {"//", "/*"},
+	".hpp":   {"//", "/*"},
+	".cs":    {"//", "/*"},
+	".go":    {"//", "/*"},
+	".java":  {"//", "/*"},
+	".kt":    {"//", "/*"},
+	".scala": {"//", "/*"},
+	".swift": {"//", "/*"},
+	".rs":    {"//", "/*"},
+	".js":    {"//", "/*"},
+	".mjs":   {"//", "/*"},
+	".jsx":   {"//", "/*"},
+	".ts":    {"//", "/*"},
+	".mts":   {"//", "/*"},
+	".tsx":   {"//", "/*"},
+	".php":   {"//", "/*", "#"},
+	".css":   {"/*"},
+	".scss":  {"//", "/*"},
+	".py":    {"#"},
+	".rb":    {"#"},
+	".pl":    {"#"},
+	".r":     {"#"},
+	".sh":    {"#"},
+	".bash":  {"#"},
+	".zsh":   {"#"},
+	".yaml":  {"#"},
+	".yml":   {"#"},
+	".toml":  {"#"},
+	".sql":   {"--", "/*"},
+	".lua":   {"--"},
+	".hs":    {"--"},
+	".html":  {"<!--"},
+	".xml":   {"<!--"},
+	".vue":   {"//", "/*", "<!--"},
+	".md":    {"<!--"},
+	".clj":   {";"},
+	".lisp":  {";"},
+	".el":    {";"},
+	".erl":   {"%"},
+	".tex":   {"%"},
+	".vim":   {`"`},
+}
+
+var defaultCommentPrefixes = []string{"//", "/*", "#", "--", "<!--", ";", "%"}
+
+// suppressRegexps holds regexps to find suppression markers for each file extension ("" for unknown extensions).
+// Markers are of form "iccheck:<kind>" placed right after the comment prefix, such as "// iccheck:ignore-next-line".
+var suppressRegexps = func() map[string]*regexp.Regexp {
+	compile := func(prefixes []string) *regexp.Regexp {
+		kinds := []string{suppressNextLine, suppressLine, suppressStart, suppressEnd, suppressDivergent}
+		return regexp.MustCompile(`(?:^|\s)(?:` + strings.Join(ds.Map(prefixes, regexp.QuoteMeta), "|") + `)\s*iccheck:(` +
+			strings.Join(kinds, "|") + `)\b`)
+	}
+	ret := make(map[string]*regexp.Regexp, len(commentPrefixes)+1)
+	for ext, prefixes := range commentPrefixes {
+		ret[ext] = compile(prefixes)
+	}
+	ret[""] = compile(defaultCommentPrefixes)
+	return ret
+}()
+
+// SuppressedLines returns 0-indexed line numbers suppressed by inline suppression comments.
+//
+// Supported markers are:
+//   - iccheck:ignore-line - the line containing the marker
+//   - iccheck:ignore-next-line - the line next to the marker
+//   - iccheck:ignore-start, iccheck:ignore-end - all lines between the markers (inclusive)
+//   - iccheck:divergent - same as ignore-next-line, or ignore-line if the marker follows code
+func SuppressedLines(path string, contents []byte) map[int]struct{} {
+	if !bytes.Contains(contents, []byte("iccheck:")) {
+		return nil // Fast path
+	}
+
+	r, ok := suppressRegexps[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		r = suppressRegexps[""]
+	}
+	lines := bytes.Split(contents, []byte{'\n'})
+	suppressed := make(map[int]struct{})
+	startL := -1
+	for i, line := range lines {
+		m := r.FindSubmatchIndex(line)
+		if m == nil {
+			if startL >= 0 {
+				suppressed[i] = struct{}{}
+			}
+			continue
+		}
+		kind := string(line[m[2]:m[3]])
+		trailing := len(bytes.TrimSpace(line[:m[0]])) > 0
+		switch {
+		case kind == suppressLine, kind == suppressDivergent && trailing:
+			suppressed[i] = struct{}{}
+		case kind == suppressNextLine, kind == suppressDivergent:
+			suppressed[i+1] = struct{}{}
+		case kind == suppressStart:
+			if startL < 0 {
+				startL = i
+			}
+		case kind == suppressEnd:
+			if startL >= 0 {
+				suppressed[i] = struct{}{}
+				startL = -1
+			}
+		}
+		if startL >= 0 {
+			suppressed[i] = struct{}{}
+		}
+	}
+	return suppressed
+}
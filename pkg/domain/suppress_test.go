@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuppressedLines(t *testing.T) {
+	cases := []struct {
+		name     string
+		path     string
+		content  string
+		expected map[int]struct{}
+	}{
+		{
+			"none",
+			"main.go",
+			"a := 1\nb := 2\n",
+			nil,
+		},
+		{
+			"next line",
+			"main.go",
+			"a := 1\n// iccheck:ignore-next-line\nb := 2\nc := 3\n",
+			map[int]struct{}{2: {}},
+		},
+		{
+			"same line",
+			"main.py",
+			"a = 1  # iccheck:ignore-line\nb = 2\n",
+			map[int]struct{}{0: {}},
+		},
+		{
+			"range",
+			"query.sql",
+			"SELECT 1;\n-- iccheck:ignore-start\nSELECT 2;\nSELECT 3;\n-- iccheck:ignore-end\nSELECT 4;\n",
+			map[int]struct{}{1: {}, 2: {}, 3: {}, 4: {}},
+		},
+		{
+			"divergent",
+			"main.go",
+			"// iccheck:divergent - legacy API\na := 1\nb := 2 // iccheck:divergent\n",
+			map[int]struct{}{1: {}, 2: {}},
+		},
+		{
+			"other comment syntax",
+			"main.py",
+			"a = 1\n// iccheck:ignore-next-line\nb = 2\n",
+			map[int]struct{}{},
+		},
+		{
+			"unknown extension",
+			"Makefile",
+			"# iccheck:ignore-next-line\nall:\n",
+			map[int]struct{}{1: {}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := SuppressedLines(c.path, []byte(c.content))
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("got %v, want %v", got, c.expected)
+			}
+		})
+	}
+}
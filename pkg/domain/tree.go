@@ -1,12 +1,14 @@
 package domain
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/binary"
 	"github.com/go-git/go-git/v5/utils/diff"
 	"github.com/go-git/go-git/v5/utils/merkletrie"
 	"github.com/go-git/go-git/v5/utils/merkletrie/noder"
@@ -137,6 +139,10 @@ func DiffTrees(base, target Tree) ([]fdiff.FilePatch, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "diffing nodes")
 	}
+	changes, err = detectRenames(base, target, changes)
+	if err != nil {
+		return nil, errors.Wrap(err, "detecting renames")
+	}
 
 	filePatches, err := ds.MapError(changes, func(c merkletrie.Change) (fdiff.FilePatch, error) {
 		return changeToFilePatch(base, target, c)
@@ -165,6 +171,27 @@ func changeToFilePatch(base, target Tree, c merkletrie.Change) (fdiff.FilePatch,
 		fp.to = &diffFile{toFilePath}
 	}
 
+	// Leave chunks empty for submodules, as their contents are not in the trees
+	if (len(c.From) > 0 && nodeIsSubmodule(c.From)) || (len(c.To) > 0 && nodeIsSubmodule(c.To)) {
+		return &fp, nil
+	}
+
+	if action == merkletrie.Delete {
+		fromFilePath := c.From.String()
+		fromContent, err := files.ReadAll(base.Reader(fromFilePath))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading base file contents %v", fromFilePath)
+		}
+		// Leave chunks empty for binary files
+		isBinary, err := binary.IsBinary(bytes.NewReader(fromContent))
+		if err != nil {
+			return nil, errors.Wrapf(err, "checking binary status of %v", fromFilePath)
+		}
+		if !isBinary && len(fromContent) > 0 {
+			fp.chunks = []fdiff.Chunk{&worktreeChunk{string(fromContent), fdiff.Delete}}
+		}
+	}
+
 	if action == merkletrie.Modify {
 		fromFilePath := c.From.String()
 		fromContent, err := files.ReadAll(base.Reader(fromFilePath))
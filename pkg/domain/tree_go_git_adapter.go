@@ -4,9 +4,9 @@
 // and worktree representation (*git.Worktree).
 // Related issue: https://github.com/go-git/go-git/issues/561
 //
-// This file's implementation does not support detecting renames between a commit and a worktree,
-// as go-git's object.DetectRename function is dependent on object.Changes instance which requires
-// git tree object (storer.EncodedObjectStorer) backend.
+// go-git's object.DetectRenames function is dependent on object.Changes instance which requires
+// git tree object (storer.EncodedObjectStorer) backend, and cannot be used between a commit and a worktree.
+// Renames in such comparisons are detected by our own detectRenames function instead.
 
 package domain
 
@@ -6,14 +6,17 @@ import (
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/utils/merkletrie/filesystem"
+	mindex "github.com/go-git/go-git/v5/utils/merkletrie/index"
 	"github.com/go-git/go-git/v5/utils/merkletrie/noder"
 	"github.com/pkg/errors"
 	"github.com/salab/iccheck/pkg/utils/ds"
 	"github.com/samber/lo"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 )
@@ -28,6 +31,14 @@ func NewGoGitCommitTree(commit *object.Commit, ref string) Tree {
 	return g
 }
 
+// CommitHash returns the commit hash of the tree, if the tree is a git commit.
+func CommitHash(t Tree) (string, bool) {
+	if g, ok := t.(*goGitCommitTree); ok {
+		return g.commit.Hash.String(), true
+	}
+	return "", false
+}
+
 func (g *goGitCommitTree) String() string {
 	return fmt.Sprintf("%s (%s)", g.ref, g.commit.Hash.String())
 }
@@ -57,7 +68,46 @@ func (g *goGitCommitTree) Reader(path string) (io.ReadCloser, error) {
 	return file.Reader()
 }
 
-type goGitIndexTree struct{} // TODO?
+type goGitIndexTree struct {
+	repo *git.Repository
+	idx  *index.Index
+}
+
+// NewGoGitIndexTree returns a tree representing the git index (staging area) of the repository.
+// Comparing a commit against this tree yields only the changes that were staged ('git add'-ed),
+// ignoring any unstaged edits in the worktree.
+func NewGoGitIndexTree(repo *git.Repository) (Tree, error) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading git index")
+	}
+	return &goGitIndexTree{repo: repo, idx: idx}, nil
+}
+
+func (g *goGitIndexTree) String() string {
+	return "INDEX"
+}
+
+func (g *goGitIndexTree) Tree() (t *object.Tree, err error, ok bool) {
+	return nil, nil, false
+}
+
+func (g *goGitIndexTree) Noder() (noder.Noder, error) {
+	return mindex.NewRootNode(g.idx), nil
+}
+
+func (g *goGitIndexTree) Reader(path string) (io.ReadCloser, error) {
+	// Index entries are always recorded in "/"-delimited paths
+	entry, err := g.idx.Entry(filepath.ToSlash(path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving index entry %v", path)
+	}
+	blob, err := g.repo.BlobObject(entry.Hash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving blob %v for %v", entry.Hash, path)
+	}
+	return blob.Reader()
+}
 
 // billyFSGitignore intercepts billy.Filesystem.Readdir() calls to filter out gitignore-d files.
 type billyFSGitignore struct {
@@ -259,4 +309,41 @@ func (g *goGitWorktreeWithOverlay) String() string {
 	return "WORKTREE+Override"
 }
 
-type fileSystemTree struct{} // TODO?
+// fileSystemTree is a tree backed by a plain directory, which does not need to be a git repository.
+// Files are compared by their contents, hashed in the same way as git blobs.
+// ".git" entries in any directory are excluded by the go-git filesystem noder, as in the worktree.
+type fileSystemTree struct {
+	dir string
+	fs  billy.Filesystem
+}
+
+func NewFileSystemTree(dir string) (Tree, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening directory %v", dir)
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("%v is not a directory", dir)
+	}
+	return &fileSystemTree{dir: dir, fs: osfs.New(dir)}, nil
+}
+
+func (f *fileSystemTree) String() string {
+	return f.dir
+}
+
+func (f *fileSystemTree) Tree() (t *object.Tree, err error, ok bool) {
+	return nil, nil, false
+}
+
+func (f *fileSystemTree) Noder() (noder.Noder, error) {
+	return filesystem.NewRootNode(f.fs, nil), nil
+}
+
+func (f *fileSystemTree) Reader(path string) (io.ReadCloser, error) {
+	file, err := f.fs.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving file %v", path)
+	}
+	return file, nil
+}
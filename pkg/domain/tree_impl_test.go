@@ -0,0 +1,169 @@
+package domain
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// changedPaths returns the paths of patches, keyed by the path in the base tree ("" if added).
+func changedPaths(patches []fdiff.FilePatch) map[string]string {
+	got := make(map[string]string)
+	for _, p := range patches {
+		from, to := p.Files()
+		var fromPath, toPath string
+		if from != nil {
+			fromPath = from.Path()
+		}
+		if to != nil {
+			toPath = to.Path()
+		}
+		got[fromPath] = toPath
+	}
+	return got
+}
+
+func readTreeFile(t *testing.T, tree Tree, path string) string {
+	t.Helper()
+	r, err := tree.Reader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGoGitIndexTree(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile := func(name, content string, add bool) {
+		t.Helper()
+		f, err := wt.Filesystem.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if add {
+			if _, err = wt.Add(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeFile("a.go", "a\n", true)
+	writeFile("b.go", "b\n", true)
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	hash, err := wt.Commit("commit", &git.CommitOptions{Author: sig, Committer: sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile("a.go", "staged\n", true)
+	writeFile("a.go", "unstaged\n", false) // Edited again after staging
+	writeFile("c.go", "c\n", true)
+	writeFile("d.go", "d\n", false)
+	writeFile("b.go", "unstaged\n", false)
+
+	tree, err := NewGoGitIndexTree(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := DiffTrees(NewGoGitCommitTree(head, "HEAD"), tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := changedPaths(patches)
+	want := map[string]string{"a.go": "a.go", "": "c.go"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for from, to := range want {
+		if got[from] != to {
+			t.Errorf("got %v -> %v, want %v -> %v", from, got[from], from, to)
+		}
+	}
+	if content := readTreeFile(t, tree, "a.go"); content != "staged\n" {
+		t.Errorf("expected staged content of a.go, got %q", content)
+	}
+}
+
+func TestFileSystemTree(t *testing.T) {
+	baseDir, targetDir := t.TempDir(), t.TempDir()
+	writeFile := func(dir, name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(baseDir, "a.go", "a\n")
+	writeFile(baseDir, "b.go", "b\n")
+	writeFile(targetDir, "a.go", "modified\n")
+	writeFile(targetDir, "b.go", "b\n")
+	writeFile(targetDir, "c.go", "c\n")
+	// Git metadata is not a part of the tree, also in subdirectories such as submodules
+	writeFile(targetDir, ".git/config", "[core]\n")
+	writeFile(targetDir, "sub/.git", "gitdir: ../.git/modules/sub\n")
+
+	base, err := NewFileSystemTree(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := NewFileSystemTree(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches, err := DiffTrees(base, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := changedPaths(patches)
+	want := map[string]string{"a.go": "a.go", "": "c.go"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for from, to := range want {
+		if got[from] != to {
+			t.Errorf("got %v -> %v, want %v -> %v", from, got[from], from, to)
+		}
+	}
+	if content := readTreeFile(t, target, "a.go"); content != "modified\n" {
+		t.Errorf("expected content of a.go, got %q", content)
+	}
+
+	if _, err := NewFileSystemTree(filepath.Join(targetDir, "a.go")); err == nil {
+		t.Errorf("expected error for a file")
+	}
+	if _, err := NewFileSystemTree(filepath.Join(targetDir, "missing")); err == nil {
+		t.Errorf("expected error for a missing directory")
+	}
+}
@@ -0,0 +1,353 @@
+// Package fix suggests patches for missing changes, by transplanting the change of a changed clone
+// onto each missing clone in the same clone set.
+package fix
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/pkg/errors"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/files"
+	"github.com/samber/lo"
+)
+
+const (
+	// windowPadding is the number of lines to look around missing clones, when aligning them with changed clones.
+	windowPadding = 3
+	// maxAlignLines limits the size of regions to align, since alignment is quadratic.
+	maxAlignLines = 1000
+)
+
+// Edit replaces lines from StartL to EndL (1-indexed, inclusive) with Lines.
+// EndL = StartL-1 denotes an insertion before StartL.
+type Edit struct {
+	Filename     string
+	StartL, EndL int
+	Lines        []string
+}
+
+// Suggestion is a suggested change for a missing clone.
+type Suggestion struct {
+	Set     *domain.CloneSet
+	Missing *domain.Clone
+	// Template is the changed clone whose change was transplanted onto Missing
+	Template *domain.Clone
+	Edits    []*Edit
+	// Patch is a unified diff which applies Edits alone
+	Patch string
+}
+
+// rowKind is the kind of line-level alignment between base and target file.
+type rowKind int
+
+const (
+	rowEqual rowKind = iota
+	rowDelete
+	rowInsert
+)
+
+// row is a line-level alignment between base and target file.
+type row struct {
+	kind rowKind
+	// targetL is the target line number (1-indexed), 0 if the line was deleted.
+	targetL int
+	line    string
+}
+
+type fileChange struct {
+	rows []*row
+}
+
+// file is the contents of a file in the target tree.
+type file struct {
+	lines []string
+	// noEOL is true if the file does not end with a newline character
+	noEOL bool
+}
+
+// Suggester suggests patches for missing clones.
+type Suggester struct {
+	toTree  domain.Tree
+	changes map[string]*fileChange
+	files   map[string]*file
+}
+
+// NewSuggester prepares file changes from file patches between the base and target tree.
+// Contents of unchanged lines are read from toTree, so that placeholder chunks from domain.ParseUnifiedDiff can be used.
+func NewSuggester(filePatches []fdiff.FilePatch, toTree domain.Tree) *Suggester {
+	s := &Suggester{
+		toTree:  toTree,
+		changes: make(map[string]*fileChange),
+		files:   make(map[string]*file),
+	}
+	for _, fp := range filePatches {
+		_, to := fp.Files()
+		if to == nil || fp.IsBinary() {
+			continue
+		}
+
+		var rows []*row
+		targetL := 1
+		for _, c := range fp.Chunks() {
+			lines := splitLines(c.Content())
+			for _, line := range lines {
+				switch c.Type() {
+				case fdiff.Equal:
+					rows = append(rows, &row{kind: rowEqual, targetL: targetL})
+					targetL++
+				case fdiff.Add:
+					rows = append(rows, &row{kind: rowInsert, targetL: targetL, line: line})
+					targetL++
+				case fdiff.Delete:
+					rows = append(rows, &row{kind: rowDelete, line: line})
+				}
+			}
+		}
+		// Windows support: clone file names are OS-specific paths
+		s.changes[filepath.Clean(to.Path())] = &fileChange{rows: rows}
+	}
+	return s
+}
+
+// splitLines splits contents into lines, without trailing newline characters.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+func (s *Suggester) file(filename string) (*file, error) {
+	if f, ok := s.files[filename]; ok {
+		return f, nil
+	}
+	content, err := files.ReadAll(s.toTree.Reader(filename))
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %v from %v", filename, s.toTree)
+	}
+	f := &file{
+		lines: splitLines(string(content)),
+		noEOL: len(content) > 0 && content[len(content)-1] != '\n',
+	}
+	s.files[filename] = f
+	return f, nil
+}
+
+func (s *Suggester) fileLines(filename string) ([]string, error) {
+	f, err := s.file(filename)
+	if err != nil {
+		return nil, err
+	}
+	return f.lines, nil
+}
+
+// Suggest suggests patches for missing clones in the given clone sets.
+// Missing clones whose patch could not be determined (e.g. the clone differs from the changed clone
+// around the changed lines) are not included in the result.
+//
+// Clone sets of deleted code and standalone clone sets are ignored.
+func (s *Suggester) Suggest(sets []*domain.CloneSet) ([]*Suggestion, error) {
+	var suggestions []*Suggestion
+	for _, set := range sets {
+		if set.Deleted || set.Standalone {
+			continue
+		}
+		for _, missing := range set.Missing {
+			for _, template := range set.Changed {
+				edits, err := s.transplant(template, missing)
+				if err != nil {
+					return nil, err
+				}
+				if len(edits) == 0 {
+					continue
+				}
+				f, err := s.file(missing.Filename)
+				if err != nil {
+					return nil, err
+				}
+				suggestions = append(suggestions, &Suggestion{
+					Set:      set,
+					Missing:  missing,
+					Template: template,
+					Edits:    edits,
+					Patch:    unifiedDiff(missing.Filename, f, edits),
+				})
+				break
+			}
+		}
+	}
+	return suggestions, nil
+}
+
+// templateRows returns the alignment rows of the changed clone region,
+// including lines deleted right before and after the region.
+func (s *Suggester) templateRows(template *domain.Clone) ([]*row, error) {
+	change, ok := s.changes[template.Filename]
+	if !ok {
+		return nil, nil
+	}
+	first := slices.IndexFunc(change.rows, func(r *row) bool { return r.targetL == template.StartL })
+	last := slices.IndexFunc(change.rows, func(r *row) bool { return r.targetL == template.EndL })
+	if first < 0 || last < 0 || last < first {
+		return nil, nil
+	}
+	for first > 0 && change.rows[first-1].kind == rowDelete {
+		first--
+	}
+	for last+1 < len(change.rows) && change.rows[last+1].kind == rowDelete {
+		last++
+	}
+
+	lines, err := s.fileLines(template.Filename)
+	if err != nil {
+		return nil, err
+	}
+	return ds.Map(change.rows[first:last+1], func(r *row) *row {
+		if r.kind != rowEqual {
+			return r
+		}
+		return &row{kind: rowEqual, targetL: r.targetL, line: lines[r.targetL-1]}
+	}), nil
+}
+
+// hunk is a group of consecutive changed rows.
+type hunk struct {
+	// Indices to the "before" lines: deleted lines are before[start:end], inserted between before[start-1] and before[start].
+	start, end int
+	inserted   []string
+}
+
+// transplant calculates edits to apply the change of the template clone onto the missing clone.
+func (s *Suggester) transplant(template, missing *domain.Clone) ([]*Edit, error) {
+	rows, err := s.templateRows(template)
+	if err != nil {
+		return nil, err
+	}
+
+	// Split the template change into "before" lines and hunks
+	var before []string
+	var hunks []*hunk
+	for i, r := range rows {
+		if r.kind == rowEqual {
+			before = append(before, r.line)
+			continue
+		}
+		if i == 0 || rows[i-1].kind == rowEqual {
+			hunks = append(hunks, &hunk{start: len(before), end: len(before)})
+		}
+		h := hunks[len(hunks)-1]
+		if r.kind == rowDelete {
+			before = append(before, r.line)
+			h.end++
+		} else {
+			h.inserted = append(h.inserted, r.line)
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, nil
+	}
+
+	// Align "before" lines with the region around the missing clone
+	lines, err := s.fileLines(missing.Filename)
+	if err != nil {
+		return nil, err
+	}
+	windowStart := max(1, missing.StartL-windowPadding)
+	windowEnd := min(len(lines), missing.EndL+windowPadding+len(before))
+	if windowEnd < windowStart || len(before) > maxAlignLines || windowEnd-windowStart+1 > maxAlignLines {
+		return nil, nil
+	}
+	window := lines[windowStart-1 : windowEnd]
+	matched := align(before, window)
+
+	var edits []*Edit
+	for _, h := range hunks {
+		var startL, endL int
+		var indentFrom, indentTo string
+		if h.end > h.start {
+			// Deleted (or modified) lines must all exist in the missing clone, contiguously
+			for i := h.start; i < h.end; i++ {
+				if matched[i] < 0 || (i > h.start && matched[i] != matched[i-1]+1) {
+					return nil, nil
+				}
+			}
+			startL = windowStart + matched[h.start]
+			endL = windowStart + matched[h.end-1]
+			indentFrom, indentTo = indent(before[h.start]), indent(window[matched[h.start]])
+		} else {
+			// Pure insertion needs an anchor line
+			switch {
+			case h.start > 0 && matched[h.start-1] >= 0:
+				startL = windowStart + matched[h.start-1] + 1
+				indentFrom, indentTo = indent(before[h.start-1]), indent(window[matched[h.start-1]])
+			case h.start < len(before) && matched[h.start] >= 0:
+				startL = windowStart + matched[h.start]
+				indentFrom, indentTo = indent(before[h.start]), indent(window[matched[h.start]])
+			default:
+				return nil, nil
+			}
+			endL = startL - 1
+		}
+		// The change should be applied to the missing clone, not somewhere around it
+		if endL < missing.StartL-1 || missing.EndL+1 < startL {
+			return nil, nil
+		}
+		edits = append(edits, &Edit{
+			Filename: missing.Filename,
+			StartL:   startL,
+			EndL:     endL,
+			Lines: ds.Map(h.inserted, func(line string) string {
+				if rest, ok := strings.CutPrefix(line, indentFrom); ok {
+					return indentTo + rest
+				}
+				return line
+			}),
+		})
+	}
+	return edits, nil
+}
+
+func indent(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// align calculates the longest common subsequence of lines (ignoring leading and trailing whitespaces),
+// and returns the index of the matched line in b for each line in a, or -1 if not matched.
+func align(a, b []string) []int {
+	a = ds.Map(a, strings.TrimSpace)
+	b = ds.Map(b, strings.TrimSpace)
+
+	// dp[i][j] = LCS length of a[i:] and b[j:]
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+
+	matched := lo.RepeatBy(len(a), func(_ int) int { return -1 })
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			matched[i] = j
+			i++
+			j++
+		case dp[i+1][j] >= dp[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matched
+}
@@ -0,0 +1,91 @@
+package fix
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+func TestSuggester_Suggest(t *testing.T) {
+	const content = `func a() {
+	x := get()
+	if x == nil {
+		return
+	}
+}
+
+func b() {
+		x := get()
+		if x == nil {
+			return
+		}
+}
+`
+	const patch = `--- a/a.go
++++ b/a.go
+@@ -1,4 +1,4 @@
+ func a() {
+ 	x := get()
+-	if x == nil {
++	if x == nil || x.Empty() {
+ 		return
+`
+	dir := t.TempDir()
+	// Patch is already applied to the target tree
+	applied := strings.Replace(content, "if x == nil {", "if x == nil || x.Empty() {", 1)
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(applied), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePatches, err := domain.ParseUnifiedDiff(strings.NewReader(patch), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSuggester(filePatches, tree)
+	sets := []*domain.CloneSet{{
+		Changed: []*domain.Clone{{Filename: "a.go", StartL: 3, EndL: 3}},
+		Missing: []*domain.Clone{{Filename: "a.go", StartL: 10, EndL: 10}},
+	}}
+	suggestions, err := s.Suggest(sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 1 {
+		t.Fatalf("expected 1 suggestion, got %d", len(suggestions))
+	}
+
+	// Indentation of the missing clone should be kept
+	const expected = `diff --git a/a.go b/a.go
+--- a/a.go
++++ b/a.go
+@@ -7,7 +7,7 @@
+` + " \n" + ` func b() {
+ 		x := get()
+-		if x == nil {
++		if x == nil || x.Empty() {
+ 			return
+ 		}
+ }
+`
+	if suggestions[0].Patch != expected {
+		t.Errorf("unexpected patch:\n%s\nexpected:\n%s", suggestions[0].Patch, expected)
+	}
+
+	contents, skipped, err := s.Apply(suggestions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 0 {
+		t.Errorf("expected no skipped suggestions, got %d", len(skipped))
+	}
+	if got := string(contents["a.go"]); got != strings.ReplaceAll(content, "if x == nil {", "if x == nil || x.Empty() {") {
+		t.Errorf("unexpected applied contents:\n%s", got)
+	}
+}
@@ -0,0 +1,201 @@
+package fix
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+
+	"github.com/salab/iccheck/pkg/utils/ds"
+)
+
+// contextLines is the number of context lines in generated unified diffs, same as the default of 'git diff'.
+const contextLines = 3
+
+// mergeEdits collects edits of the given suggestions per file.
+// Edits overlapping with edits of earlier suggestions are skipped, together with the rest of the suggestion.
+func mergeEdits(suggestions []*Suggestion) (map[string][]*Edit, []*Suggestion) {
+	editsByFile := make(map[string][]*Edit)
+	var skipped []*Suggestion
+	for _, sug := range suggestions {
+		overlaps := lo.SomeBy(sug.Edits, func(e *Edit) bool {
+			return lo.SomeBy(editsByFile[e.Filename], func(other *Edit) bool { return editsOverlap(e, other) })
+		})
+		if overlaps {
+			skipped = append(skipped, sug)
+			continue
+		}
+		for _, e := range sug.Edits {
+			editsByFile[e.Filename] = append(editsByFile[e.Filename], e)
+		}
+	}
+	for _, edits := range editsByFile {
+		slices.SortFunc(edits, ds.SortAsc(func(e *Edit) int { return e.StartL }))
+	}
+	return editsByFile, skipped
+}
+
+func editsOverlap(a, b *Edit) bool {
+	// Insertions at the same position would be ambiguous in order, treat them as overlapping too
+	return a.StartL <= max(b.EndL, b.StartL) && b.StartL <= max(a.EndL, a.StartL)
+}
+
+// Patch generates a unified diff applying all given suggestions, which can be applied by 'git apply'.
+// Suggestions which conflict with earlier suggestions are skipped, and returned as the second value.
+func (s *Suggester) Patch(suggestions []*Suggestion) (string, []*Suggestion, error) {
+	editsByFile, skipped := mergeEdits(suggestions)
+	filenames := lo.Keys(editsByFile)
+	slices.Sort(filenames)
+
+	var sb strings.Builder
+	for _, filename := range filenames {
+		f, err := s.file(filename)
+		if err != nil {
+			return "", nil, err
+		}
+		sb.WriteString(unifiedDiff(filename, f, editsByFile[filename]))
+	}
+	return sb.String(), skipped, nil
+}
+
+// Apply applies all given suggestions, and returns the new contents of each changed file.
+// Suggestions which conflict with earlier suggestions are skipped, and returned as the second value.
+func (s *Suggester) Apply(suggestions []*Suggestion) (map[string][]byte, []*Suggestion, error) {
+	editsByFile, skipped := mergeEdits(suggestions)
+	contents := make(map[string][]byte, len(editsByFile))
+	for filename, edits := range editsByFile {
+		f, err := s.file(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		var lines []string
+		l := 1
+		for _, e := range edits {
+			lines = append(lines, f.lines[l-1:e.StartL-1]...)
+			lines = append(lines, e.Lines...)
+			l = max(l, e.EndL+1)
+		}
+		lines = append(lines, f.lines[l-1:]...)
+
+		content := strings.Join(lines, "\n")
+		if !f.noEOL && len(lines) > 0 {
+			content += "\n"
+		}
+		contents[filename] = []byte(content)
+	}
+	return contents, skipped, nil
+}
+
+// unifiedDiff generates a unified diff of a single file, applying sorted and non-overlapping edits.
+func unifiedDiff(filename string, f *file, edits []*Edit) string {
+	if len(edits) == 0 {
+		return ""
+	}
+	path := filepath.ToSlash(filename)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\n", path, path)
+	fmt.Fprintf(&sb, "--- a/%s\n", path)
+	fmt.Fprintf(&sb, "+++ b/%s\n", path)
+
+	// Group edits whose context lines touch each other into hunks
+	var groups [][]*Edit
+	for i, e := range edits {
+		if i > 0 && e.StartL-edits[i-1].EndL-1 <= 2*contextLines {
+			groups[len(groups)-1] = append(groups[len(groups)-1], e)
+		} else {
+			groups = append(groups, []*Edit{e})
+		}
+	}
+
+	offset := 0 // Difference of line numbers between new and old file, caused by earlier hunks
+	for _, group := range groups {
+		first, last := group[0], group[len(group)-1]
+		from := max(1, first.StartL-contextLines)
+		to := min(len(f.lines), last.EndL+contextLines)
+
+		var body []hunkLine
+		l := from
+		for _, e := range group {
+			for ; l < e.StartL; l++ {
+				body = append(body, hunkLine{' ', f.lines[l-1]})
+			}
+			for ; l <= e.EndL; l++ {
+				body = append(body, hunkLine{'-', f.lines[l-1]})
+			}
+			for _, line := range e.Lines {
+				body = append(body, hunkLine{'+', line})
+			}
+		}
+		for ; l <= to; l++ {
+			body = append(body, hunkLine{' ', f.lines[l-1]})
+		}
+		oldCount := lo.CountBy(body, func(h hunkLine) bool { return h.prefix != '+' })
+		newCount := lo.CountBy(body, func(h hunkLine) bool { return h.prefix != '-' })
+
+		// Empty ranges denote the line just before the hunk
+		oldStart := lo.Ternary(oldCount == 0, from-1, from)
+		newStart := lo.Ternary(newCount == 0, from+offset-1, from+offset)
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		if f.noEOL && to == len(f.lines) {
+			body = markNoEOL(body)
+		}
+		for _, h := range body {
+			sb.WriteByte(h.prefix)
+			sb.WriteString(h.line)
+			sb.WriteByte('\n')
+		}
+		offset += newCount - oldCount
+	}
+	return sb.String()
+}
+
+// hunkLine is a line in a hunk of unified diff. prefix is ' ', '-', '+', or '\\' for "\ No newline at end of file".
+type hunkLine struct {
+	prefix byte
+	line   string
+}
+
+var noEOLLine = hunkLine{'\\', " No newline at end of file"}
+
+// markNoEOL marks the last lines of the old and new file in the hunk reaching the end of the file,
+// as the file does not end with a newline character before and after applying the edits (see Suggester.Apply).
+func markNoEOL(body []hunkLine) []hunkLine {
+	k := len(body) - 1 // The last context line
+	for k >= 0 && body[k].prefix != ' ' {
+		k--
+	}
+	tail := body[k+1:]
+	hasOld := slices.ContainsFunc(tail, func(h hunkLine) bool { return h.prefix == '-' })
+	hasNew := slices.ContainsFunc(tail, func(h hunkLine) bool { return h.prefix == '+' })
+	if !hasOld && !hasNew {
+		// The last line of both files
+		return append(body, noEOLLine)
+	}
+
+	// Rewrite the tail as deletion of the last old lines, and insertion of the last new lines
+	start := k + 1
+	if (!hasOld || !hasNew) && k >= 0 {
+		// The last context line is the last line of only one of the files, and gets a newline in the other
+		start = k
+	}
+	var olds, news []hunkLine
+	for _, h := range body[start:] {
+		if h.prefix != '+' {
+			olds = append(olds, hunkLine{'-', h.line})
+		}
+		if h.prefix != '-' {
+			news = append(news, hunkLine{'+', h.line})
+		}
+	}
+	ret := slices.Clone(body[:start])
+	if len(olds) > 0 {
+		ret = append(append(ret, olds...), noEOLLine)
+	}
+	if len(news) > 0 {
+		ret = append(append(ret, news...), noEOLLine)
+	}
+	return ret
+}
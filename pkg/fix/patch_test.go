@@ -0,0 +1,81 @@
+package fix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSuggester_Patch_NoEOL(t *testing.T) {
+	const noEOL = `\ No newline at end of file`
+	tests := []struct {
+		name      string
+		content   string
+		edit      *Edit
+		wantPatch string
+		wantApply string
+	}{
+		{
+			"change before the last line", "a\nb\nc",
+			&Edit{StartL: 2, EndL: 2, Lines: []string{"B"}},
+			"@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n" + noEOL + "\n",
+			"a\nB\nc",
+		},
+		{
+			"change of the last line", "a\nb\nc",
+			&Edit{StartL: 3, EndL: 3, Lines: []string{"C"}},
+			"@@ -1,3 +1,3 @@\n a\n b\n-c\n" + noEOL + "\n+C\n" + noEOL + "\n",
+			"a\nb\nC",
+		},
+		{
+			"deletion of the last line", "a\nb\nc",
+			&Edit{StartL: 3, EndL: 3},
+			"@@ -1,3 +1,2 @@\n a\n-b\n-c\n" + noEOL + "\n+b\n" + noEOL + "\n",
+			"a\nb",
+		},
+		{
+			"insertion after the last line", "a\nb\nc",
+			&Edit{StartL: 4, EndL: 3, Lines: []string{"d"}},
+			"@@ -1,3 +1,4 @@\n a\n b\n-c\n" + noEOL + "\n+c\n+d\n" + noEOL + "\n",
+			"a\nb\nc\nd",
+		},
+		{
+			"replacement of the whole file", "a",
+			&Edit{StartL: 1, EndL: 1, Lines: []string{"b", "c"}},
+			"@@ -1,1 +1,2 @@\n-a\n" + noEOL + "\n+b\n+c\n" + noEOL + "\n",
+			"b\nc",
+		},
+		{
+			"with the last newline", "a\nb\nc\n",
+			&Edit{StartL: 3, EndL: 3, Lines: []string{"C"}},
+			"@@ -1,3 +1,3 @@\n a\n b\n-c\n+C\n",
+			"a\nb\nC\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Suggester{files: map[string]*file{"a.go": {
+				lines: splitLines(tt.content),
+				noEOL: !strings.HasSuffix(tt.content, "\n"),
+			}}}
+			tt.edit.Filename = "a.go"
+			suggestions := []*Suggestion{{Edits: []*Edit{tt.edit}}}
+
+			patch, _, err := s.Patch(suggestions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const header = "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n"
+			if patch != header+tt.wantPatch {
+				t.Errorf("unexpected patch:\n%s\nexpected:\n%s", patch, header+tt.wantPatch)
+			}
+
+			contents, _, err := s.Apply(suggestions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(contents["a.go"]); got != tt.wantApply {
+				t.Errorf("unexpected applied contents %q, expected %q", got, tt.wantApply)
+			}
+		})
+	}
+}
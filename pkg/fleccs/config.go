@@ -1,5 +1,7 @@
 package fleccs
 
+import "github.com/salab/iccheck/pkg/diskcache"
+
 const (
 	DefaultContextLines        = 4
 	DefaultSimilarityThreshold = 0.7
@@ -8,6 +10,8 @@ const (
 type config struct {
 	contextLines        int
 	similarityThreshold float64
+	diskCache           *diskcache.Cache
+	partialResults      bool
 }
 
 func defaultConfig() *config {
@@ -38,3 +42,18 @@ func WithSimilarityThreshold(threshold float64) ConfigFunc {
 		c.similarityThreshold = threshold
 	}
 }
+
+// WithDiskCache sets the persistent cache of line bigrams of search target files.
+func WithDiskCache(cache *diskcache.Cache) ConfigFunc {
+	return func(c *config) {
+		c.diskCache = cache
+	}
+}
+
+// WithPartialResults sets whether to return results of the files already searched, when ctx is done.
+// See domain.SearchFiles.
+func WithPartialResults(partial bool) ConfigFunc {
+	return func(c *config) {
+		c.partialResults = partial
+	}
+}
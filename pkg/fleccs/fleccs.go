@@ -7,7 +7,8 @@ package fleccs
 
 import (
 	"context"
-	"runtime"
+	"slices"
+	"sync"
 
 	"github.com/cespare/xxhash"
 	"github.com/pkg/errors"
@@ -16,7 +17,6 @@ import (
 	"github.com/salab/iccheck/pkg/utils/files"
 	"github.com/salab/iccheck/pkg/utils/strs"
 	"github.com/samber/lo"
-	"github.com/sourcegraph/conc/pool"
 )
 
 type Source struct {
@@ -37,7 +37,6 @@ type Query struct {
 	Filename     string
 	StartL, EndL int
 
-	enlargedContext    int
 	contextStartLine   int
 	contextEndLine     int
 	contextLineLengths []int
@@ -67,7 +66,6 @@ func (q *Query) calculateContextLines(c *config, queryTree domain.Searcher) erro
 	queryFileLines := len(queryFileLineIndices)
 	queryFileLineIndices = append(queryFileLineIndices, len(queryFileContent)) // For cleaner code below
 
-	q.enlargedContext = c.contextLines
 	q.contextStartLine = max(1, q.StartL-c.contextLines)          // inclusive, 1-indexed
 	q.contextEndLine = min(queryFileLines, q.EndL+c.contextLines) // inclusive, 1-indexed
 	q.contextLineLengths, q.contextBigrams = files.LengthsAndBigrams(queryFileContent, q.contextStartLine, q.contextEndLine)
@@ -126,10 +124,13 @@ func findCandidates(
 	searchFileBigrams []strs.BigramSet,
 	ignoreRule *domain.IgnoreLineRule,
 	similarityThreshold float64,
+	possible []bool,
 ) []*Candidate {
 	var candidates []*Candidate
 	windowSize := len(q.contextBigrams)
-	orgWindowSize := windowSize - 2*q.enlargedContext
+	// Context lines may be fewer than configured at the start or end of the query file
+	contextBefore := q.StartL - q.contextStartLine
+	orgWindowSize := q.EndL - q.StartL + 1
 
 	if len(searchFileBigrams) < windowSize {
 		// If the search target file is shorter than the query lines (including context)
@@ -141,8 +142,13 @@ func findCandidates(
 		endLine := i + windowSize // 0-indexed, exclusive
 
 		// Check if this (original) region can be skipped
-		if canSkip, skipUntil := ignoreRule.CanSkip(i+q.enlargedContext, orgWindowSize); canSkip {
-			i = skipUntil - q.enlargedContext
+		if canSkip, skipUntil := ignoreRule.CanSkip(i+contextBefore, orgWindowSize); canSkip {
+			i = skipUntil - contextBefore
+			continue
+		}
+
+		// Skip windows which cannot reach the threshold, found by the index
+		if possible != nil && !possible[i] {
 			continue
 		}
 
@@ -170,7 +176,8 @@ func fileSearch(
 	searchTree domain.Searcher,
 	searchFilename string,
 	matcher *domain.MatcherRules,
-	similarityThreshold float64,
+	ix *bigramIndex,
+	c *config,
 ) ([]*Candidate, error) {
 	if ctx.Err() != nil { // check for deadline
 		return nil, ctx.Err()
@@ -201,8 +208,10 @@ func fileSearch(
 		return nil, nil
 	}
 
-	fileHash := xxhash.Sum64(fileContent)
-	fileLineLengths, fileLineBigrams := files.LengthsAndBigrams(fileContent, 1, -1)
+	// Ignored lines affect candidates too (e.g. when ignore files are edited while the LSP server is running)
+	fileHash := xxhash.Sum64(fileContent) ^ ignoreRule.Hash()
+	fileLineLengths, fileLineBigrams := c.diskCache.LengthsAndBigrams(fileContent)
+	filePostings := sync.OnceValue(func() *postings { return ix.postings(fileLineBigrams) })
 
 	var candidates []*Candidate
 	// For each query, extract candidates
@@ -212,7 +221,11 @@ func fileSearch(
 		}
 
 		qCandidates := getFromCacheOrCalcCandidates(q.hash, fileHash, func() []*Candidate {
-			qCandidates := findCandidates(q, searchFilename, fileLineLengths, fileLineBigrams, ignoreRule, similarityThreshold)
+			possible := possibleWindows(q, ix, filePostings(), fileLineLengths, len(ignoreRule.IgnoreLines), c.similarityThreshold)
+			if possible != nil && !slices.Contains(possible, true) {
+				return nil
+			}
+			qCandidates := findCandidates(q, searchFilename, fileLineLengths, fileLineBigrams, ignoreRule, c.similarityThreshold, possible)
 			// Fix found candidate lines not to include the enlarged context lines
 			return ds.Map(qCandidates, func(c *Candidate) *Candidate { return q.accountForContextLines(c) })
 		})
@@ -241,6 +254,8 @@ func Search(
 		}
 	}
 
+	ix := newBigramIndex(queries)
+
 	// List all file names from search root directory
 	searchFiles, err := searchTree.Files()
 	if err != nil {
@@ -248,21 +263,7 @@ func Search(
 	}
 
 	// Search for co-change candidates!
-	p := pool.NewWithResults[[]*Candidate]().
-		WithMaxGoroutines(runtime.NumCPU()).
-		WithErrors().
-		WithContext(ctx).
-		WithCancelOnError().
-		WithFirstError()
-	for _, searchFile := range searchFiles {
-		p.Go(func(ctx context.Context) ([]*Candidate, error) {
-			return fileSearch(ctx, queries, searchTree, searchFile, matcher, c.similarityThreshold)
-		})
-	}
-	candidates, err := p.Wait()
-	if err != nil {
-		return nil, err
-	}
-
-	return lo.Flatten(candidates), nil
+	return domain.SearchFiles(ctx, searchFiles, c.partialResults, func(ctx context.Context, searchFile string) ([]*Candidate, error) {
+		return fileSearch(ctx, queries, searchTree, searchFile, matcher, ix, c)
+	})
 }
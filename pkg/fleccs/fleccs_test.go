@@ -0,0 +1,102 @@
+package fleccs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/files"
+)
+
+func TestFindCandidates_IgnoreLines(t *testing.T) {
+	const content = "x := get()\nif x == nil {\n\treturn\n}\nuse(x)" // No trailing newline, for no empty last line
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileLengths, fileBigrams := files.LengthsAndBigrams([]byte(content), 1, -1)
+
+	tests := []struct {
+		name         string
+		startL, endL int
+		ignoreLines  []int
+		found        bool
+	}{
+		{"not ignored", 2, 3, nil, true},
+		{"first line ignored", 2, 3, []int{2}, false},
+		{"context line ignored", 2, 3, []int{1}, true},
+		// Fewer context lines are available at the start of the file
+		{"first line ignored at the start of file", 1, 2, []int{1}, false},
+		{"context line ignored at the start of file", 1, 2, []int{3}, true},
+		// Fewer context lines are available at the end of the file
+		{"last line ignored at the end of file", 4, 5, []int{5}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &Query{Filename: "a.go", StartL: tt.startL, EndL: tt.endL}
+			if err := q.calculateContextLines(applyConfig(WithContextLines(1)), domain.NewSearcherFromTree(tree)); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(content, "\n")
+			var ignoreConfigs []*domain.IgnoreConfig
+			for _, l := range tt.ignoreLines {
+				ignoreConfigs = append(ignoreConfigs, domain.IgnoreConfigForLines("a.go", []string{lines[l-1]}))
+			}
+			matcher, err := domain.ReadMatcherRulesWithConfigs(t.TempDir(), true, ignoreConfigs, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, ignoreRule := matcher.Match("a.go", []byte(content))
+
+			candidates := findCandidates(q, "a.go", fileLengths, fileBigrams, ignoreRule, 1, nil)
+			if found := len(candidates) > 0; found != tt.found {
+				t.Errorf("expected found = %v, got %d candidate(s)", tt.found, len(candidates))
+			}
+		})
+	}
+}
+
+func TestSearch_IgnoreRulesChange(t *testing.T) {
+	const content = "\nfunc f() {\n\tx := get()\n\tif x == nil {\n\t\treturn\n\t}\n\tuse(x)\n}\n"
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(dir, name+".go"), []byte("package "+name+"\n"+content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searcher := domain.NewSearcherFromTree(tree)
+	search := func(ignoreConfigs []*domain.IgnoreConfig) bool {
+		t.Helper()
+		matcher, err := domain.ReadMatcherRulesWithConfigs(t.TempDir(), true, ignoreConfigs, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		candidates, err := Search(context.Background(), searcher, []*Query{{Filename: "a.go", StartL: 4, EndL: 7}}, searcher, matcher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Wait() // Make sure candidates are cached
+		return slices.ContainsFunc(candidates, func(c *Candidate) bool { return c.Filename == "b.go" })
+	}
+
+	if !search(nil) {
+		t.Fatalf("expected the copy in b.go to be found")
+	}
+	// Same file contents with different ignore rules, such as after editing ignore files in the LSP server
+	lines := strings.Split(content, "\n")
+	if search([]*domain.IgnoreConfig{domain.IgnoreConfigForLines("b.go", lines[2:6])}) {
+		t.Errorf("expected ignored lines in b.go not to be found from the cache")
+	}
+}
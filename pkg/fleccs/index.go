@@ -0,0 +1,152 @@
+package fleccs
+
+import (
+	"math"
+	"slices"
+
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/strs"
+	"github.com/samber/lo"
+)
+
+// bigramIndex assigns a slot for each bigram appearing in the queries.
+// Only these bigrams are indexed in search target files, since the other bigrams never contribute to similarity.
+type bigramIndex struct {
+	slot  []int32 // -1 if the bigram does not appear in the queries
+	slots int
+}
+
+func newBigramIndex(queries []*Query) *bigramIndex {
+	ix := &bigramIndex{slot: make([]int32, math.MaxUint16+1)}
+	for i := range ix.slot {
+		ix.slot[i] = -1
+	}
+	for _, q := range queries {
+		for _, set := range q.contextBigrams {
+			for _, b := range set {
+				if ix.slot[b] < 0 {
+					ix.slot[b] = int32(ix.slots)
+					ix.slots++
+				}
+			}
+		}
+	}
+	return ix
+}
+
+// postings is an inverted index of a search target file, from bigram slots to lines containing the bigram.
+type postings struct {
+	offsets []int32
+	lines   []int32
+}
+
+func (ix *bigramIndex) postings(fileBigrams []strs.BigramSet) *postings {
+	p := &postings{offsets: make([]int32, ix.slots+1)}
+	for _, set := range fileBigrams {
+		for _, b := range set {
+			if s := ix.slot[b]; s >= 0 {
+				p.offsets[s+1]++
+			}
+		}
+	}
+	for s := 0; s < ix.slots; s++ {
+		p.offsets[s+1] += p.offsets[s]
+	}
+	p.lines = make([]int32, p.offsets[ix.slots])
+	next := slices.Clone(p.offsets[:ix.slots])
+	for line, set := range fileBigrams {
+		for _, b := range set {
+			if s := ix.slot[b]; s >= 0 {
+				p.lines[next[s]] = int32(line)
+				next[s]++
+			}
+		}
+	}
+	return p
+}
+
+func (p *postings) get(s int32) []int32 {
+	return p.lines[p.offsets[s]:p.offsets[s+1]]
+}
+
+// possibleWindows returns, for each search window, whether its similarity can possibly reach the threshold.
+// This never rejects a window which would reach the threshold, so that results stay identical to the full scan.
+//
+// Let τ = threshold/2. The similarity is a weighted average of per-line DiSC, so a window reaching the threshold needs
+// enough weight (line lengths) from line pairs with DiSC >= τ, while the other pairs contribute less than τ.
+// A line pair with DiSC >= τ shares at least ceil(τ|a|/(2-τ)) bigrams with the query line a (prefix filtering),
+// so the file line must contain at least one of the |a|-ceil(τ|a|/(2-τ))+1 rarest bigrams of a, which is looked up from the postings.
+//
+// Returns nil if filtering is not possible.
+func possibleWindows(
+	q *Query,
+	ix *bigramIndex,
+	p *postings,
+	fileLineLengths []int,
+	ignoredLines int,
+	similarityThreshold float64,
+) []bool {
+	windowSize := len(q.contextBigrams)
+	windows := len(fileLineLengths) - windowSize + 1
+	if windows <= 0 || similarityThreshold <= 0 {
+		return nil
+	}
+	tau := similarityThreshold / 2
+
+	// Select the rarest bigrams of each query line
+	prefixes := make([][]int32, len(q.contextBigrams))
+	work := 0
+	for k, set := range q.contextBigrams {
+		if len(set) == 0 {
+			continue // DiSC is always 0
+		}
+		minShared := max(1, int(math.Ceil(tau*float64(len(set))/(2-tau)-1e-9)))
+		keys := ds.Map(set, func(b uint16) uint64 {
+			s := ix.slot[b]
+			return uint64(p.offsets[s+1]-p.offsets[s])<<32 | uint64(s)
+		})
+		slices.Sort(keys)
+		keys = keys[:len(set)-minShared+1]
+		prefixes[k] = ds.Map(keys, func(key uint64) int32 { return int32(uint32(key)) })
+		work += int(lo.SumBy(keys, func(key uint64) uint64 { return key >> 32 }))
+	}
+	// Filtering does not pay off if the lines are too common, compared to the cost of comparing every window.
+	// Windows containing ignored lines are skipped anyway, without comparison.
+	scannedWindows := max(0, windows-ignoredLines*(q.EndL-q.StartL+1))
+	if work > 4*scannedWindows*windowSize {
+		return nil
+	}
+
+	// Weight from line pairs possibly having DiSC >= τ, for each window
+	highWeight := make([]int, windows)
+	stamp := make([]int, len(fileLineLengths))
+	for k, prefix := range prefixes {
+		for _, s := range prefix {
+			for _, line := range p.get(s) {
+				i := int(line) - k
+				if i < 0 || windows <= i || stamp[line] == k+1 {
+					continue
+				}
+				stamp[line] = k + 1
+				highWeight[i] += q.contextLineLengths[k] + fileLineLengths[line]
+			}
+		}
+	}
+
+	queryLength := lo.Sum(q.contextLineLengths)
+	fileLength := lo.Sum(fileLineLengths[:windowSize])
+	possible := make([]bool, windows)
+	for i := range possible {
+		if i > 0 {
+			fileLength += fileLineLengths[i+windowSize-1] - fileLineLengths[i-1]
+		}
+		total := float64(queryLength + fileLength)
+		if total == 0 {
+			continue // Similarity is 0
+		}
+		high := float64(highWeight[i])
+		upperBound := (high + tau*(total-high)) / total
+		possible[i] = upperBound >= similarityThreshold-1e-9
+	}
+	return possible
+}
@@ -0,0 +1,50 @@
+package fleccs
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/files"
+)
+
+func TestPossibleWindows_SameResults(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	words := []string{"if", "err", "!=", "nil", "{", "}", "return", "x", "y", ":=", "foo(", ")", "for", "range", "i++", "\t"}
+	randomFile := func(lines int) []byte {
+		var sb strings.Builder
+		for range lines {
+			for range rnd.Intn(8) {
+				sb.WriteString(words[rnd.Intn(len(words))])
+				sb.WriteString(" ")
+			}
+			sb.WriteString("\n")
+		}
+		return []byte(sb.String())
+	}
+
+	for range 50 {
+		queryContent := randomFile(20)
+		fileContent := randomFile(200)
+		startL := 1 + rnd.Intn(15)
+		q := &Query{Filename: "q", StartL: startL, EndL: startL + rnd.Intn(3)}
+		q.contextStartLine, q.contextEndLine = q.StartL, min(20, q.EndL+2)
+		q.contextLineLengths, q.contextBigrams = files.LengthsAndBigrams(queryContent, q.contextStartLine, q.contextEndLine)
+
+		fileLengths, fileBigrams := files.LengthsAndBigrams(fileContent, 1, -1)
+		ix := newBigramIndex([]*Query{q})
+		p := ix.postings(fileBigrams)
+		for _, threshold := range []float64{0.3, 0.5, 0.7, 0.9} {
+			ignoreRule := &domain.IgnoreLineRule{IgnoreLines: map[int]struct{}{50: {}}}
+			expected := findCandidates(q, "f", fileLengths, fileBigrams, ignoreRule, threshold, nil)
+			possible := possibleWindows(q, ix, p, fileLengths, 0, threshold)
+			ignoreRule = &domain.IgnoreLineRule{IgnoreLines: map[int]struct{}{50: {}}}
+			got := findCandidates(q, "f", fileLengths, fileBigrams, ignoreRule, threshold, possible)
+			if !reflect.DeepEqual(expected, got) {
+				t.Fatalf("threshold %v: expected %d candidates, got %d", threshold, len(expected), len(got))
+			}
+		}
+	}
+}
@@ -0,0 +1,341 @@
+// Package history analyzes each commit in a commit range for inconsistent changes,
+// and tracks whether the detected missing changes were fixed later in the range.
+package history
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/pkg/errors"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/search"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/samber/lo"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// CommitReport holds inconsistent changes detected in a single commit, compared to its (first) parent.
+type CommitReport struct {
+	Commit    *object.Commit
+	CloneSets []*CloneSet
+	// Coverage is non-nil if the analysis of the commit was interrupted (such as by a timeout with partial results),
+	// and CloneSets are partial.
+	Coverage *domain.Coverage
+	// Err is non-nil if the analysis of the commit failed, in which case CloneSets is empty.
+	Err error
+}
+
+// CloneSet is a domain.CloneSet annotated with the status of each missing change.
+type CloneSet struct {
+	*domain.CloneSet
+	// FixedIn holds, for each clone in CloneSet.Missing (in the same order), the later commit in the range
+	// which changed the clone. nil indicates that the missing change is still outstanding at the end of the range.
+	FixedIn []*object.Commit
+}
+
+// Outstanding returns the number of missing changes not fixed in the range.
+func (cs *CloneSet) Outstanding() int {
+	return lo.CountBy(cs.FixedIn, func(c *object.Commit) bool { return c == nil })
+}
+
+// ListCommits lists commits in the given range, in the form of "from..to" (the same as 'git log from..to'),
+// from the oldest to the newest.
+//
+// If firstParent is true, only commits in the first-parent chain of "to" are listed.
+// Otherwise, all non-merge commits in the range are listed.
+func ListCommits(repo *git.Repository, commitRange string, firstParent bool) ([]*object.Commit, error) {
+	fromRev, toRev, ok := strings.Cut(commitRange, "..")
+	if !ok || fromRev == "" || toRev == "" || strings.HasPrefix(toRev, ".") {
+		return nil, errors.Errorf("invalid range %v, should be of form 'from..to'", commitRange)
+	}
+	from, err := resolveCommit(repo, fromRev)
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolveCommit(repo, toRev)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exclude all ancestors of "from"
+	excluded := make(map[plumbing.Hash]bool)
+	err = object.NewCommitPreorderIter(from, nil, nil).ForEach(func(c *object.Commit) error {
+		excluded[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing ancestors of %v", fromRev)
+	}
+
+	var commits []*object.Commit
+	if firstParent {
+		for c := to; !excluded[c.Hash]; {
+			commits = append(commits, c)
+			if c.NumParents() == 0 {
+				break
+			}
+			parent, err := c.Parent(0)
+			if err != nil {
+				return nil, errors.Wrapf(err, "resolving parent of %v", c.Hash)
+			}
+			c = parent
+		}
+		slices.Reverse(commits)
+	} else {
+		err = object.NewCommitPreorderIter(to, excluded, nil).ForEach(func(c *object.Commit) error {
+			if c.NumParents() <= 1 {
+				commits = append(commits, c)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing commits in %v", commitRange)
+		}
+		slices.SortStableFunc(commits, ds.SortAsc(func(c *object.Commit) int64 { return c.Committer.When.Unix() }))
+	}
+	return commits, nil
+}
+
+func resolveCommit(repo *git.Repository, rev string) (*object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving hash revision from %v", rev)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving commit from hash %v", *hash)
+	}
+	return commit, nil
+}
+
+func shortHash(c *object.Commit) string {
+	return c.Hash.String()[:7]
+}
+
+// Analyze searches for inconsistent changes in each commit compared to its first parent,
+// then checks whether each missing change was fixed by a following commit.
+// timeout is applied to the analysis of each commit.
+//
+// A commit which fails to be analyzed does not abort the range: the error is recorded in CommitReport.Err,
+// and the following commits are analyzed. Only cancellation of ctx stops the analysis.
+func Analyze(
+	ctx context.Context,
+	commits []*object.Commit,
+	algorithm string,
+	timeout time.Duration,
+	c *search.Config,
+) ([]*CommitReport, error) {
+	reports := make([]*CommitReport, 0, len(commits))
+	for i, commit := range commits {
+		if commit.NumParents() == 0 {
+			slog.Info(fmt.Sprintf("Skipping root commit %v", shortHash(commit)))
+			continue
+		}
+		report, err := analyzeReport(ctx, commit, commits[i+1:], algorithm, timeout, c)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			slog.Warn(fmt.Sprintf("[%d/%d] Failed to analyze commit %v, skipping: %v", i+1, len(commits), shortHash(commit), err))
+			report = &CommitReport{Commit: commit, Err: err}
+		} else {
+			slog.Info(fmt.Sprintf("[%d/%d] %d clone set(s) are likely missing consistent change.", i+1, len(commits), len(report.CloneSets)), "commit", shortHash(commit))
+			if report.Coverage != nil {
+				slog.Warn(fmt.Sprintf("Analysis of commit %v was interrupted, results are partial: %v", shortHash(commit), report.Coverage))
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// analyzeReport analyzes a single commit, and tracks its missing changes through laterCommits.
+func analyzeReport(
+	ctx context.Context,
+	commit *object.Commit,
+	laterCommits []*object.Commit,
+	algorithm string,
+	timeout time.Duration,
+	c *search.Config,
+) (*CommitReport, error) {
+	parent, err := commit.Parent(0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving parent of %v", commit.Hash)
+	}
+	cloneSets, coverage, err := analyzeCommit(ctx, parent, commit, algorithm, timeout, c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "analyzing commit %v", commit.Hash)
+	}
+
+	// With all commits in the range, later commits might be on branches which do not contain this commit
+	laterCommits, err = descendantsOf(commit, laterCommits)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing descendants of %v", commit.Hash)
+	}
+
+	report := &CommitReport{Commit: commit, Coverage: coverage}
+	for _, cs := range cloneSets {
+		fixedIn, err := ds.MapError(cs.Missing, func(missing *domain.Clone) (*object.Commit, error) {
+			return findFixingCommit(missing, commit, laterCommits)
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "tracking missing changes of %v", commit.Hash)
+		}
+		report.CloneSets = append(report.CloneSets, &CloneSet{CloneSet: cs, FixedIn: fixedIn})
+	}
+	return report, nil
+}
+
+// descendantsOf filters commits to the descendants of ancestor, keeping the order.
+func descendantsOf(ancestor *object.Commit, commits []*object.Commit) ([]*object.Commit, error) {
+	// Results of previous walks, so that the history is not walked again for each commit
+	reaches := map[plumbing.Hash]bool{ancestor.Hash: true}
+	unreachable := make(map[plumbing.Hash]bool)
+
+	var descendants []*object.Commit
+	for _, c := range commits {
+		var visited []plumbing.Hash
+		found := false
+		err := object.NewCommitPreorderIter(c, unreachable, nil).ForEach(func(p *object.Commit) error {
+			if reaches[p.Hash] {
+				found = true
+				return storer.ErrStop
+			}
+			visited = append(visited, p.Hash)
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "walking history of %v", c.Hash)
+		}
+		if found {
+			reaches[c.Hash] = true
+			descendants = append(descendants, c)
+		} else {
+			// The whole history of the commit was walked
+			for _, h := range visited {
+				unreachable[h] = true
+			}
+		}
+	}
+	return descendants, nil
+}
+
+func analyzeCommit(
+	ctx context.Context,
+	parent, commit *object.Commit,
+	algorithm string,
+	timeout time.Duration,
+	c *search.Config,
+) ([]*domain.CloneSet, *domain.Coverage, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fromTree := domain.NewGoGitCommitTree(parent, shortHash(parent))
+	toTree := domain.NewGoGitCommitTree(commit, shortHash(commit))
+	queries, _, err := search.DiffTrees(ctx, fromTree, toTree)
+	if err != nil {
+		return nil, nil, err
+	}
+	cloneSets, err := search.Search(ctx, algorithm, queries, toTree, c)
+	incomplete, isIncomplete := domain.AsIncomplete(err)
+	if err != nil && !isIncomplete {
+		return nil, nil, err
+	}
+	cloneSets = lo.Filter(cloneSets, func(cs *domain.CloneSet, _ int) bool { return len(cs.Missing) > 0 })
+	if isIncomplete {
+		return cloneSets, &incomplete.Coverage, nil
+	}
+	return cloneSets, nil, nil
+}
+
+// findFixingCommit finds the first commit in laterCommits that changed the lines of the missing clone.
+// laterCommits should be descendants of commit.
+// Clone locations are tracked through diffs of each later commit,
+// so that line shifts by unrelated changes are not regarded as fixes.
+//
+// A commit which deletes the file of the missing clone is regarded as fixing it, since the clone no longer exists
+// and cannot be left behind. Renames are not followed, so moving the file is regarded as a fix as well;
+// tracking clones across renames would require rename detection on every later commit.
+func findFixingCommit(missing *domain.Clone, commit *object.Commit, laterCommits []*object.Commit) (*object.Commit, error) {
+	// Windows support: git paths are always "/"-delimited
+	path := filepath.ToSlash(missing.Filename)
+	file, err := commit.File(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving %v", path)
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %v", path)
+	}
+
+	prevHash := file.Hash
+	startL, endL := missing.StartL, missing.EndL
+	for _, later := range laterCommits {
+		laterFile, err := later.File(path)
+		if errors.Is(err, object.ErrFileNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+			return later, nil // File was deleted or moved
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving %v in %v", path, later.Hash)
+		}
+		if laterFile.Hash == prevHash {
+			continue
+		}
+		prevHash = laterFile.Hash
+
+		laterContent, err := laterFile.Contents()
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %v in %v", path, later.Hash)
+		}
+		var changed bool
+		startL, endL, changed = trackLines(content, laterContent, startL, endL)
+		if changed {
+			return later, nil
+		}
+		content = laterContent
+	}
+	return nil, nil
+}
+
+// trackLines calculates the new location of lines from startL to endL (1-indexed, inclusive) after the change.
+// changed is true if any of the lines were changed.
+func trackLines(before, after string, startL, endL int) (newStartL, newEndL int, changed bool) {
+	countLines := func(s string) int {
+		return strings.Count(s, "\n") + lo.Ternary(strings.HasSuffix(s, "\n"), 0, 1)
+	}
+
+	beforeL, afterL := 1, 1
+	offset := 0
+	for _, d := range diff.Do(before, after) {
+		lines := countLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			if beforeL <= startL && startL < beforeL+lines {
+				offset = afterL - beforeL
+			}
+			beforeL += lines
+			afterL += lines
+		case diffmatchpatch.DiffDelete:
+			if startL < beforeL+lines && beforeL <= endL {
+				return 0, 0, true
+			}
+			beforeL += lines
+		case diffmatchpatch.DiffInsert:
+			if startL < beforeL && beforeL <= endL {
+				return 0, 0, true
+			}
+			afterL += lines
+		}
+	}
+	return startL + offset, endL + offset, false
+}
@@ -0,0 +1,292 @@
+package history
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/search"
+)
+
+// testRepo builds commits on an in-memory repository.
+type testRepo struct {
+	t    *testing.T
+	repo *git.Repository
+	wt   *git.Worktree
+	when time.Time
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, repo: repo, wt: wt, when: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// commit writes files (or deletes them if the content is empty), and commits them on top of parents,
+// starting from the tree of the first parent.
+// If no parents are given, the commit is made on top of HEAD.
+func (r *testRepo) commit(files map[string]string, parents ...plumbing.Hash) *object.Commit {
+	r.t.Helper()
+	if len(parents) > 0 {
+		if err := r.wt.Checkout(&git.CheckoutOptions{Hash: parents[0], Force: true}); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	for path, content := range files {
+		if content == "" {
+			if _, err := r.wt.Remove(path); err != nil {
+				r.t.Fatal(err)
+			}
+			continue
+		}
+		f, err := r.wt.Filesystem.Create(path)
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		if _, err = f.Write([]byte(content)); err != nil {
+			r.t.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			r.t.Fatal(err)
+		}
+		if _, err = r.wt.Add(path); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	r.when = r.when.Add(time.Minute)
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: r.when}
+	hash, err := r.wt.Commit("commit", &git.CommitOptions{Author: sig, Committer: sig, Parents: parents, AllowEmptyCommits: true})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	c, err := r.repo.CommitObject(hash)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return c
+}
+
+func TestTrackLines(t *testing.T) {
+	const before = "1\n2\n3\n4\n5\n6\n7\n8\n"
+	tests := []struct {
+		name        string
+		after       string
+		startL      int
+		endL        int
+		wantStartL  int
+		wantEndL    int
+		wantChanged bool
+	}{
+		{"unchanged", before, 3, 5, 3, 5, false},
+		{"insertion before", "0\n1\n2\n3\n4\n5\n6\n7\n8\n", 3, 5, 4, 6, false},
+		{"insertions before", "1\n1.5\n1.6\n2\n3\n4\n5\n6\n7\n8\n", 3, 5, 5, 7, false},
+		{"deletion before", "2\n3\n4\n5\n6\n7\n8\n", 3, 5, 2, 4, false},
+		{"insertion right before", "1\n2\n2.5\n3\n4\n5\n6\n7\n8\n", 3, 5, 4, 6, false},
+		{"insertion right after", "1\n2\n3\n4\n5\n5.5\n6\n7\n8\n", 3, 5, 3, 5, false},
+		{"change after", "1\n2\n3\n4\n5\n6\nseven\n8\n", 3, 5, 3, 5, false},
+		{"insertion inside", "1\n2\n3\n3.5\n4\n5\n6\n7\n8\n", 3, 5, 0, 0, true},
+		{"modification inside", "1\n2\n3\nfour\n5\n6\n7\n8\n", 3, 5, 0, 0, true},
+		{"deletion of first line", "1\n2\n4\n5\n6\n7\n8\n", 3, 5, 0, 0, true},
+		{"deletion of last line", "1\n2\n3\n4\n6\n7\n8\n", 3, 5, 0, 0, true},
+		{"deletion of all lines", "1\n2\n6\n7\n8\n", 3, 5, 0, 0, true},
+		{"deletion overlapping start", "1\n4\n5\n6\n7\n8\n", 3, 5, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startL, endL, changed := trackLines(before, tt.after, tt.startL, tt.endL)
+			if changed != tt.wantChanged {
+				t.Fatalf("expected changed = %v, got %v", tt.wantChanged, changed)
+			}
+			if !changed && (startL != tt.wantStartL || endL != tt.wantEndL) {
+				t.Errorf("expected L%d-L%d, got L%d-L%d", tt.wantStartL, tt.wantEndL, startL, endL)
+			}
+		})
+	}
+}
+
+func TestFindFixingCommit(t *testing.T) {
+	const base = "a\nb\nc\nd\ne\n"
+	missing := &domain.Clone{Filename: "src/a.go", StartL: 2, EndL: 3}
+
+	// Each later commit is given by the changed files
+	tests := []struct {
+		name    string
+		later   []map[string]string
+		fixedIn int // Index of the fixing commit in later, or -1 if outstanding
+	}{
+		{"no later commits", nil, -1},
+		{"unrelated file", []map[string]string{{"src/b.go": "x\n"}}, -1},
+		{"line shift", []map[string]string{{"src/a.go": "0\n" + base}}, -1},
+		{"change after the clone", []map[string]string{{"src/a.go": "a\nb\nc\nd\nE\n"}}, -1},
+		{"fixed", []map[string]string{{"src/a.go": "a\nB\nc\nd\ne\n"}}, 0},
+		{"fixed after line shift", []map[string]string{
+			{"src/a.go": "0\n" + base},
+			{"src/b.go": "x\n"},
+			{"src/a.go": "0\na\nb\nC\nd\ne\n"},
+		}, 2},
+		{"shifted away from the old location", []map[string]string{
+			{"src/a.go": "0\n1\n" + base},
+			// Changes the old location of the clone (L2-L3), but not the clone itself (now L4-L5)
+			{"src/a.go": "0\nONE\nA\nb\nc\nd\ne\n"},
+		}, -1},
+		// The missing clone no longer exists, and cannot be left behind
+		{"file deleted", []map[string]string{{"src/a.go": ""}}, 0},
+		// Renames are not followed
+		{"file moved", []map[string]string{{"src/a.go": "", "src/moved.go": base}}, 0},
+		{"directory moved", []map[string]string{{"src/a.go": "", "lib/a.go": base}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t)
+			commit := r.commit(map[string]string{"src/a.go": base})
+			var later []*object.Commit
+			for _, files := range tt.later {
+				later = append(later, r.commit(files))
+			}
+
+			fixedIn, err := findFixingCommit(missing, commit, later)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.fixedIn < 0 {
+				if fixedIn != nil {
+					t.Errorf("expected outstanding, got fixed in later commit %v", fixedIn.Hash)
+				}
+				return
+			}
+			if fixedIn == nil || fixedIn.Hash != later[tt.fixedIn].Hash {
+				t.Errorf("expected fixed in later commit #%d, got %v", tt.fixedIn, fixedIn)
+			}
+		})
+	}
+}
+
+func TestDescendantsOf(t *testing.T) {
+	const base = "a\nb\nc\nd\ne\n"
+	r := newTestRepo(t)
+	root := r.commit(map[string]string{"a.go": base})
+	commit := r.commit(map[string]string{"b.go": "x\n"}, root.Hash)
+	// Diverges from the parent of commit, and changes the missing clone
+	side := r.commit(map[string]string{"a.go": "a\nB\nc\nd\ne\n"}, root.Hash)
+	sideNext := r.commit(map[string]string{"c.go": "y\n"}, side.Hash)
+	mainline := r.commit(map[string]string{"b.go": "z\n"}, commit.Hash)
+	merge := r.commit(map[string]string{"a.go": "a\nb\nC\nd\ne\n"}, mainline.Hash, sideNext.Hash)
+	after := r.commit(map[string]string{"a.go": "A\nb\nc\nd\ne\n"}, merge.Hash)
+
+	// All non-merge commits of the range, ordered by time as by ListCommits
+	later := []*object.Commit{side, sideNext, mainline, after}
+	descendants, err := descendantsOf(commit, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descendants) != 2 || descendants[0].Hash != mainline.Hash || descendants[1].Hash != after.Hash {
+		t.Fatalf("expected %v and %v, got %v", mainline.Hash, after.Hash, descendants)
+	}
+
+	// Changes on the other branch are not fixes
+	fixedIn, err := findFixingCommit(&domain.Clone{Filename: "a.go", StartL: 2, EndL: 2}, commit, descendants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixedIn != nil {
+		t.Errorf("expected outstanding, got fixed in %v", fixedIn.Hash)
+	}
+	fixedIn, err = findFixingCommit(&domain.Clone{Filename: "a.go", StartL: 1, EndL: 1}, commit, descendants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixedIn == nil || fixedIn.Hash != after.Hash {
+		t.Errorf("expected fixed in %v, got %v", after.Hash, fixedIn)
+	}
+}
+
+func TestListCommits(t *testing.T) {
+	r := newTestRepo(t)
+	root := r.commit(map[string]string{"a.go": "1\n"})
+	c1 := r.commit(map[string]string{"a.go": "2\n"})
+	side := r.commit(map[string]string{"b.go": "side\n"}, c1.Hash)
+	mainline := r.commit(map[string]string{"a.go": "3\n"}, c1.Hash)
+	merge := r.commit(map[string]string{"a.go": "4\n"}, mainline.Hash, side.Hash)
+	after := r.commit(map[string]string{"a.go": "5\n"})
+
+	hashes := func(commits ...*object.Commit) []string {
+		ret := make([]string, len(commits))
+		for i, c := range commits {
+			ret[i] = c.Hash.String()[:7]
+		}
+		return ret
+	}
+	rangeOf := func(from, to *object.Commit) string { return from.Hash.String() + ".." + to.Hash.String() }
+	tests := []struct {
+		name        string
+		commitRange string
+		firstParent bool
+		want        []*object.Commit
+	}{
+		{"linear", rangeOf(root, c1), true, []*object.Commit{c1}},
+		{"first parent", rangeOf(root, after), true, []*object.Commit{c1, mainline, merge, after}},
+		{"all non-merge", rangeOf(root, after), false, []*object.Commit{c1, side, mainline, after}},
+		{"from side branch", rangeOf(side, after), true, []*object.Commit{mainline, merge, after}},
+		{"empty", rangeOf(after, after), true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commits, err := ListCommits(r.repo, tt.commitRange, tt.firstParent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, want := hashes(commits...), hashes(tt.want...)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"", "HEAD", "..HEAD", "HEAD..", "HEAD...HEAD", "unknown..HEAD"} {
+		if _, err := ListCommits(r.repo, invalid, true); err == nil {
+			t.Errorf("expected error for range %q", invalid)
+		}
+	}
+}
+
+func TestAnalyze_ContinuesOnError(t *testing.T) {
+	r := newTestRepo(t)
+	root := r.commit(map[string]string{"a.go": "1\n"})
+	commits := []*object.Commit{
+		root,
+		r.commit(map[string]string{"a.go": "2\n"}),
+		r.commit(map[string]string{"a.go": "3\n"}),
+	}
+
+	// Every commit fails, as the algorithm is not registered
+	reports, err := Analyze(context.Background(), commits, "unknown", time.Minute, &search.Config{Matcher: domain.DefaultMatcherRules()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected reports of 2 non-root commits, got %d", len(reports))
+	}
+	for i, report := range reports {
+		if report.Commit.Hash != commits[i+1].Hash {
+			t.Errorf("report #%d: expected commit %v, got %v", i, commits[i+1].Hash, report.Commit.Hash)
+		}
+		if report.Err == nil || !strings.Contains(report.Err.Error(), "unknown") {
+			t.Errorf("report #%d: expected error on the commit, got %v", i, report.Err)
+		}
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"fmt"
 	"github.com/go-git/go-git/v5"
 	"github.com/pkg/errors"
+	"github.com/salab/iccheck/iccheck"
 	"github.com/salab/iccheck/pkg/domain"
-	"github.com/salab/iccheck/pkg/search"
+	"github.com/salab/iccheck/pkg/fix"
 	"github.com/samber/lo"
 	"github.com/sourcegraph/go-lsp"
 	"log/slog"
@@ -65,7 +66,7 @@ func (h *handler) analyzePath(ctx context.Context, gitPath string) (struct{}, er
 	diagnostics := make(map[string][]*lsp.Diagnostic)
 
 	// Calculate
-	cloneSets, err := h.getCloneSets(ctx, gitPath)
+	cloneSets, suggestions, err := h.getCloneSets(ctx, gitPath)
 	if err != nil {
 		return struct{}{}, err
 	}
@@ -166,53 +167,61 @@ func (h *handler) analyzePath(ctx context.Context, gitPath string) (struct{}, er
 
 	// Store current analysis results and diagnostic paths
 	h.previousAnalysis.Store(gitPath, cloneSets)
+	h.previousSuggestions.Store(gitPath, suggestions)
 	h.previousDiagnostics.Store(gitPath, diagnostics)
 
 	return struct{}{}, nil
 }
 
-func (h *handler) getCloneSets(ctx context.Context, gitPath string) ([]*domain.CloneSet, error) {
+// getCloneSets calculates clone sets from HEAD to the current worktree, and suggested changes for missing clones.
+func (h *handler) getCloneSets(ctx context.Context, gitPath string) ([]*domain.CloneSet, []*fix.Suggestion, error) {
 	ctx, cancel := context.WithTimeout(ctx, h.timeout)
 	defer cancel()
 
 	// Open repository
 	repo, err := git.PlainOpen(gitPath)
 	if err != nil {
-		return nil, errors.Wrap(err, "opening git directory")
+		return nil, nil, errors.Wrap(err, "opening git directory")
 	}
 
 	// Get head tree
 	headHash, err := repo.ResolveRevision("HEAD")
 	if err != nil {
-		return nil, errors.Wrapf(err, "resolving hash revision from %v", headHash)
+		return nil, nil, errors.Wrapf(err, "resolving hash revision from %v", headHash)
 	}
 	headCommit, err := repo.CommitObject(*headHash)
 	if err != nil {
-		return nil, errors.Wrapf(err, "resolving commit from hash %v", *headHash)
+		return nil, nil, errors.Wrapf(err, "resolving commit from hash %v", *headHash)
 	}
 	headTree := domain.NewGoGitCommitTree(headCommit, "HEAD")
 
 	// Get overlay tree
 	worktree, err := domain.NewGoGitWorktreeWithOverlay(repo, &h.openFiles)
 	if err != nil {
-		return nil, errors.Wrap(err, "creating domain tree")
+		return nil, nil, errors.Wrap(err, "creating domain tree")
 	}
 
 	// Read search config
-	searchConf, err := h.searchConfCache.Get(ctx, gitPath)
+	analyzer, err := h.analyzerCache.Get(ctx, gitPath)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting search config for %v", gitPath)
+		return nil, nil, errors.Wrapf(err, "getting analyzer for %v", gitPath)
 	}
 
 	// Calculate
-	queries, changedFiles, err := search.DiffTrees(ctx, headTree, worktree)
+	changes := iccheck.TreeChanges(headTree, worktree)
+	result, err := analyzer.Analyze(ctx, changes)
 	if err != nil {
-		return nil, errors.Wrapf(err, "diffing tree")
+		return nil, nil, errors.Wrapf(err, "searching clone sets")
 	}
-	slog.Info(fmt.Sprintf("%d changed text chunk(s) were found within %d changed file(s).", len(queries), changedFiles))
-	cloneSets, err := search.Search(ctx, h.algorithm, queries, worktree, searchConf)
+
+	// Suggest changes for code actions
+	suggester, err := changes.Suggester()
+	if err != nil {
+		return nil, nil, err
+	}
+	suggestions, err := suggester.Suggest(result.CloneSets)
 	if err != nil {
-		return nil, errors.Wrapf(err, "searching clone sets")
+		return nil, nil, errors.Wrapf(err, "suggesting changes")
 	}
-	return cloneSets, nil
+	return result.CloneSets, suggestions, nil
 }
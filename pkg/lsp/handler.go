@@ -3,7 +3,10 @@ package lsp
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -13,6 +16,7 @@ import (
 	"github.com/sourcegraph/jsonrpc2"
 
 	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/fix"
 	"github.com/salab/iccheck/pkg/utils/ds"
 )
 
@@ -62,9 +66,19 @@ type initializeResult struct {
 }
 
 type serverCapabilities struct {
-	TextDocumentSync   lsp.TextDocumentSyncOptions `json:"textDocumentSync"`
-	DiagnosticProvider diagnosticProvider          `json:"diagnosticProvider"`
-	ReferencesProvider bool                        `json:"referencesProvider"`
+	TextDocumentSync       lsp.TextDocumentSyncOptions `json:"textDocumentSync"`
+	DiagnosticProvider     diagnosticProvider          `json:"diagnosticProvider"`
+	ReferencesProvider     bool                        `json:"referencesProvider"`
+	CodeActionProvider     codeActionProvider          `json:"codeActionProvider"`
+	ExecuteCommandProvider executeCommandProvider      `json:"executeCommandProvider"`
+}
+
+type codeActionProvider struct {
+	CodeActionKinds []lsp.CodeActionKind `json:"codeActionKinds"`
+}
+
+type executeCommandProvider struct {
+	Commands []string `json:"commands"`
 }
 
 type diagnosticProvider struct {
@@ -102,6 +116,12 @@ func (h *handler) handleInitialize(_ context.Context, _ *jsonrpc2.Conn, req *jso
 				Change:    lsp.TDSKFull,
 			},
 			ReferencesProvider: true,
+			CodeActionProvider: codeActionProvider{
+				CodeActionKinds: []lsp.CodeActionKind{lsp.CAKQuickFix},
+			},
+			ExecuteCommandProvider: executeCommandProvider{
+				Commands: []string{suppressCloneSetCommand},
+			},
 		},
 	}, nil
 }
@@ -258,9 +278,178 @@ func (h *handler) toLSPLocation(gitPath string, clone *domain.Clone) (*lsp.Locat
 	}, nil
 }
 
-func (h *handler) handleTextDocumentCodeAction(_ context.Context, _ *jsonrpc2.Conn, _ *jsonrpc2.Request) (any, error) {
-	// IntelliJ clients send textDocument/codeAction requests for some reason,
-	// even though we have not announced codeAction capabilities.
-	// For now, in order to suppress error logs, just return 'null' which is also a valid response.
-	return nil, nil
+type codeAction struct {
+	Title       string             `json:"title"`
+	Kind        lsp.CodeActionKind `json:"kind"`
+	IsPreferred bool               `json:"isPreferred,omitempty"`
+	Edit        *lsp.WorkspaceEdit `json:"edit,omitempty"`
+	Command     *lsp.Command       `json:"command,omitempty"`
+}
+
+// suppressCloneSetCommand appends ignore rules for missing clones of a clone set to the ignore file.
+// Arguments are the git path, and the missing clones of the clone set.
+const suppressCloneSetCommand = "iccheck.suppressCloneSet"
+
+func (h *handler) handleTextDocumentCodeAction(_ context.Context, _ *jsonrpc2.Conn, req *jsonrpc2.Request) (any, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+	var params lsp.CodeActionParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	actions := make([]*codeAction, 0)
+	filePath := h.trimFilePrefix(params.TextDocument.URI)
+	gitPathElements, ok := getGitRoot(filePath)
+	if !ok {
+		return actions, nil
+	}
+	gitPath := strings.Join(gitPathElements, string(os.PathSeparator))
+	cloneSets, ok := h.previousAnalysis.Load(gitPath)
+	if !ok {
+		return actions, nil
+	}
+	suggestions, _ := h.previousSuggestions.Load(gitPath)
+
+	// NOTE: LSP location's line is 0-indexed, while our clone data is recorded in 1-indexed manner.
+	startL, endL := params.Range.Start.Line+1, params.Range.End.Line+1
+	for _, cs := range cloneSets {
+		missing := lo.Filter(cs.Missing, func(c *domain.Clone, _ int) bool {
+			return filePath == filepath.Join(gitPath, c.Filename) && c.StartL <= endL && startL <= c.EndL
+		})
+		if len(missing) == 0 {
+			continue
+		}
+
+		// Apply the same change as the changed clone
+		for _, c := range missing {
+			sug, ok := lo.Find(suggestions, func(s *fix.Suggestion) bool { return s.Missing == c })
+			if !ok {
+				continue
+			}
+			actions = append(actions, &codeAction{
+				Title:       fmt.Sprintf("Apply the same change as %s", readablePaths(c.Filename, []*domain.Clone{sug.Template}, 1)),
+				Kind:        lsp.CAKQuickFix,
+				IsPreferred: true,
+				Edit:        h.toLSPWorkspaceEdit(gitPath, sug.Edits),
+			})
+		}
+
+		// Suppress the clone set. Rules in the ignore file are not tied to this clone set,
+		// and ignore the current contents of the missing clones in every analysis of the repository.
+		locations := ds.Map(cs.Missing, func(c *domain.Clone) *domain.Clone {
+			return &domain.Clone{Filename: c.Filename, StartL: c.StartL, EndL: c.EndL}
+		})
+		actions = append(actions, &codeAction{
+			Title: "Ignore these lines in all analyses (add to .iccheckignore.yaml)",
+			Kind:  lsp.CAKQuickFix,
+			Command: &lsp.Command{
+				Title:     "Suppress this clone set",
+				Command:   suppressCloneSetCommand,
+				Arguments: []any{gitPath, locations},
+			},
+		})
+	}
+	return actions, nil
+}
+
+// toLSPWorkspaceEdit converts line-level edits to text edits.
+func (h *handler) toLSPWorkspaceEdit(gitPath string, edits []*fix.Edit) *lsp.WorkspaceEdit {
+	changes := make(map[string][]lsp.TextEdit)
+	for _, e := range edits {
+		detectedPath := filepath.Join(gitPath, e.Filename)
+		uri := string(h.appendFilePrefix(h.osToLSPFilepath(detectedPath)))
+		// Replace whole lines, from the start of StartL to the start of the line after EndL
+		newText := strings.Join(e.Lines, "\n")
+		if len(e.Lines) > 0 {
+			newText += "\n"
+		}
+		changes[uri] = append(changes[uri], lsp.TextEdit{
+			Range: lsp.Range{
+				Start: lsp.Position{Line: e.StartL - 1, Character: 0},
+				End:   lsp.Position{Line: e.EndL, Character: 0},
+			},
+			NewText: newText,
+		})
+	}
+	return &lsp.WorkspaceEdit{Changes: changes}
+}
+
+func (h *handler) handleWorkspaceExecuteCommand(ctx context.Context, _ *jsonrpc2.Conn, req *jsonrpc2.Request) (any, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+	var params struct {
+		Command   string            `json:"command"`
+		Arguments []json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	switch params.Command {
+	case suppressCloneSetCommand:
+		var gitPath string
+		var clones []*domain.Clone
+		if len(params.Arguments) != 2 {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+		}
+		if err := json.Unmarshal(params.Arguments[0], &gitPath); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(params.Arguments[1], &clones); err != nil {
+			return nil, err
+		}
+		// Only accept repositories analyzed by this server, as the ignore file is written there
+		if _, ok := h.previousAnalysis.Load(gitPath); !ok {
+			return nil, &jsonrpc2.Error{
+				Code:    jsonrpc2.CodeInvalidParams,
+				Message: fmt.Sprintf("unknown repository: %s", gitPath),
+			}
+		}
+		for _, c := range clones {
+			if !filepath.IsLocal(c.Filename) {
+				return nil, &jsonrpc2.Error{
+					Code:    jsonrpc2.CodeInvalidParams,
+					Message: fmt.Sprintf("file outside the repository: %s", c.Filename),
+				}
+			}
+		}
+		return nil, h.suppressClones(ctx, gitPath, clones)
+	}
+	return nil, &jsonrpc2.Error{
+		Code:    jsonrpc2.CodeMethodNotFound,
+		Message: fmt.Sprintf("command not supported: %s", params.Command),
+	}
+}
+
+// suppressClones appends rules to ignore the current contents of the clones to the ignore file, and re-analyzes.
+func (h *handler) suppressClones(ctx context.Context, gitPath string, clones []*domain.Clone) error {
+	configs, err := ds.MapError(clones, func(c *domain.Clone) (*domain.IgnoreConfig, error) {
+		lines, err := h.filesCache.Get(ctx, filepath.Join(gitPath, c.Filename))
+		if err != nil {
+			return nil, err
+		}
+		if c.StartL < 1 || len(lines) < c.EndL {
+			return nil, errors.Errorf("%s#L%d-L%d is out of range", c.Filename, c.StartL, c.EndL)
+		}
+		return domain.IgnoreConfigForLines(c.Filename, lines[c.StartL-1:c.EndL]), nil
+	})
+	if err != nil {
+		return &jsonrpc2.Error{Code: jsonrpc2.CodeInternalError, Message: err.Error()}
+	}
+	// Clones with the same contents in the same file result in the same rule
+	configs = lo.UniqBy(configs, func(c *domain.IgnoreConfig) string {
+		return strings.Join(c.Files, "\n") + "\x00" + strings.Join(c.Patterns, "\n")
+	})
+	path, err := domain.AppendIgnoreConfigs(gitPath, "Suppressed clone set, added by ICCheck language server", configs)
+	if err != nil {
+		return &jsonrpc2.Error{Code: jsonrpc2.CodeInternalError, Message: err.Error()}
+	}
+	slog.Info(fmt.Sprintf("Suppressed %d clone(s) in %v", len(clones), path))
+
+	h.analyzerCache.Forget(gitPath)
+	h.debouncedAnalyze(gitPath)
+	return nil
 }
@@ -0,0 +1,176 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/motoki317/sc"
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+
+	"github.com/salab/iccheck/iccheck"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/fix"
+)
+
+// newTestHandler returns a handler initialized at a new repository with the given files,
+// and previous analysis results of cloneSets.
+// Re-analyses requested by the handler are recorded in analyzed instead of being run.
+func newTestHandler(t *testing.T, files map[string]string, cloneSets []*domain.CloneSet, suggestions []*fix.Suggestion) (h *handler, dir string, analyzed *[]string) {
+	t.Helper()
+	dir = t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) }) // initialize changes the working directory
+
+	analyzed = new([]string)
+	h = &handler{}
+	h.analyzerCache = sc.NewMust(func(context.Context, string) (*iccheck.Analyzer, error) {
+		return nil, errors.New("not supported in tests")
+	}, time.Minute, time.Minute)
+	h.filesCache = sc.NewMust(h.readFile, time.Minute, time.Minute)
+	h.debouncedAnalyze = func(gitPath string) { *analyzed = append(*analyzed, gitPath) }
+
+	call(t, h, "initialize", lsp.InitializeParams{RootURI: lsp.DocumentURI(fileURIPrefix + dir)})
+	// The repository root is the working directory
+	h.previousAnalysis.Store("", cloneSets)
+	h.previousSuggestions.Store("", suggestions)
+	return h, dir, analyzed
+}
+
+func call(t *testing.T, h *handler, method string, params any) (json.RawMessage, error) {
+	t.Helper()
+	b, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := h.handle(context.Background(), nil, &jsonrpc2.Request{Method: method, Params: (*json.RawMessage)(&b)})
+	if err != nil {
+		return nil, err
+	}
+	out, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out, nil
+}
+
+func TestHandler_CodeAction(t *testing.T) {
+	changed := &domain.Clone{Filename: "b.go", StartL: 1, EndL: 2}
+	missing := &domain.Clone{Filename: "a.go", StartL: 2, EndL: 3}
+	cloneSets := []*domain.CloneSet{{Changed: []*domain.Clone{changed}, Missing: []*domain.Clone{missing}}}
+	suggestions := []*fix.Suggestion{{
+		Set:      cloneSets[0],
+		Missing:  missing,
+		Template: changed,
+		Edits:    []*fix.Edit{{Filename: "a.go", StartL: 2, EndL: 2, Lines: []string{"y := 2"}}},
+	}}
+	h, dir, _ := newTestHandler(t, map[string]string{"a.go": "package a\nx := 1\nuse(x)\n"}, cloneSets, suggestions)
+
+	codeActions := func(startLine, endLine int) []*codeAction {
+		t.Helper()
+		res, err := call(t, h, "textDocument/codeAction", lsp.CodeActionParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: lsp.DocumentURI(fileURIPrefix + dir + "/a.go")},
+			Range:        lsp.Range{Start: lsp.Position{Line: startLine}, End: lsp.Position{Line: endLine}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actions []*codeAction
+		if err := json.Unmarshal(res, &actions); err != nil {
+			t.Fatal(err)
+		}
+		return actions
+	}
+
+	if actions := codeActions(0, 0); len(actions) != 0 {
+		t.Errorf("expected no actions outside the missing clone, got %d", len(actions))
+	}
+
+	actions := codeActions(2, 2)
+	if len(actions) != 2 {
+		t.Fatalf("expected 2 actions on the missing clone, got %d", len(actions))
+	}
+	apply := actions[0]
+	if !strings.Contains(apply.Title, "b.go#L1-L2") || apply.Edit == nil {
+		t.Fatalf("expected action applying the change, got %+v", apply)
+	}
+	edits := apply.Edit.Changes[fileURIPrefix+dir+"/a.go"]
+	if len(edits) != 1 || edits[0].NewText != "y := 2\n" || edits[0].Range.Start.Line != 1 || edits[0].Range.End.Line != 2 {
+		t.Errorf("expected edit replacing L2 of a.go, got %+v", apply.Edit.Changes)
+	}
+
+	suppress := actions[1]
+	if !strings.Contains(suppress.Title, "all analyses") || suppress.Command == nil || suppress.Command.Command != suppressCloneSetCommand {
+		t.Errorf("expected action suppressing the clone set, got %+v", suppress)
+	}
+}
+
+func TestHandler_ExecuteCommand(t *testing.T) {
+	missing := []*domain.Clone{{Filename: "a.go", StartL: 2, EndL: 3}}
+	cloneSets := []*domain.CloneSet{{Changed: []*domain.Clone{{Filename: "b.go", StartL: 1, EndL: 2}}, Missing: missing}}
+	const content = "package a\n\tx := 1\n\tuse(x)\n"
+	h, dir, analyzed := newTestHandler(t, map[string]string{"a.go": content}, cloneSets, nil)
+
+	execute := func(command string, arguments ...any) error {
+		t.Helper()
+		_, err := call(t, h, "workspace/executeCommand", lsp.ExecuteCommandParams{Command: command, Arguments: arguments})
+		return err
+	}
+
+	tests := []struct {
+		name      string
+		command   string
+		arguments []any
+	}{
+		{"unknown command", "iccheck.unknown", nil},
+		{"missing arguments", suppressCloneSetCommand, []any{""}},
+		{"unknown repository", suppressCloneSetCommand, []any{"/unknown", missing}},
+		{"file outside the repository", suppressCloneSetCommand, []any{"", []*domain.Clone{{Filename: "../a.go", StartL: 1, EndL: 1}}}},
+		{"out of range", suppressCloneSetCommand, []any{"", []*domain.Clone{{Filename: "a.go", StartL: 3, EndL: 10}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := execute(tt.command, tt.arguments...); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".iccheckignore.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no ignore file to be written on errors, got %v", err)
+	}
+
+	if err := execute(suppressCloneSetCommand, "", missing); err != nil {
+		t.Fatal(err)
+	}
+	if len(*analyzed) != 1 || (*analyzed)[0] != "" {
+		t.Errorf("expected re-analysis of the repository, got %v", *analyzed)
+	}
+	matcher, err := domain.ReadMatcherRules(dir, true, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lines are ignored wherever they are in the file
+	_, ignoreRule := matcher.Match("a.go", []byte("package a\n\nfunc f() {\n\tx := 1\n\tuse(x)\n}\n"))
+	for _, l := range []int{3, 4} {
+		if _, ok := ignoreRule.IgnoreLines[l]; !ok {
+			t.Errorf("expected 0-indexed line %d to be ignored, got %v", l, ignoreRule.IgnoreLines)
+		}
+	}
+}
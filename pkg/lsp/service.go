@@ -7,8 +7,9 @@ import (
 	"fmt"
 	"github.com/kevinms/leakybucket-go"
 	"github.com/motoki317/sc"
+	"github.com/salab/iccheck/iccheck"
 	"github.com/salab/iccheck/pkg/domain"
-	"github.com/salab/iccheck/pkg/search"
+	"github.com/salab/iccheck/pkg/fix"
 	"github.com/salab/iccheck/pkg/utils/ds"
 	"github.com/samber/lo"
 	"github.com/sourcegraph/go-lsp"
@@ -21,15 +22,15 @@ import (
 type handler struct {
 	conn *jsonrpc2.Conn
 
-	searchConfCache *sc.Cache[string, *search.Config]
+	analyzerCache *sc.Cache[string, *iccheck.Analyzer]
 
 	filesCache          *sc.Cache[string, []string]
 	analyzeCache        *sc.Cache[string, struct{}]
 	debouncedAnalyze    func(gitPath string)
 	previousDiagnostics ds.SyncMap[string, map[string][]*lsp.Diagnostic]
 	previousAnalysis    ds.SyncMap[string, []*domain.CloneSet]
+	previousSuggestions ds.SyncMap[string, []*fix.Suggestion]
 
-	algorithm   string
 	timeout     time.Duration
 	lspRootPath string
 	openFiles   ds.SyncMap[string, string]
@@ -43,20 +44,20 @@ var analyzeDebounce = 500 * time.Millisecond
 const targetUtilization = 0.25
 const bucketCapacitySeconds = 30
 
+// NewHandler returns the handler of the language server.
+// getAnalyzer returns the analyzer of each repository, which can differ per repository by project config files.
 func NewHandler(
-	algorithm string,
 	timeout time.Duration,
-	getSearchConf func(repoDir string) (*search.Config, error),
+	getAnalyzer func(repoDir string) (*iccheck.Analyzer, error),
 ) jsonrpc2.Handler {
 	h := &handler{
-		algorithm: algorithm,
 		timeout:   timeout,
 		limiter:   leakybucket.NewLeakyBucket(targetUtilization*1000, bucketCapacitySeconds*1000), // in milliseconds
 		openFiles: ds.SyncMap[string, string]{},
 	}
 
-	h.searchConfCache = sc.NewMust(func(ctx context.Context, repoDir string) (*search.Config, error) {
-		return getSearchConf(repoDir)
+	h.analyzerCache = sc.NewMust(func(ctx context.Context, repoDir string) (*iccheck.Analyzer, error) {
+		return getAnalyzer(repoDir)
 	}, time.Minute, 2*time.Minute)
 
 	// Dedupe calls to clone set calculation
@@ -98,6 +99,8 @@ func (h *handler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2
 		return h.handleTextDocumentReferences(ctx, conn, req)
 	case "textDocument/codeAction":
 		return h.handleTextDocumentCodeAction(ctx, conn, req)
+	case "workspace/executeCommand":
+		return h.handleWorkspaceExecuteCommand(ctx, conn, req)
 	}
 
 	return nil, &jsonrpc2.Error{
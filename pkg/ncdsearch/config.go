@@ -21,6 +21,7 @@ type config struct {
 	filterThreshold float64
 	searchThreshold float64
 	windowSizeMult  float64
+	partialResults  bool
 }
 
 func defaultConfig() *config {
@@ -72,3 +73,11 @@ func WithWindowSizeMultiplier(windowSizeMult float64) ConfigFunc {
 		c.windowSizeMult = windowSizeMult
 	}
 }
+
+// WithPartialResults sets whether to return results of the files already searched, when ctx is done.
+// See domain.SearchFiles.
+func WithPartialResults(partial bool) ConfigFunc {
+	return func(c *config) {
+		c.partialResults = partial
+	}
+}
@@ -9,15 +9,12 @@ import (
 	"context"
 	"fmt"
 	"math"
-	"runtime"
 	"slices"
 	"sort"
 
 	"github.com/pkg/errors"
 	"github.com/salab/iccheck/pkg/domain"
 	"github.com/salab/iccheck/pkg/utils/ds"
-	"github.com/samber/lo"
-	"github.com/sourcegraph/conc/pool"
 
 	"github.com/salab/iccheck/pkg/utils/files"
 	"github.com/salab/iccheck/pkg/utils/strs"
@@ -85,15 +82,23 @@ func tokenIndices(s []byte, tokenize TokenizeFunc) []int {
 	return indices
 }
 
+type Source struct {
+	Filename     string
+	StartL, EndL int
+}
+
 type Clone struct {
 	Filename  string
 	StartLine int
 	EndLine   int
 	KBest     int
 	Distance  float64
+	// Source indicates from which query this clone was detected
+	Source Source
 }
 
 func codeSearch(
+	source Source,
 	q []byte,
 	windowSize int,
 	threshold float64,
@@ -128,15 +133,18 @@ func codeSearch(
 		kBest, distance := compareLZJD(b, pos, lzSetQ)
 		if distance < threshold {
 			startLine := getLine(tokenStartIdx)
-			if canSkip, _ := ignoreRule.CanSkip(startLine, windowSize); canSkip {
+			endLine := getLine(tokenEndIdx) // TODO: get accurate end line
+			// windowSize is in tokens - check the lines this window spans (0-indexed) instead
+			if canSkip, _ := ignoreRule.CanSkip(startLine-1, endLine-startLine+1); canSkip {
 				continue
 			}
 			clones = append(clones, &Clone{
 				Filename:  filename,
 				StartLine: startLine,
-				EndLine:   getLine(tokenEndIdx), // TODO: get accurate end line
+				EndLine:   endLine,
 				KBest:     kBest,
 				Distance:  distance,
+				Source:    source,
 			})
 		}
 	}
@@ -192,6 +200,7 @@ func fileSearch(
 			return nil, nil
 		}
 		result := codeSearch(
+			q.toSource(),
 			q.contents,
 			q.windowSize,
 			c.searchThreshold,
@@ -215,6 +224,14 @@ type Query struct {
 	windowSize int
 }
 
+func (q *Query) toSource() Source {
+	return Source{
+		Filename: q.Filename,
+		StartL:   q.StartL,
+		EndL:     q.EndL,
+	}
+}
+
 func (q *Query) readContents(c *config, queryTree domain.Searcher) error {
 	f, err := queryTree.Open(q.Filename)
 	if err != nil {
@@ -266,23 +283,13 @@ func Search(
 		return nil, errors.Wrap(err, "listing search tree files")
 	}
 
-	p := pool.NewWithResults[[]*Clone]().
-		WithMaxGoroutines(runtime.NumCPU()).
-		WithErrors().
-		WithContext(ctx).
-		WithCancelOnError().
-		WithFirstError()
-	for _, searchFile := range searchFiles {
-		p.Go(func(ctx context.Context) ([]*Clone, error) {
-			return fileSearch(ctx, c, queries, searchTree, searchFile, matcher)
-		})
-	}
-	results, err := p.Wait()
-	if err != nil {
+	clones, err := domain.SearchFiles(ctx, searchFiles, c.partialResults, func(ctx context.Context, searchFile string) ([]*Clone, error) {
+		return fileSearch(ctx, c, queries, searchTree, searchFile, matcher)
+	})
+	if _, ok := domain.AsIncomplete(err); err != nil && !ok {
 		return nil, err
 	}
-	clones := lo.Flatten(results)
 
 	slices.SortFunc(clones, ds.SortDesc(func(c *Clone) float64 { return c.Distance }))
-	return clones, nil
+	return clones, err
 }
@@ -0,0 +1,52 @@
+package ncdsearch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+func TestCodeSearch_IgnoreLines(t *testing.T) {
+	const query = "if response.StatusCode != http.StatusOK {\n\treturn nil, fmt.Errorf(\"unexpected status %d\", response.StatusCode)\n}\n"
+	content := "package client\n\nfunc fetch() {\n" + query + "\tdefer response.Body.Close()\n\tbody, err := io.ReadAll(response.Body)\n\tuse(body, err)\n}\n"
+	lines := strings.Split(content, "\n")
+
+	tests := []struct {
+		name        string
+		ignoreLines []int
+		found       bool
+	}{
+		{"not ignored", nil, true},
+		{"first line ignored", []int{4}, false},
+		{"last line ignored", []int{6}, false},
+		{"line before ignored", []int{3}, true},
+		// Window size is in tokens, which should not be taken as lines
+		{"line after ignored", []int{8}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ignoreConfigs []*domain.IgnoreConfig
+			for _, l := range tt.ignoreLines {
+				ignoreConfigs = append(ignoreConfigs, domain.IgnoreConfigForLines("a.go", []string{lines[l-1]}))
+			}
+			matcher, err := domain.ReadMatcherRulesWithConfigs(t.TempDir(), true, ignoreConfigs, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, ignoreRule := matcher.Match("a.go", []byte(content))
+
+			tokens := DefaultTokenizeFunc([]byte(query))
+			clones := codeSearch(Source{}, []byte(query), len(tokens), 0.1, []byte(content), "a.go", DefaultTokenizeFunc, ignoreRule)
+			found := false
+			for _, c := range clones {
+				if c.StartLine == 4 {
+					found = true
+				}
+			}
+			if found != tt.found {
+				t.Errorf("expected found = %v, got clones %+v", tt.found, clones)
+			}
+		})
+	}
+}
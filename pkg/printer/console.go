@@ -3,23 +3,61 @@ package printer
 import (
 	"bytes"
 	"fmt"
+
 	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/history"
+	"github.com/samber/lo"
 )
 
-type consolePrinter struct{}
+type consolePrinter struct {
+	// snippets is nil if code snippets should not be printed
+	snippets *snippetPrinter
+}
 
 func NewConsolePrinter() Printer {
 	return &consolePrinter{}
 }
 
+// NewConsoleSnippetPrinter returns a console printer which also prints code snippets of each clone set.
+func NewConsoleSnippetPrinter(opts *SnippetOptions) Printer {
+	return &consolePrinter{snippets: newSnippetPrinter(opts)}
+}
+
 func (s *consolePrinter) cloneToStr(c *domain.Clone) string {
 	return fmt.Sprintf("%s:%d (L%d-L%d)", c.Filename, c.StartL, c.StartL, c.EndL)
 }
 
-func (s *consolePrinter) PrintClones(sets []*domain.CloneSet) []byte {
+func (s *consolePrinter) PrintClones(sets []*domain.CloneSet, coverage *domain.Coverage) []byte {
 	var buf bytes.Buffer
 	for i, set := range sets {
 		buf.WriteString("\n")
+		if set.Standalone {
+			buf.WriteString(fmt.Sprintf("Clone set #%d - %d clones.\n", i, len(set.Missing)))
+			for _, c := range set.Missing {
+				buf.WriteString("    " + s.cloneToStr(c) + "\n")
+			}
+			if s.snippets != nil {
+				s.snippets.printStandalone(&buf, set)
+			}
+			continue
+		}
+		if set.Deleted {
+			buf.WriteString(
+				fmt.Sprintf("Clone set #%d - code was deleted, but %d clone(s) likely remain.\n", i, len(set.Missing)),
+			)
+			buf.WriteString(fmt.Sprintf("  Remaining clones (%d):\n", len(set.Missing)))
+			for _, c := range set.Missing {
+				buf.WriteString("    " + s.cloneToStr(c) + "\n")
+			}
+			buf.WriteString(fmt.Sprintf("  Deleted code (%d):\n", len(set.Changed)))
+			for _, c := range set.Changed {
+				buf.WriteString("    " + s.cloneToStr(c) + "\n")
+			}
+			if s.snippets != nil {
+				s.snippets.printSideBySide(&buf, set)
+			}
+			continue
+		}
 		buf.WriteString(
 			fmt.Sprintf("Clone set #%d - %d out of %d clones are likely missing consistent change(s).\n", i, len(set.Missing), len(set.Missing)+len(set.Changed)),
 		)
@@ -31,6 +69,63 @@ func (s *consolePrinter) PrintClones(sets []*domain.CloneSet) []byte {
 		for _, c := range set.Changed {
 			buf.WriteString("    " + s.cloneToStr(c) + "\n")
 		}
+		if s.snippets != nil {
+			s.snippets.printSideBySide(&buf, set)
+		}
+	}
+	if coverage != nil {
+		buf.WriteString("\n" + incompleteMessage(coverage) + "\n")
+	}
+	return buf.Bytes()
+}
+
+func (s *consolePrinter) PrintHistory(reports []*history.CommitReport) []byte {
+	var buf bytes.Buffer
+	var missing, outstanding, incomplete, failed int
+	for _, report := range reports {
+		if len(report.CloneSets) == 0 && report.Coverage == nil && report.Err == nil {
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("\nCommit %s - %s\n", report.Commit.Hash.String()[:7], commitSubject(report.Commit)))
+		if report.Err != nil {
+			buf.WriteString("  Analysis failed: " + report.Err.Error() + "\n")
+			failed++
+		}
+		if report.Coverage != nil {
+			buf.WriteString("  " + incompleteMessage(report.Coverage) + "\n")
+			incomplete++
+		}
+		for i, set := range report.CloneSets {
+			buf.WriteString(
+				fmt.Sprintf("  Clone set #%d - %d out of %d clones were likely missing consistent change(s), %d still outstanding.\n",
+					i, len(set.Missing), len(set.Missing)+len(set.Changed), set.Outstanding()),
+			)
+			buf.WriteString(fmt.Sprintf("    Missing changes (%d):\n", len(set.Missing)))
+			for j, c := range set.Missing {
+				status := lo.TernaryF(
+					set.FixedIn[j] == nil,
+					func() string { return "outstanding" },
+					func() string { return "fixed in " + set.FixedIn[j].Hash.String()[:7] },
+				)
+				buf.WriteString("      " + s.cloneToStr(c) + " - " + status + "\n")
+			}
+			buf.WriteString(fmt.Sprintf("    Changed clones (%d):\n", len(set.Changed)))
+			for _, c := range set.Changed {
+				buf.WriteString("      " + s.cloneToStr(c) + "\n")
+			}
+			missing += len(set.Missing)
+			outstanding += set.Outstanding()
+		}
+	}
+	buf.WriteString(fmt.Sprintf(
+		"\n%d missing change(s) found in %d commit(s): %d fixed later, %d still outstanding.\n",
+		missing, len(reports), missing-outstanding, outstanding,
+	))
+	if incomplete > 0 {
+		buf.WriteString(fmt.Sprintf("Analysis of %d commit(s) timed out, and their results are incomplete.\n", incomplete))
+	}
+	if failed > 0 {
+		buf.WriteString(fmt.Sprintf("Analysis of %d commit(s) failed, and they are not included in the results.\n", failed))
 	}
 	return buf.Bytes()
 }
@@ -14,10 +14,46 @@ func NewGitHubPrinter() Printer {
 	return &githubPrinter{}
 }
 
-func (g *githubPrinter) PrintClones(sets []*domain.CloneSet) []byte {
+func (g *githubPrinter) PrintClones(sets []*domain.CloneSet, coverage *domain.Coverage) []byte {
 	var buf bytes.Buffer
 
 	for _, set := range sets {
+		if set.Standalone {
+			for _, c := range set.Missing {
+				buf.WriteString(
+					fmt.Sprintf("::notice file=%s,line=%d,endLine=%d,title=%s::%s\n",
+						c.Filename,
+						c.StartL,
+						c.EndL,
+						"Code clone",
+						fmt.Sprintf(
+							"Code clone here (L%d - L%d) (%d clone(s) in this clone set)",
+							c.StartL, c.EndL,
+							len(set.Missing),
+						),
+					),
+				)
+			}
+			continue
+		}
+		if set.Deleted {
+			for _, c := range set.Missing {
+				buf.WriteString(
+					fmt.Sprintf("::notice file=%s,line=%d,endLine=%d,title=%s::%s\n",
+						c.Filename,
+						c.StartL,
+						c.EndL,
+						"Possibly remaining copy of deleted code",
+						fmt.Sprintf(
+							"Possibly a remaining copy of deleted code here (L%d - L%d) (deleted from %s)",
+							c.StartL, c.EndL,
+							set.Changed[0].Filename,
+						),
+					),
+				)
+			}
+			continue
+		}
 		for _, c := range set.Missing {
 			buf.WriteString(
 				fmt.Sprintf("::notice file=%s,line=%d,endLine=%d,title=%s::%s\n",
@@ -34,6 +70,9 @@ func (g *githubPrinter) PrintClones(sets []*domain.CloneSet) []byte {
 			)
 		}
 	}
+	if coverage != nil {
+		buf.WriteString(fmt.Sprintf("::warning title=%s::%s\n", "Incomplete results", incompleteMessage(coverage)))
+	}
 
 	return buf.Bytes()
 }
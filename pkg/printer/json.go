@@ -3,7 +3,9 @@ package printer
 import (
 	"bytes"
 	"encoding/json"
+
 	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/history"
 	"github.com/salab/iccheck/pkg/utils/ds"
 	"github.com/samber/lo"
 )
@@ -21,16 +23,24 @@ type jsonSource struct {
 }
 
 type jsonClone struct {
+	// ID is the stable fingerprint of a missing clone, only printed by NDJSONPrinter
+	ID       string        `json:"id,omitempty"`
 	Filename string        `json:"filename"`
 	StartL   int           `json:"start_l"`
 	EndL     int           `json:"end_l"`
 	Distance float64       `json:"distance"`
 	Sources  []*jsonSource `json:"sources"`
+	// SuggestedPatch is a unified diff to apply to this missing clone, if --suggest-patch is set
+	SuggestedPatch string `json:"suggested_patch,omitempty"`
 }
 
 type jsonCloneSet struct {
 	Missing []*jsonClone `json:"missing"`
 	Changed []*jsonClone `json:"changed"`
+	// Deleted indicates "changed" clones were deleted, and "missing" clones are copies still remaining
+	Deleted bool `json:"deleted,omitempty"`
+	// Standalone indicates the clone set was detected without changes, and all clones are in "missing"
+	Standalone bool `json:"standalone,omitempty"`
 }
 
 func (j *jsonPrinter) formatClone(c *domain.Clone) *jsonClone {
@@ -46,22 +56,110 @@ func (j *jsonPrinter) formatClone(c *domain.Clone) *jsonClone {
 				EndL:     s.EndL,
 			}
 		}),
+		SuggestedPatch: c.SuggestedPatch,
 	}
 }
 
 func (j *jsonPrinter) formatCloneSet(set *domain.CloneSet) *jsonCloneSet {
 	return &jsonCloneSet{
-		Missing: ds.Map(set.Missing, func(c *domain.Clone) *jsonClone { return j.formatClone(c) }),
-		Changed: ds.Map(set.Changed, func(c *domain.Clone) *jsonClone { return j.formatClone(c) }),
+		Missing:    ds.Map(set.Missing, func(c *domain.Clone) *jsonClone { return j.formatClone(c) }),
+		Changed:    ds.Map(set.Changed, func(c *domain.Clone) *jsonClone { return j.formatClone(c) }),
+		Deleted:    set.Deleted,
+		Standalone: set.Standalone,
 	}
 }
 
-func (j *jsonPrinter) PrintClones(sets []*domain.CloneSet) []byte {
+// jsonCoverageType tags jsonIncomplete, to tell it apart from clone sets in the same stream.
+const jsonCoverageType = "coverage"
+
+// jsonIncomplete is printed after clone sets, if the search was interrupted and the clone sets are partial.
+type jsonIncomplete struct {
+	Type          string `json:"type"`
+	Incomplete    bool   `json:"incomplete"`
+	SearchedFiles int    `json:"searched_files"`
+	TotalFiles    int    `json:"total_files"`
+}
+
+func (j *jsonPrinter) PrintClones(sets []*domain.CloneSet, coverage *domain.Coverage) []byte {
 	var buf bytes.Buffer
 	encoder := json.NewEncoder(&buf)
 	for _, set := range sets {
 		obj := j.formatCloneSet(set)
 		lo.Must0(encoder.Encode(obj))
 	}
+	if coverage != nil {
+		lo.Must0(encoder.Encode(&jsonIncomplete{
+			Type:          jsonCoverageType,
+			Incomplete:    true,
+			SearchedFiles: coverage.SearchedFiles,
+			TotalFiles:    coverage.TotalFiles,
+		}))
+	}
+	return buf.Bytes()
+}
+
+type jsonHistoryClone struct {
+	*jsonClone
+	// FixedIn is the commit hash which later changed this missing clone, or null if still outstanding
+	FixedIn *string `json:"fixed_in"`
+}
+
+type jsonHistoryCloneSet struct {
+	Missing []*jsonHistoryClone `json:"missing"`
+	Changed []*jsonClone        `json:"changed"`
+}
+
+type jsonCommitReport struct {
+	Commit    string                 `json:"commit"`
+	Subject   string                 `json:"subject"`
+	CloneSets []*jsonHistoryCloneSet `json:"clone_sets"`
+	// Incomplete indicates the analysis of the commit was interrupted, and clone sets are partial
+	Incomplete    bool `json:"incomplete,omitempty"`
+	SearchedFiles *int `json:"searched_files,omitempty"`
+	TotalFiles    *int `json:"total_files,omitempty"`
+	// Error is the reason the analysis of the commit failed, if any
+	Error string `json:"error,omitempty"`
+}
+
+func (j *jsonPrinter) formatHistoryCloneSet(set *history.CloneSet) *jsonHistoryCloneSet {
+	missing := make([]*jsonHistoryClone, len(set.Missing))
+	for i, c := range set.Missing {
+		missing[i] = &jsonHistoryClone{
+			jsonClone: j.formatClone(c),
+			FixedIn: lo.TernaryF(
+				set.FixedIn[i] == nil,
+				func() *string { return nil },
+				func() *string { return lo.ToPtr(set.FixedIn[i].Hash.String()) },
+			),
+		}
+	}
+	return &jsonHistoryCloneSet{
+		Missing: missing,
+		Changed: ds.Map(set.Changed, func(c *domain.Clone) *jsonClone { return j.formatClone(c) }),
+	}
+}
+
+func (j *jsonPrinter) PrintHistory(reports []*history.CommitReport) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, report := range reports {
+		if len(report.CloneSets) == 0 && report.Coverage == nil && report.Err == nil {
+			continue
+		}
+		obj := &jsonCommitReport{
+			Commit:    report.Commit.Hash.String(),
+			Subject:   commitSubject(report.Commit),
+			CloneSets: ds.Map(report.CloneSets, j.formatHistoryCloneSet),
+		}
+		if report.Coverage != nil {
+			obj.Incomplete = true
+			obj.SearchedFiles = &report.Coverage.SearchedFiles
+			obj.TotalFiles = &report.Coverage.TotalFiles
+		}
+		if report.Err != nil {
+			obj.Error = report.Err.Error()
+		}
+		lo.Must0(encoder.Encode(obj))
+	}
 	return buf.Bytes()
 }
@@ -0,0 +1,93 @@
+package printer
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/history"
+)
+
+func TestJsonPrinter_PrintClones_Incomplete(t *testing.T) {
+	sets := []*domain.CloneSet{{
+		Changed: []*domain.Clone{{Filename: "a.go", StartL: 1, EndL: 3}},
+		Missing: []*domain.Clone{{Filename: "b.go", StartL: 5, EndL: 7}},
+	}}
+	p := NewJsonPrinter()
+
+	out := strings.TrimSpace(string(p.PrintClones(sets, nil)))
+	if lines := strings.Split(out, "\n"); len(lines) != 1 {
+		t.Fatalf("expected 1 record for a complete search, got %q", out)
+	}
+
+	out = strings.TrimSpace(string(p.PrintClones(sets, &domain.Coverage{SearchedFiles: 3, TotalFiles: 10})))
+	lines := strings.Split(out, "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a clone set and a coverage record, got %q", out)
+	}
+	var set map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &set); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := set["type"]; ok {
+		t.Errorf("expected no type field in clone sets, got %s", lines[0])
+	}
+	var obj jsonIncomplete
+	if err := json.Unmarshal([]byte(lines[1]), &obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Type != "coverage" || !obj.Incomplete || obj.SearchedFiles != 3 || obj.TotalFiles != 10 {
+		t.Errorf("expected coverage record, got %s", lines[1])
+	}
+}
+
+func TestJsonPrinter_PrintHistory_Incomplete(t *testing.T) {
+	commit := &object.Commit{Hash: plumbing.NewHash("0123456789abcdef0123456789abcdef01234567"), Message: "Fix a\n\nbody"}
+	reports := []*history.CommitReport{
+		{Commit: commit, Coverage: &domain.Coverage{SearchedFiles: 3, TotalFiles: 10}},
+		{Commit: commit}, // Complete without clone sets, not printed
+	}
+	out := strings.TrimSpace(string(NewJsonPrinter().(HistoryPrinter).PrintHistory(reports)))
+	lines := strings.Split(out, "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 record, got %q", out)
+	}
+	var obj jsonCommitReport
+	if err := json.Unmarshal([]byte(lines[0]), &obj); err != nil {
+		t.Fatal(err)
+	}
+	if !obj.Incomplete || obj.SearchedFiles == nil || *obj.SearchedFiles != 3 || obj.TotalFiles == nil || *obj.TotalFiles != 10 {
+		t.Errorf("expected incomplete report with coverage, got %s", lines[0])
+	}
+	if obj.Subject != "Fix a" {
+		t.Errorf("unexpected subject %q", obj.Subject)
+	}
+
+	console := string(NewConsolePrinter().(HistoryPrinter).PrintHistory(reports))
+	if !strings.Contains(console, "results are incomplete: searched 3 out of 10 file(s)") {
+		t.Errorf("expected console output to report coverage, got %q", console)
+	}
+}
+
+func TestJsonPrinter_PrintHistory_Failed(t *testing.T) {
+	commit := &object.Commit{Hash: plumbing.NewHash("0123456789abcdef0123456789abcdef01234567"), Message: "Fix a"}
+	reports := []*history.CommitReport{{Commit: commit, Err: errors.New("reading object: not found")}}
+
+	var obj jsonCommitReport
+	if err := json.Unmarshal(NewJsonPrinter().(HistoryPrinter).PrintHistory(reports), &obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Error != "reading object: not found" {
+		t.Errorf("expected error to be reported, got %q", obj.Error)
+	}
+
+	console := string(NewConsolePrinter().(HistoryPrinter).PrintHistory(reports))
+	if !strings.Contains(console, "Analysis failed: reading object: not found") {
+		t.Errorf("expected console output to report the error, got %q", console)
+	}
+}
@@ -0,0 +1,203 @@
+package printer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/cli"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/samber/lo"
+)
+
+// NDJSONSchemaVersion is the version of records printed by NDJSONPrinter.
+// Incremented when records change in an incompatible way.
+const NDJSONSchemaVersion = 1
+
+// NDJSONPrinter prints records of a run in newline-delimited JSON:
+// a header record, a record for each clone set, and a trailing summary record.
+//
+// Each method returns complete lines, so that callers can write records as soon as they are available,
+// and consumers can start processing early.
+// Note that clone set records are only available after the whole search, since clone sets are formed
+// by grouping all clones found (a clone found later might join an existing set).
+type NDJSONPrinter struct {
+	json jsonPrinter
+
+	cloneSets     int
+	missingClones int
+}
+
+func NewNDJSONPrinter() *NDJSONPrinter {
+	return &NDJSONPrinter{}
+}
+
+// RunHeader describes the run, printed before searching.
+type RunHeader struct {
+	Command string
+	// From is the base tree, or nil if not applicable (such as for patch files or the clones command)
+	From domain.Tree
+	To   domain.Tree
+
+	Algorithm       string
+	AlgorithmParams map[string]string
+	DetectMicro     bool
+	SnapBoundaries  bool
+	TimeoutSeconds  int
+	OnTimeout       string
+
+	StartedAt time.Time
+}
+
+// RunSummary describes the result of the run, printed after all clone sets.
+type RunSummary struct {
+	Queries      int
+	ChangedFiles int
+	Duration     time.Duration
+	// Coverage is non-nil if the search was interrupted, and the clone sets are partial
+	Coverage *domain.Coverage
+}
+
+type ndjsonTree struct {
+	Name   string `json:"name"`
+	Commit string `json:"commit,omitempty"`
+}
+
+type ndjsonConfig struct {
+	Algorithm       string            `json:"algorithm"`
+	AlgorithmParams map[string]string `json:"algorithm_params"`
+	Micro           bool              `json:"micro"`
+	SnapBoundaries  bool              `json:"snap_boundaries"`
+	TimeoutSeconds  int               `json:"timeout_seconds"`
+	OnTimeout       string            `json:"on_timeout"`
+}
+
+type ndjsonHeader struct {
+	Type          string        `json:"type"`
+	SchemaVersion int           `json:"schema_version"`
+	Version       string        `json:"version"`
+	Command       string        `json:"command"`
+	From          *ndjsonTree   `json:"from,omitempty"`
+	To            *ndjsonTree   `json:"to,omitempty"`
+	Config        *ndjsonConfig `json:"config"`
+	StartedAt     time.Time     `json:"started_at"`
+}
+
+type ndjsonCloneSet struct {
+	Type string `json:"type"`
+	// ID is stable against line shifts, as long as the contents of the clones stay the same
+	ID string `json:"id,omitempty"`
+	*jsonCloneSet
+}
+
+type ndjsonSummary struct {
+	Type          string `json:"type"`
+	CloneSets     int    `json:"clone_sets"`
+	MissingClones int    `json:"missing_clones"`
+	Queries       int    `json:"queries"`
+	ChangedFiles  int    `json:"changed_files"`
+	DurationMS    int64  `json:"duration_ms"`
+	Incomplete    bool   `json:"incomplete"`
+	SearchedFiles *int   `json:"searched_files,omitempty"`
+	TotalFiles    *int   `json:"total_files,omitempty"`
+}
+
+func (n *NDJSONPrinter) tree(t domain.Tree) *ndjsonTree {
+	if t == nil {
+		return nil
+	}
+	hash, _ := domain.CommitHash(t)
+	return &ndjsonTree{Name: t.String(), Commit: hash}
+}
+
+func (n *NDJSONPrinter) encode(v any) []byte {
+	var buf bytes.Buffer
+	lo.Must0(json.NewEncoder(&buf).Encode(v))
+	return buf.Bytes()
+}
+
+func (n *NDJSONPrinter) PrintHeader(h *RunHeader) []byte {
+	return n.encode(&ndjsonHeader{
+		Type:          "header",
+		SchemaVersion: NDJSONSchemaVersion,
+		Version:       cli.GetFormattedVersion(),
+		Command:       h.Command,
+		From:          n.tree(h.From),
+		To:            n.tree(h.To),
+		Config: &ndjsonConfig{
+			Algorithm:       h.Algorithm,
+			AlgorithmParams: lo.Ternary(h.AlgorithmParams != nil, h.AlgorithmParams, map[string]string{}),
+			Micro:           h.DetectMicro,
+			SnapBoundaries:  h.SnapBoundaries,
+			TimeoutSeconds:  h.TimeoutSeconds,
+			OnTimeout:       h.OnTimeout,
+		},
+		StartedAt: h.StartedAt,
+	})
+}
+
+// PrintCloneSets prints a record for each clone set.
+// fingerprints are the stable fingerprints of missing clones of each set (see baseline.Fingerprinter),
+// used as IDs of missing clones and clone sets. fingerprints can be nil, in which case IDs are omitted.
+func (n *NDJSONPrinter) PrintCloneSets(sets []*domain.CloneSet, fingerprints [][]string) []byte {
+	var buf bytes.Buffer
+	for i, set := range sets {
+		obj := &ndjsonCloneSet{Type: "clone_set", jsonCloneSet: n.json.formatCloneSet(set)}
+		if fingerprints != nil {
+			n.setIDs(obj, fingerprints[i])
+		}
+		buf.Write(n.encode(obj))
+
+		n.cloneSets++
+		n.missingClones += len(set.Missing)
+	}
+	return buf.Bytes()
+}
+
+// setIDs sets IDs of the clone set and its missing clones.
+// Missing clones with the same contents are distinguished by their ordinals in the order of locations.
+func (n *NDJSONPrinter) setIDs(set *ndjsonCloneSet, fingerprints []string) {
+	order := lo.Range(len(set.Missing))
+	slices.SortStableFunc(order, ds.SortCompose(
+		ds.SortAsc(func(j int) string { return set.Missing[j].Filename }),
+		ds.SortAsc(func(j int) int { return set.Missing[j].StartL }),
+	))
+	seen := make(map[string]int)
+	for _, j := range order {
+		fp := fingerprints[j]
+		set.Missing[j].ID = lo.Ternary(seen[fp] == 0, fp, fmt.Sprintf("%s-%d", fp, seen[fp]))
+		seen[fp]++
+	}
+
+	ids := ds.Map(set.Missing, func(c *jsonClone) string { return c.ID })
+	slices.Sort(ids)
+	set.ID = fmt.Sprintf("%016x", xxhash.Sum64String(strings.Join(ids, "\x00")))
+}
+
+// PrintSummary prints the summary record, including the number of clone sets printed so far.
+func (n *NDJSONPrinter) PrintSummary(s *RunSummary) []byte {
+	summary := &ndjsonSummary{
+		Type:          "summary",
+		CloneSets:     n.cloneSets,
+		MissingClones: n.missingClones,
+		Queries:       s.Queries,
+		ChangedFiles:  s.ChangedFiles,
+		DurationMS:    s.Duration.Milliseconds(),
+		Incomplete:    s.Coverage != nil,
+	}
+	if s.Coverage != nil {
+		summary.SearchedFiles = &s.Coverage.SearchedFiles
+		summary.TotalFiles = &s.Coverage.TotalFiles
+	}
+	return n.encode(summary)
+}
+
+// PrintClones prints clone sets without IDs and the summary, for callers not printing records of the run one by one.
+func (n *NDJSONPrinter) PrintClones(sets []*domain.CloneSet, coverage *domain.Coverage) []byte {
+	return append(n.PrintCloneSets(sets, nil), n.PrintSummary(&RunSummary{Coverage: coverage})...)
+}
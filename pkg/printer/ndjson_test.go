@@ -0,0 +1,57 @@
+package printer
+
+import (
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+func TestNDJSONPrinter_setIDs(t *testing.T) {
+	n := NewNDJSONPrinter()
+	setIDs := func(missing []*domain.Clone, fingerprints []string) *ndjsonCloneSet {
+		set := &ndjsonCloneSet{jsonCloneSet: n.json.formatCloneSet(&domain.CloneSet{Missing: missing})}
+		n.setIDs(set, fingerprints)
+		return set
+	}
+
+	set := setIDs(
+		[]*domain.Clone{{Filename: "b.go", StartL: 10, EndL: 12}, {Filename: "a.go", StartL: 5, EndL: 7}},
+		[]string{"fp-b", "fp-a"},
+	)
+	if set.Missing[0].ID != "fp-b" || set.Missing[1].ID != "fp-a" {
+		t.Errorf("expected fingerprints as IDs of missing clones, got %v and %v", set.Missing[0].ID, set.Missing[1].ID)
+	}
+
+	// Stable against line shifts and order of clones
+	shifted := setIDs(
+		[]*domain.Clone{{Filename: "a.go", StartL: 8, EndL: 10}, {Filename: "b.go", StartL: 1, EndL: 3}},
+		[]string{"fp-a", "fp-b"},
+	)
+	if shifted.ID != set.ID {
+		t.Errorf("expected the same clone set ID after line shifts, got %v and %v", set.ID, shifted.ID)
+	}
+
+	// Changes with the contents of clones
+	changed := setIDs(
+		[]*domain.Clone{{Filename: "b.go", StartL: 10, EndL: 12}, {Filename: "a.go", StartL: 5, EndL: 7}},
+		[]string{"fp-b2", "fp-a"},
+	)
+	if changed.ID == set.ID {
+		t.Errorf("expected a different clone set ID after contents change")
+	}
+
+	// Clones with the same contents are distinguished by their ordinals in the order of locations
+	duplicates := setIDs(
+		[]*domain.Clone{
+			{Filename: "b.go", StartL: 1, EndL: 3},
+			{Filename: "a.go", StartL: 20, EndL: 22},
+			{Filename: "a.go", StartL: 5, EndL: 7},
+		},
+		[]string{"fp", "fp", "fp"},
+	)
+	for i, want := range []string{"fp-2", "fp-1", "fp"} {
+		if got := duplicates.Missing[i].ID; got != want {
+			t.Errorf("clone #%d: expected ID %v, got %v", i, want, got)
+		}
+	}
+}
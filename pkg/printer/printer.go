@@ -1,7 +1,30 @@
 package printer
 
-import "github.com/salab/iccheck/pkg/domain"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/history"
+)
 
 type Printer interface {
-	PrintClones(sets []*domain.CloneSet) []byte
+	// PrintClones prints clone sets.
+	// coverage is non-nil if the search was interrupted (such as by a timeout), and the clone sets are partial.
+	PrintClones(sets []*domain.CloneSet, coverage *domain.Coverage) []byte
+}
+
+// HistoryPrinter is implemented by printers which support printing results of the history command.
+type HistoryPrinter interface {
+	PrintHistory(reports []*history.CommitReport) []byte
+}
+
+func incompleteMessage(coverage *domain.Coverage) string {
+	return fmt.Sprintf("Search timed out, and results are incomplete: %v.", coverage)
+}
+
+func commitSubject(c *object.Commit) string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return subject
 }
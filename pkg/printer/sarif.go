@@ -0,0 +1,262 @@
+package printer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/cli"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/samber/lo"
+)
+
+// sarifPrinter prints output in SARIF 2.1.0 format, for use with code scanning dashboards.
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+type sarifPrinter struct{}
+
+func NewSarifPrinter() Printer {
+	return &sarifPrinter{}
+}
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	sarifToolURI = "https://github.com/salab/iccheck"
+	// sarifFingerprintKey is the key of partialFingerprints, versioned in case the calculation changes
+	sarifFingerprintKey = "iccheckCloneSet/v1"
+)
+
+type sarifRuleID string
+
+const (
+	sarifRuleMissingChange = sarifRuleID("missing-change")
+	sarifRuleDeletedCode   = sarifRuleID("remaining-deleted-code")
+	sarifRuleCodeClone     = sarifRuleID("code-clone")
+)
+
+var sarifRules = []*sarifRule{
+	{
+		ID:               sarifRuleMissingChange,
+		ShortDescription: sarifMessage{"Possibly missing change"},
+		FullDescription:  sarifMessage{"A clone of the changed code might be missing a consistent change."},
+		DefaultConfig:    sarifRuleConfig{Level: "warning"},
+	},
+	{
+		ID:               sarifRuleDeletedCode,
+		ShortDescription: sarifMessage{"Possibly remaining copy of deleted code"},
+		FullDescription:  sarifMessage{"A clone of the deleted code still remains, and might also have to be deleted."},
+		DefaultConfig:    sarifRuleConfig{Level: "warning"},
+	},
+	{
+		ID:               sarifRuleCodeClone,
+		ShortDescription: sarifMessage{"Code clone"},
+		FullDescription:  sarifMessage{"Similar code exists in other location(s)."},
+		DefaultConfig:    sarifRuleConfig{Level: "note"},
+	},
+}
+
+type sarifLog struct {
+	Schema  string      `json:"$schema"`
+	Version string      `json:"version"`
+	Runs    []*sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool          `json:"tool"`
+	Invocations []*sarifInvocation `json:"invocations,omitempty"`
+	Results     []*sarifResult     `json:"results"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful        bool                 `json:"executionSuccessful"`
+	ToolExecutionNotifications []*sarifNotification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type sarifNotification struct {
+	Level   string       `json:"level"`
+	Message sarifMessage `json:"message"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string       `json:"name"`
+	Version        string       `json:"version"`
+	InformationURI string       `json:"informationUri"`
+	Rules          []*sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               sarifRuleID     `json:"id"`
+	ShortDescription sarifMessage    `json:"shortDescription"`
+	FullDescription  sarifMessage    `json:"fullDescription"`
+	DefaultConfig    sarifRuleConfig `json:"defaultConfiguration"`
+}
+
+type sarifRuleConfig struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              sarifRuleID       `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []*sarifLocation  `json:"locations"`
+	RelatedLocations    []*sarifLocation  `json:"relatedLocations,omitempty"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type sarifLocation struct {
+	ID               *int                  `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+}
+
+func (s *sarifPrinter) location(c *domain.Clone) *sarifLocation {
+	return &sarifLocation{
+		PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{
+				// SARIF requires URI references, which are always "/"-delimited
+				URI:       filepath.ToSlash(c.Filename),
+				URIBaseID: "%SRCROOT%",
+			},
+			Region: sarifRegion{
+				StartLine: c.StartL,
+				EndLine:   c.EndL,
+			},
+		},
+	}
+}
+
+func (s *sarifPrinter) relatedLocations(clones []*domain.Clone, message string) []*sarifLocation {
+	return lo.Map(clones, func(c *domain.Clone, i int) *sarifLocation {
+		loc := s.location(c)
+		loc.ID = lo.ToPtr(i + 1)
+		loc.Message = &sarifMessage{message}
+		return loc
+	})
+}
+
+// fingerprint calculates a fingerprint of the result that is stable against line shifts.
+// Line numbers are not included - instead, the file names of the clone set
+// and the ordinal of the clone among the clones in the same file are used.
+func (s *sarifPrinter) fingerprint(rule sarifRuleID, c *domain.Clone, others []*domain.Clone, ordinal int) string {
+	otherFiles := lo.Uniq(ds.Map(others, func(c *domain.Clone) string { return filepath.ToSlash(c.Filename) }))
+	slices.Sort(otherFiles)
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%s",
+		rule, filepath.ToSlash(c.Filename), ordinal, strings.Join(otherFiles, "\x00"))))
+	return hex.EncodeToString(h[:16])
+}
+
+func (s *sarifPrinter) result(rule sarifRuleID, set *domain.CloneSet, c *domain.Clone, ordinal int) *sarifResult {
+	ruleIndex := slices.IndexFunc(sarifRules, func(r *sarifRule) bool { return r.ID == rule })
+	res := &sarifResult{
+		RuleID:    rule,
+		RuleIndex: ruleIndex,
+		Locations: []*sarifLocation{s.location(c)},
+	}
+	var others []*domain.Clone
+	switch rule {
+	case sarifRuleMissingChange:
+		others = set.Changed
+		res.Message.Text = fmt.Sprintf(
+			"Possibly missing a consistent change here (L%d - L%d) (%d / %d clone(s) in this clone set were changed)",
+			c.StartL, c.EndL, len(set.Changed), len(set.Changed)+len(set.Missing),
+		)
+		res.RelatedLocations = s.relatedLocations(set.Changed, "Changed clone")
+	case sarifRuleDeletedCode:
+		others = set.Changed
+		// Deleted code only exists in the base tree, so it is named in the message instead of
+		// related locations, which would be resolved against the analyzed checkout.
+		deleted := set.Changed[0]
+		res.Message.Text = fmt.Sprintf(
+			"Possibly a remaining copy of deleted code here (L%d - L%d) (deleted from %s L%d - L%d in the base tree)",
+			c.StartL, c.EndL, filepath.ToSlash(deleted.Filename), deleted.StartL, deleted.EndL,
+		)
+	case sarifRuleCodeClone:
+		others = lo.Without(set.Missing, c)
+		res.Message.Text = fmt.Sprintf(
+			"Code clone here (L%d - L%d) (%d clone(s) in this clone set)",
+			c.StartL, c.EndL, len(set.Missing),
+		)
+		res.RelatedLocations = s.relatedLocations(others, "Other clone")
+	}
+	res.PartialFingerprints = map[string]string{
+		sarifFingerprintKey: s.fingerprint(rule, c, others, ordinal),
+	}
+	return res
+}
+
+func (s *sarifPrinter) PrintClones(sets []*domain.CloneSet, coverage *domain.Coverage) []byte {
+	results := make([]*sarifResult, 0)
+	for _, set := range sets {
+		rule := sarifRuleMissingChange
+		if set.Deleted {
+			rule = sarifRuleDeletedCode
+		} else if set.Standalone {
+			rule = sarifRuleCodeClone
+		}
+
+		ordinals := make(map[string]int)
+		missing := slices.Clone(set.Missing)
+		slices.SortFunc(missing, ds.SortCompose(
+			ds.SortAsc(func(c *domain.Clone) string { return c.Filename }),
+			ds.SortAsc(func(c *domain.Clone) int { return c.StartL }),
+		))
+		for _, c := range missing {
+			results = append(results, s.result(rule, set, c, ordinals[c.Filename]))
+			ordinals[c.Filename]++
+		}
+	}
+
+	log := &sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []*sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "ICCheck",
+				Version:        cli.GetFormattedVersion(),
+				InformationURI: sarifToolURI,
+				Rules:          sarifRules,
+			}},
+			Results: results,
+		}},
+	}
+	if coverage != nil {
+		log.Runs[0].Invocations = []*sarifInvocation{{
+			ExecutionSuccessful: false,
+			ToolExecutionNotifications: []*sarifNotification{{
+				Level:   "warning",
+				Message: sarifMessage{incompleteMessage(coverage)},
+			}},
+		}}
+	}
+	out := lo.Must(json.MarshalIndent(log, "", "  "))
+	return append(out, '\n')
+}
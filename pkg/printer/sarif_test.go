@@ -0,0 +1,107 @@
+package printer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+func TestSarifPrinter_PrintClones(t *testing.T) {
+	s := NewSarifPrinter()
+	printClones := func(sets []*domain.CloneSet, coverage *domain.Coverage) *sarifLog {
+		var log sarifLog
+		if err := json.Unmarshal(s.PrintClones(sets, coverage), &log); err != nil {
+			t.Fatal(err)
+		}
+		if log.Schema != sarifSchema || log.Version != sarifVersion {
+			t.Errorf("unexpected schema %v and version %v", log.Schema, log.Version)
+		}
+		if len(log.Runs) != 1 {
+			t.Fatalf("expected 1 run, got %d", len(log.Runs))
+		}
+		return &log
+	}
+	sets := func(shift int) []*domain.CloneSet {
+		return []*domain.CloneSet{
+			{
+				Changed: []*domain.Clone{{Filename: "a.go", StartL: 5 + shift, EndL: 7 + shift}},
+				Missing: []*domain.Clone{
+					{Filename: "b.go", StartL: 10 + shift, EndL: 12 + shift},
+					{Filename: "a.go", StartL: 20 + shift, EndL: 22 + shift},
+				},
+			},
+			{
+				Changed: []*domain.Clone{{Filename: "old.go", StartL: 1, EndL: 3}},
+				Missing: []*domain.Clone{{Filename: "c.go", StartL: 30 + shift, EndL: 32 + shift}},
+				Deleted: true,
+			},
+		}
+	}
+
+	run := printClones(sets(0), nil).Runs[0]
+	if len(run.Invocations) != 0 {
+		t.Errorf("expected no invocations for a complete run, got %d", len(run.Invocations))
+	}
+	if len(run.Results) != 3 {
+		t.Fatalf("expected 1 result per missing clone, got %d", len(run.Results))
+	}
+	for i, want := range []struct {
+		rule    sarifRuleID
+		file    string
+		startL  int
+		related int
+	}{
+		{sarifRuleMissingChange, "a.go", 20, 1},
+		{sarifRuleMissingChange, "b.go", 10, 1},
+		{sarifRuleDeletedCode, "c.go", 30, 0},
+	} {
+		res := run.Results[i]
+		if res.RuleID != want.rule || run.Tool.Driver.Rules[res.RuleIndex].ID != want.rule {
+			t.Errorf("result #%d: expected rule %v, got %v (index %d)", i, want.rule, res.RuleID, res.RuleIndex)
+		}
+		if loc := res.Locations[0].PhysicalLocation; loc.ArtifactLocation.URI != want.file || loc.Region.StartLine != want.startL {
+			t.Errorf("result #%d: expected location %v:%d, got %+v", i, want.file, want.startL, loc)
+		}
+		if len(res.RelatedLocations) != want.related {
+			t.Errorf("result #%d: expected %d related location(s), got %d", i, want.related, len(res.RelatedLocations))
+		}
+	}
+
+	related := run.Results[0].RelatedLocations[0]
+	if related.ID == nil || *related.ID != 1 {
+		t.Errorf("expected related location id 1, got %v", related.ID)
+	}
+	if loc := related.PhysicalLocation; loc.ArtifactLocation.URI != "a.go" || loc.Region.StartLine != 5 || loc.Region.EndLine != 7 {
+		t.Errorf("expected related location of the changed clone, got %+v", loc)
+	}
+
+	// Stable against line shifts
+	shifted := printClones(sets(3), nil).Runs[0]
+	for i := range run.Results {
+		fp := run.Results[i].PartialFingerprints[sarifFingerprintKey]
+		if fp == "" {
+			t.Errorf("result #%d: expected a fingerprint", i)
+		}
+		if got := shifted.Results[i].PartialFingerprints[sarifFingerprintKey]; got != fp {
+			t.Errorf("result #%d: expected the same fingerprint after line shifts, got %v and %v", i, fp, got)
+		}
+	}
+	if run.Results[0].PartialFingerprints[sarifFingerprintKey] == run.Results[1].PartialFingerprints[sarifFingerprintKey] {
+		t.Errorf("expected different fingerprints for different missing clones")
+	}
+
+	// Standalone clone sets refer to the other clones
+	standalone := printClones([]*domain.CloneSet{{
+		Missing:    []*domain.Clone{{Filename: "a.go", StartL: 1, EndL: 3}, {Filename: "b.go", StartL: 1, EndL: 3}},
+		Standalone: true,
+	}}, &domain.Coverage{SearchedFiles: 1, TotalFiles: 2}).Runs[0]
+	for i, res := range standalone.Results {
+		if res.RuleID != sarifRuleCodeClone || len(res.RelatedLocations) != 1 {
+			t.Errorf("result #%d: expected rule %v with 1 related location, got %v with %d", i, sarifRuleCodeClone, res.RuleID, len(res.RelatedLocations))
+		}
+	}
+	if len(standalone.Invocations) != 1 || standalone.Invocations[0].ExecutionSuccessful {
+		t.Errorf("expected an unsuccessful invocation for an incomplete run, got %+v", standalone.Invocations)
+	}
+}
@@ -0,0 +1,324 @@
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/files"
+	"github.com/samber/lo"
+)
+
+// SnippetOptions configures code snippets printed by the console printer.
+type SnippetOptions struct {
+	// From is the base tree to read deleted code from. Can be nil, in which case deleted code is not printed.
+	From domain.Tree
+	// To is the target tree to read clones from.
+	To domain.Tree
+	// Changes returns the change of target lines from startL to endL of a changed file (such as by PatchChanges),
+	// or nil if the file was not changed.
+	// Can be nil, in which case the current code of changed clones is printed.
+	Changes func(filename string, startL, endL int) ([]*DiffLine, error)
+	// Context is the number of lines to print before and after each clone.
+	Context int
+	// Color enables ANSI color escape sequences.
+	Color bool
+	// Width is the total width of side-by-side snippets.
+	Width int
+}
+
+// DiffLine is a line of the change of a changed clone.
+type DiffLine struct {
+	// Kind is ' ' for unchanged lines, '-' for deleted lines, and '+' for inserted lines.
+	Kind byte
+	// TargetL is the target line number (1-indexed), 0 if the line was deleted.
+	TargetL int
+	Line    string
+}
+
+// PatchChanges returns SnippetOptions.Changes reading the changes from file patches between the base and target tree.
+// Lines are read from the target tree, so that placeholder chunks from domain.ParseUnifiedDiff can be used.
+func PatchChanges(filePatches []fdiff.FilePatch, to domain.Tree) func(filename string, startL, endL int) ([]*DiffLine, error) {
+	type fileChange struct {
+		// deleted lines, keyed by the target line number right after them
+		deleted  map[int][]string
+		inserted map[int]struct{}
+	}
+	changes := make(map[string]*fileChange)
+	for _, fp := range filePatches {
+		_, toFile := fp.Files()
+		if toFile == nil || fp.IsBinary() {
+			continue
+		}
+		change := &fileChange{deleted: make(map[int][]string), inserted: make(map[int]struct{})}
+		targetL := 1
+		for _, c := range fp.Chunks() {
+			var lines []string
+			if content := c.Content(); content != "" {
+				lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+			}
+			switch c.Type() {
+			case fdiff.Equal:
+				targetL += len(lines)
+			case fdiff.Add:
+				for range lines {
+					change.inserted[targetL] = struct{}{}
+					targetL++
+				}
+			case fdiff.Delete:
+				change.deleted[targetL] = append(change.deleted[targetL], lines...)
+			}
+		}
+		// Windows support: clone file names are OS-specific paths
+		changes[filepath.Clean(toFile.Path())] = change
+	}
+
+	return func(filename string, startL, endL int) ([]*DiffLine, error) {
+		change, ok := changes[filename]
+		if !ok {
+			return nil, nil
+		}
+		content, err := files.ReadAll(to.Reader(filename))
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+
+		startL, endL = max(1, startL), min(len(lines), endL)
+		var diff []*DiffLine
+		for l := startL; l <= endL+1; l++ {
+			for _, line := range change.deleted[l] {
+				diff = append(diff, &DiffLine{Kind: '-', Line: line})
+			}
+			if l > endL {
+				break
+			}
+			_, ok := change.inserted[l]
+			diff = append(diff, &DiffLine{Kind: lo.Ternary[byte](ok, '+', ' '), TargetL: l, Line: lines[l-1]})
+		}
+		return diff, nil
+	}
+}
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+
+	snippetIndent    = "    "
+	snippetSeparator = " | "
+	snippetTabWidth  = 4
+	// minSnippetColumn is the minimum width of a column, if the given width is too narrow
+	minSnippetColumn = 30
+)
+
+// snippetLine is a line of code to print.
+type snippetLine struct {
+	// kind is ' ' for unchanged lines, '-' for deleted lines, and '+' for inserted lines
+	kind byte
+	// num is the line number, 0 if the line does not exist in the tree
+	num  int
+	text string
+	// context is true if the line is outside the clone
+	context bool
+}
+
+type fileKey struct {
+	tree domain.Tree
+	name string
+}
+
+type snippetPrinter struct {
+	opts  *SnippetOptions
+	files map[fileKey][]string
+}
+
+func newSnippetPrinter(opts *SnippetOptions) *snippetPrinter {
+	return &snippetPrinter{
+		opts:  opts,
+		files: make(map[fileKey][]string),
+	}
+}
+
+func (p *snippetPrinter) fileLines(tree domain.Tree, filename string) ([]string, error) {
+	key := fileKey{tree, filename}
+	if lines, ok := p.files[key]; ok {
+		return lines, nil
+	}
+	content, err := files.ReadAll(tree.Reader(filename))
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	p.files[key] = lines
+	return lines, nil
+}
+
+// code returns lines of the clone with surrounding context lines.
+// Lines of the clone are of the given kind.
+func (p *snippetPrinter) code(tree domain.Tree, c *domain.Clone, kind byte) ([]*snippetLine, error) {
+	lines, err := p.fileLines(tree, c.Filename)
+	if err != nil {
+		return nil, err
+	}
+	startL, endL := max(1, c.StartL-p.opts.Context), min(len(lines), c.EndL+p.opts.Context)
+	var ret []*snippetLine
+	for l := startL; l <= endL; l++ {
+		context := l < c.StartL || c.EndL < l
+		ret = append(ret, &snippetLine{
+			kind:    lo.Ternary[byte](context, ' ', kind),
+			num:     l,
+			text:    lines[l-1],
+			context: context,
+		})
+	}
+	return ret, nil
+}
+
+// change returns the change of the changed clone with surrounding context lines,
+// or its current code if the change is not available.
+func (p *snippetPrinter) change(c *domain.Clone) ([]*snippetLine, error) {
+	if p.opts.Changes == nil {
+		return p.code(p.opts.To, c, ' ')
+	}
+	diff, err := p.opts.Changes(c.Filename, c.StartL-p.opts.Context, c.EndL+p.opts.Context)
+	if err != nil {
+		return nil, err
+	}
+	if diff == nil {
+		return p.code(p.opts.To, c, ' ')
+	}
+	return ds.Map(diff, func(l *DiffLine) *snippetLine {
+		return &snippetLine{
+			kind:    l.Kind,
+			num:     l.TargetL,
+			text:    l.Line,
+			context: l.Kind == ' ' && (l.TargetL < c.StartL || c.EndL < l.TargetL),
+		}
+	}), nil
+}
+
+// printSideBySide prints the change (or deleted code) of the first changed clone,
+// side by side with the current code of each missing clone.
+func (p *snippetPrinter) printSideBySide(buf *bytes.Buffer, set *domain.CloneSet) {
+	if len(set.Changed) == 0 {
+		return
+	}
+	template := set.Changed[0]
+	var (
+		left      []*snippetLine
+		leftTitle string
+		err       error
+	)
+	if set.Deleted {
+		if p.opts.From == nil {
+			return
+		}
+		left, err = p.code(p.opts.From, template, '-')
+		leftTitle = fmt.Sprintf("%s:%d (deleted)", template.Filename, template.StartL)
+	} else {
+		left, err = p.change(template)
+		leftTitle = fmt.Sprintf("%s:%d (changed)", template.Filename, template.StartL)
+	}
+	if err != nil {
+		p.printError(buf, err)
+		return
+	}
+
+	width := max(minSnippetColumn, (p.opts.Width-len(snippetIndent)-len(snippetSeparator))/2)
+	for _, c := range set.Missing {
+		right, err := p.code(p.opts.To, c, ' ')
+		if err != nil {
+			p.printError(buf, err)
+			continue
+		}
+		rightTitle := fmt.Sprintf("%s:%d (%s)", c.Filename, c.StartL, lo.Ternary(set.Deleted, "remaining", "missing"))
+
+		buf.WriteString("\n")
+		buf.WriteString(snippetIndent +
+			p.color(ansiBold, pad(leftTitle, width)) + snippetSeparator +
+			p.color(ansiBold, truncate(rightTitle, width)) + "\n")
+		for i := 0; i < max(len(left), len(right)); i++ {
+			row := snippetIndent + p.formatLine(left, i, width, true) + snippetSeparator + p.formatLine(right, i, width, false)
+			buf.WriteString(strings.TrimRight(row, " ") + "\n")
+		}
+	}
+}
+
+// printStandalone prints the code of each clone in the standalone clone set.
+func (p *snippetPrinter) printStandalone(buf *bytes.Buffer, set *domain.CloneSet) {
+	width := max(minSnippetColumn, p.opts.Width-len(snippetIndent))
+	for _, c := range set.Missing {
+		lines, err := p.code(p.opts.To, c, ' ')
+		if err != nil {
+			p.printError(buf, err)
+			continue
+		}
+		buf.WriteString("\n")
+		buf.WriteString(snippetIndent + p.color(ansiBold, truncate(fmt.Sprintf("%s:%d", c.Filename, c.StartL), width)) + "\n")
+		for i := range lines {
+			buf.WriteString(snippetIndent + p.formatLine(lines, i, width, false) + "\n")
+		}
+	}
+}
+
+func (p *snippetPrinter) printError(buf *bytes.Buffer, err error) {
+	buf.WriteString(snippetIndent + p.color(ansiYellow, fmt.Sprintf("(failed to read code: %v)", err)) + "\n")
+}
+
+// formatLine formats the i-th line to fit in the given width.
+// Empty column is returned if lines has no i-th line.
+func (p *snippetPrinter) formatLine(lines []*snippetLine, i int, width int, padRight bool) string {
+	if i >= len(lines) {
+		return lo.Ternary(padRight, strings.Repeat(" ", width), "")
+	}
+	l := lines[i]
+	num := lo.Ternary(l.num > 0, fmt.Sprintf("%5d", l.num), strings.Repeat(" ", 5))
+	text := truncate(fmt.Sprintf("%s %c %s", num, l.kind, expandTabs(l.text)), width)
+	if padRight {
+		text = pad(text, width)
+	}
+	switch {
+	case l.kind == '-':
+		return p.color(ansiRed, text)
+	case l.kind == '+':
+		return p.color(ansiGreen, text)
+	case l.context:
+		return p.color(ansiDim, text)
+	default:
+		return text
+	}
+}
+
+func (p *snippetPrinter) color(code, s string) string {
+	if !p.opts.Color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", strings.Repeat(" ", snippetTabWidth))
+}
+
+// truncate truncates s to at most width runes.
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+// pad pads s with spaces to width runes, truncating if necessary.
+func pad(s string, width int) string {
+	s = truncate(s, width)
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
@@ -0,0 +1,221 @@
+package printer
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// assertGolden compares got with testdata/<name>.golden, or updates the file with -update.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %v (run with -update to update):\n%s\nexpected:\n%s", path, got, want)
+	}
+}
+
+// snippetTestFile has two identical functions a (L3-L9) and b (L11-L17).
+const snippetTestFile = `package a
+
+func a() {
+	x := get()
+	if x == nil {
+		return
+	}
+	use(x)
+}
+
+func b() {
+	x := get()
+	if x == nil {
+		return
+	}
+	use(x)
+}
+`
+
+func snippetTestTree(t *testing.T, content string) domain.Tree {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestSnippetPrinter(t *testing.T) {
+	// The nil check of a() was changed, and the one of b() was not
+	changed := bytes.Replace([]byte(snippetTestFile), []byte("if x == nil {"), []byte("if x == nil || x.Empty() {"), 1)
+	to := snippetTestTree(t, string(changed))
+	from := snippetTestTree(t, snippetTestFile)
+	changes := func(filename string, startL, endL int) ([]*DiffLine, error) {
+		return []*DiffLine{
+			{Kind: ' ', TargetL: 4, Line: "\tx := get()"},
+			{Kind: '-', Line: "\tif x == nil {"},
+			{Kind: '+', TargetL: 5, Line: "\tif x == nil || x.Empty() {"},
+			{Kind: ' ', TargetL: 6, Line: "\t\treturn"},
+		}, nil
+	}
+
+	changedSet := &domain.CloneSet{
+		Changed: []*domain.Clone{{Filename: "a.go", StartL: 5, EndL: 5}},
+		Missing: []*domain.Clone{{Filename: "a.go", StartL: 13, EndL: 13}},
+	}
+	deletedSet := &domain.CloneSet{
+		Changed: []*domain.Clone{{Filename: "a.go", StartL: 5, EndL: 7}},
+		Missing: []*domain.Clone{{Filename: "a.go", StartL: 13, EndL: 15}},
+		Deleted: true,
+	}
+	standaloneSet := &domain.CloneSet{
+		Missing:    []*domain.Clone{{Filename: "a.go", StartL: 4, EndL: 8}, {Filename: "a.go", StartL: 12, EndL: 16}},
+		Standalone: true,
+	}
+
+	tests := []struct {
+		name  string
+		set   *domain.CloneSet
+		opts  SnippetOptions
+		print func(p *snippetPrinter, buf *bytes.Buffer, set *domain.CloneSet)
+	}{
+		{"side_by_side", changedSet, SnippetOptions{Context: 1, Width: 80, Changes: changes}, (*snippetPrinter).printSideBySide},
+		{"side_by_side_color", changedSet, SnippetOptions{Context: 1, Width: 80, Changes: changes, Color: true}, (*snippetPrinter).printSideBySide},
+		// Without the change, the current code of the changed clone is printed
+		{"side_by_side_no_changes", changedSet, SnippetOptions{Context: 1, Width: 80}, (*snippetPrinter).printSideBySide},
+		// Lines longer than the column are truncated
+		{"side_by_side_narrow", changedSet, SnippetOptions{Context: 1, Width: 40, Changes: changes}, (*snippetPrinter).printSideBySide},
+		{"deleted", deletedSet, SnippetOptions{Width: 80, From: from}, (*snippetPrinter).printSideBySide},
+		{"deleted_color", deletedSet, SnippetOptions{Width: 80, From: from, Color: true}, (*snippetPrinter).printSideBySide},
+		{"standalone", standaloneSet, SnippetOptions{Width: 80}, (*snippetPrinter).printStandalone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.To = to
+			var buf bytes.Buffer
+			tt.print(newSnippetPrinter(&opts), &buf, tt.set)
+			assertGolden(t, tt.name, buf.Bytes())
+		})
+	}
+}
+
+func TestPatchChanges(t *testing.T) {
+	const patch = `--- a/a.go
++++ b/a.go
+@@ -1,4 +1,4 @@
+ a
+-b
++b2
+ c
+-c2
+ d
+`
+	filePatches, err := domain.ParseUnifiedDiff(strings.NewReader(patch), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := PatchChanges(filePatches, snippetTestTree(t, "a\nb2\nc\nd\n"))
+
+	diff, err := changes("a.go", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range diff {
+		got = append(got, fmt.Sprintf("%c%d %s", l.Kind, l.TargetL, l.Line))
+	}
+	expected := []string{"-0 b", "+2 b2", " 3 c", "-0 c2"}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected change:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	if diff, err = changes("b.go", 1, 1); err != nil || diff != nil {
+		t.Errorf("expected no change for unchanged file, got %v, %v", diff, err)
+	}
+}
+
+func TestSnippetPrinter_formatLine(t *testing.T) {
+	lines := []*snippetLine{
+		{kind: ' ', num: 3, text: "\tfoo()", context: true},
+		{kind: '-', text: "bar()"},
+		{kind: '+', num: 4, text: "baz()"},
+		{kind: ' ', num: 5, text: "qux()"},
+	}
+	tests := []struct {
+		name     string
+		i        int
+		width    int
+		padRight bool
+		color    bool
+		want     string
+	}{
+		{"context line with tab", 0, 20, false, false, "    3       foo()"},
+		{"padded", 3, 20, true, false, "    5   qux()       "},
+		{"truncated", 3, 10, false, false, "    5   q…"},
+		{"deleted line without number", 1, 20, false, false, "      - bar()"},
+		{"past the end", 4, 5, true, false, "     "},
+		{"past the end without padding", 4, 5, false, false, ""},
+		{"context color", 0, 20, false, true, ansiDim + "    3       foo()" + ansiReset},
+		{"deleted color", 1, 20, false, true, ansiRed + "      - bar()" + ansiReset},
+		{"inserted color", 2, 20, false, true, ansiGreen + "    4 + baz()" + ansiReset},
+		{"unchanged color", 3, 20, false, true, "    5   qux()"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newSnippetPrinter(&SnippetOptions{Color: tt.color})
+			if got := p.formatLine(lines, tt.i, tt.width, tt.padRight); got != tt.want {
+				t.Errorf("formatLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateAndPad(t *testing.T) {
+	tests := []struct {
+		s         string
+		width     int
+		truncated string
+		padded    string
+	}{
+		{"abc", 5, "abc", "abc  "},
+		{"abcde", 5, "abcde", "abcde"},
+		{"abcdef", 5, "abcd…", "abcd…"},
+		{"", 3, "", "   "},
+		// Widths are counted in runes, not bytes
+		{"日本語テキスト", 5, "日本語テ…", "日本語テ…"},
+		{"日本", 4, "日本", "日本  "},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.width); got != tt.truncated {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.truncated)
+		}
+		if got := pad(tt.s, tt.width); got != tt.padded {
+			t.Errorf("pad(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.padded)
+		}
+	}
+}
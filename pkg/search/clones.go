@@ -0,0 +1,199 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/pkg/errors"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/files"
+	"github.com/samber/lo"
+)
+
+// DetectClones enumerates clone sets in the whole tree, without requiring any changes.
+//
+// Every file is cut into windows of minLines lines, and each window is searched against the whole tree.
+// Windows start every windowStride(minLines) lines instead of every line, since the number of windows
+// multiplies the cost of searching the whole tree. Clones of at least minLines+stride-1 lines always contain
+// a whole window, and smaller clones are found as long as a window overlapping them is similar enough.
+// Clones smaller than minLines lines, or with similarity less than minSimilarity (0 to 1) are excluded.
+//
+// Returned clone sets have Standalone flag set, and all clones are stored in Missing.
+func DetectClones(
+	ctx context.Context,
+	algorithmName string,
+	tree domain.Tree,
+	minLines int,
+	minSimilarity float64,
+	c *Config,
+) ([]*domain.CloneSet, error) {
+	if minLines <= 0 {
+		return nil, errors.Errorf("minimum clone size must be a positive integer, got %d", minLines)
+	}
+
+	algorithm, err := GetAlgorithm(algorithmName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Context lines help finding co-change candidates around changes, but are only noise in whole-tree detection
+	c = withoutContextLines(algorithm, c)
+
+	searcher := domain.NewSearcherFromTree(tree)
+	queries, err := treeQueries(searcher, minLines, windowStride(minLines), c.Matcher)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info(fmt.Sprintf("Searching for clones of %d window(s) of %d line(s)...", len(queries), minLines))
+
+	clones, err := runAlgorithm(ctx, algorithmName, searcher, queries, searcher, c)
+	incomplete, isIncomplete := domain.AsIncomplete(err)
+	if err != nil && !isIncomplete {
+		return nil, err
+	}
+
+	// Every query trivially matches itself - only keep matches at other locations,
+	// and record the query locations that had such matches as clones, too.
+	clones = lo.Filter(clones, func(cl *domain.Clone, _ int) bool {
+		if 1-cl.Distance < minSimilarity {
+			return false
+		}
+		return !lo.SomeBy(cl.Sources, func(s *domain.Source) bool {
+			return cl.Filename == s.Filename && cl.StartL <= s.EndL && s.StartL <= cl.EndL
+		})
+	})
+	matchedSources := lo.UniqBy(
+		ds.FlatMap(clones, func(cl *domain.Clone) []*domain.Source { return cl.Sources }),
+		func(s *domain.Source) string { return s.Key() },
+	)
+	for _, s := range matchedSources {
+		clones = append(clones, &domain.Clone{
+			Filename: s.Filename,
+			StartL:   s.StartL,
+			EndL:     s.EndL,
+			Sources:  []*domain.Source{s},
+		})
+	}
+
+	// Deduplicate overlapping clones, and calculate clone sets
+	clones = dedupeDetectedClones(clones)
+	rawCloneSets := findCloneSets(clones, matchedSources)
+
+	cloneSets := ds.Map(rawCloneSets, func(set []*domain.Clone) *domain.CloneSet {
+		set = lo.Filter(set, func(cl *domain.Clone, _ int) bool { return cl.EndL-cl.StartL+1 >= minLines })
+		slices.SortFunc(set, ds.SortCompose(
+			ds.SortAsc(func(c *domain.Clone) string { return c.Filename }),
+			ds.SortAsc(func(c *domain.Clone) int { return c.StartL }),
+		))
+		return &domain.CloneSet{Missing: set, Standalone: true}
+	})
+	cloneSets = lo.Filter(cloneSets, func(cs *domain.CloneSet, _ int) bool { return len(cs.Missing) > 1 })
+
+	// Sort from the largest clone sets, which are likely better refactoring targets
+	slices.SortStableFunc(cloneSets, ds.SortDesc(func(cs *domain.CloneSet) int {
+		return lo.SumBy(cs.Missing, func(cl *domain.Clone) int { return cl.EndL - cl.StartL + 1 })
+	}))
+
+	if isIncomplete {
+		return cloneSets, incomplete
+	}
+	return cloneSets, nil
+}
+
+// windowStride returns the number of lines between starts of windows of the given lines.
+// Windows overlap by half, so that every clone of 1.5 times the window size contains a whole window.
+func windowStride(window int) int {
+	return max(1, window/2)
+}
+
+// treeQueries cuts all non-binary and non-ignored files in the tree into windows of the given lines, starting every stride lines.
+// The last window of each file is aligned to the end of file, so that the end of file is covered too.
+// Windows consisting only of blank lines, or containing lines suppressed by inline suppression comments are excluded.
+func treeQueries(searcher domain.Searcher, window int, stride int, matcher *domain.MatcherRules) ([]*domain.Source, error) {
+	filenames, err := searcher.Files()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing files")
+	}
+
+	var queries []*domain.Source
+	for _, filename := range filenames {
+		file, err := searcher.Open(filename)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening %v", filename)
+		}
+		isBinary, err := file.IsBinary()
+		if err != nil {
+			return nil, errors.Wrapf(err, "calculating binary status of %v", filename)
+		}
+		if isBinary {
+			continue
+		}
+		content, err := file.Content()
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %v", filename)
+		}
+		if skipEntireFile, _ := matcher.Match(filename, content); skipEntireFile {
+			continue
+		}
+
+		indices := files.LineStartIndices(content)
+		lines := len(indices)
+		suppressed := domain.SuppressedLines(filename, content)
+		for startL := 1; startL+window-1 <= lines; startL = nextWindowStart(startL, window, stride, lines) {
+			endL := startL + window - 1
+			if lo.SomeBy(lo.RangeFrom(startL-1, window), func(l int) bool { _, ok := suppressed[l]; return ok }) {
+				continue
+			}
+			end := len(content)
+			if endL < lines {
+				end = indices[endL]
+			}
+			if len(bytes.TrimSpace(content[indices[startL-1]:end])) == 0 {
+				continue
+			}
+			queries = append(queries, &domain.Source{Filename: filename, StartL: startL, EndL: endL})
+		}
+	}
+	return queries, nil
+}
+
+// nextWindowStart returns the start of the window after the one starting at startL,
+// or a line past the end if no more windows fit in the file.
+func nextWindowStart(startL, window, stride, lines int) int {
+	lastStartL := lines - window + 1
+	if startL < lastStartL {
+		return min(startL+stride, lastStartL)
+	}
+	return lines + 1
+}
+
+// withoutContextLines sets context-lines parameter of the algorithm (and of nested algorithms, see Algorithm.NestedParams) to 0,
+// unless given explicitly.
+func withoutContextLines(algorithm *Algorithm, c *Config) *Config {
+	params := maps.Clone(c.AlgoParams)
+	if params == nil {
+		params = make(map[string]string)
+	}
+	set := func(a *Algorithm, name string) {
+		if _, ok := a.Param("context-lines"); !ok {
+			return
+		}
+		if _, ok := params[name]; !ok {
+			params[name] = "0"
+		}
+	}
+	set(algorithm, "context-lines")
+	if algorithm.NestedParams {
+		for _, other := range Algorithms() {
+			set(other, other.Name+".context-lines")
+		}
+	}
+	cc := *c
+	cc.AlgoParams = params
+	return &cc
+}
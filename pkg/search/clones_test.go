@@ -0,0 +1,135 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+// clonesTestFunc is a function of 9 lines, copied to clonesTestTree.
+const clonesTestFunc = `func sum%[1]s(values []int) int {
+	total := 0
+	for _, v := range values {
+		if v > 0 {
+			total += v
+		}
+	}
+	return total
+}
+`
+
+// clonesTestFiles returns files with 3 copies of clonesTestFunc.
+func clonesTestFiles() map[string]string {
+	return map[string]string{
+		// Copies at L3-L11 and L18-L26, with unrelated code in between
+		"a.go": "package a\n\n" + fmt.Sprintf(clonesTestFunc, "A") +
+			"\nfunc hello() {\n\tprintln(\"hello, world\")\n\tos.Exit(1)\n}\n\n" +
+			fmt.Sprintf(clonesTestFunc, "B"),
+		// Copy at L5-L13
+		"b/b.go": "package b\n\nimport \"os\"\n\n" + fmt.Sprintf(clonesTestFunc, "C"),
+		"c.go":   "package c\n\nvar x = map[string]int{\"a\": 1, \"b\": 2}\n",
+	}
+}
+
+func clonesTestTree(t *testing.T) domain.Tree {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range clonesTestFiles() {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestDetectClones(t *testing.T) {
+	tree := clonesTestTree(t)
+	copies := []*domain.Source{
+		{Filename: "a.go", StartL: 3, EndL: 11},
+		{Filename: "a.go", StartL: 18, EndL: 26},
+		{Filename: "b/b.go", StartL: 5, EndL: 13},
+	}
+	for _, algorithm := range []string{"fleccs", "token"} {
+		t.Run(algorithm, func(t *testing.T) {
+			sets, err := DetectClones(context.Background(), algorithm, tree, 5, 0, &Config{Matcher: domain.DefaultMatcherRules()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sets) != 1 {
+				t.Fatalf("expected 1 clone set, got %d: %v", len(sets), sets)
+			}
+			set := sets[0]
+			if !set.Standalone || len(set.Changed) > 0 {
+				t.Errorf("expected standalone clone set with all clones in missing, got %v", set)
+			}
+			if len(set.Missing) != len(copies) {
+				t.Fatalf("expected %d clones, got %v", len(copies), set.Missing)
+			}
+			for i, cl := range set.Missing {
+				// Clone boundaries are up to the windows matched, so allow a window of slack
+				want := copies[i]
+				if cl.Filename != want.Filename || cl.StartL > want.StartL || cl.EndL < want.EndL ||
+					want.StartL-cl.StartL >= 5 || cl.EndL-want.EndL >= 5 {
+					t.Errorf("clone #%d: expected around %v:L%d-L%d, got %v:L%d-L%d",
+						i, want.Filename, want.StartL, want.EndL, cl.Filename, cl.StartL, cl.EndL)
+				}
+			}
+		})
+	}
+
+	if _, err := DetectClones(context.Background(), "fleccs", tree, 0, 0, &Config{Matcher: domain.DefaultMatcherRules()}); err == nil {
+		t.Errorf("expected error for non-positive minimum clone size")
+	}
+}
+
+func TestTreeQueries(t *testing.T) {
+	// Without the trailing newline, which would count as another line
+	lines := func(n int) string { return strings.Repeat("x\n", n-1) + "x" }
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt":     lines(10),
+		"b.txt":     lines(11),
+		"short.txt": lines(3),
+		"blank.txt": strings.Repeat("\n", 9),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries, err := treeQueries(domain.NewSearcherFromTree(tree), 4, 2, domain.DefaultMatcherRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]int)
+	for _, q := range queries {
+		if q.EndL-q.StartL+1 != 4 {
+			t.Errorf("expected windows of 4 lines, got %v", q)
+		}
+		got[q.Filename] = append(got[q.Filename], q.StartL)
+	}
+	want := map[string][]int{
+		"a.txt": {1, 3, 5, 7},
+		// The last window is aligned to the end of file
+		"b.txt": {1, 3, 5, 7, 8},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected window starts %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,122 @@
+package search
+
+import (
+	"context"
+
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/samber/lo"
+	"github.com/theodesp/unionfind"
+)
+
+// SearchDeleted searches for copies of code deleted from the base tree, which still remain in the target tree.
+// When someone deletes a piece of code (e.g. a buggy function), its copies might also have to be deleted.
+// Code rewritten by modifications is searched too, since its copies might also have to be rewritten.
+//
+// filePatches are the changes from the base tree to the target tree, such as by domain.DiffTrees.
+// Returned clone sets have Deleted flag set, and Changed clones refer to the deleted regions in the base tree.
+func SearchDeleted(
+	ctx context.Context,
+	algorithmName string,
+	filePatches []diff.FilePatch,
+	fromTree, toTree domain.Tree,
+	c *Config,
+) ([]*domain.CloneSet, error) {
+	chunks := filePatchesToChunks(filePatches)
+	queries := lo.FilterMap(chunks, func(c *chunk, _ int) (*domain.Source, bool) { return c.deletedSource() })
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	// Search for clones of deleted code in the target tree
+	fromSearcher := domain.NewSearcherFromTree(fromTree)
+	queries, err := excludeSuppressedLines(fromSearcher, queries)
+	if err != nil {
+		return nil, err
+	}
+	clones, err := runAlgorithm(
+		ctx,
+		algorithmName,
+		fromSearcher,
+		queries,
+		domain.NewSearcherFromTree(toTree),
+		c,
+	)
+	incomplete, isIncomplete := domain.AsIncomplete(err)
+	if err != nil && !isIncomplete {
+		return nil, err
+	}
+	clones = dedupeDetectedClones(clones)
+
+	// Exclude clones which are not really "remaining" copies:
+	//  - the location where the code was deleted, since its surrounding lines are still similar
+	//    (such matches span the lines before and after the deletion, which are now adjacent)
+	//  - the locations changed by this diff, since the code might have been moved there
+	excludes := ds.FlatMap(targetChunks(chunks), func(c *chunk) []*domain.Source {
+		if c.kind != changeKindDeletion {
+			return c.searchQuery()
+		}
+		return []*domain.Source{{
+			Filename: c.afterFilename,
+			StartL:   c.afterStartL,
+			EndL:     c.afterStartL + 1,
+		}}
+	})
+	excludesPerFile := lo.GroupBy(excludes, func(s *domain.Source) string { return s.Filename })
+	clones = lo.Reject(clones, func(cl *domain.Clone, _ int) bool {
+		return lo.SomeBy(excludesPerFile[cl.Filename], func(s *domain.Source) bool {
+			return cl.StartL <= s.EndL && s.StartL <= cl.EndL
+		})
+	})
+
+	// Group deleted regions and their remaining clones into clone sets
+	queryKeyToIdx := make(map[string]int, len(queries))
+	for i, q := range queries {
+		queryKeyToIdx[q.Key()] = i
+	}
+	uf := unionfind.New(len(queries) + len(clones))
+	for i, cl := range clones {
+		for _, src := range cl.Sources {
+			if j, ok := queryKeyToIdx[src.Key()]; ok {
+				uf.Union(j, len(queries)+i)
+			}
+		}
+	}
+	setByRootID := make(map[int]*domain.CloneSet)
+	getSet := func(idx int) *domain.CloneSet {
+		root := uf.Root(idx)
+		if _, ok := setByRootID[root]; !ok {
+			setByRootID[root] = &domain.CloneSet{Deleted: true}
+		}
+		return setByRootID[root]
+	}
+	for i, q := range queries {
+		set := getSet(i)
+		set.Changed = append(set.Changed, &domain.Clone{
+			Filename: q.Filename,
+			StartL:   q.StartL,
+			EndL:     q.EndL,
+		})
+	}
+	for i, cl := range clones {
+		set := getSet(len(queries) + i)
+		set.Missing = append(set.Missing, cl)
+	}
+
+	// Deleted code without any remaining copies is not interesting
+	cloneSets := lo.Filter(lo.Values(setByRootID), func(cs *domain.CloneSet, _ int) bool {
+		return len(cs.Changed) > 0 && len(cs.Missing) > 0
+	})
+
+	// Sort
+	domain.SortCloneSets(cloneSets)
+	for _, set := range cloneSets {
+		set.Sort()
+	}
+
+	if isIncomplete {
+		return cloneSets, incomplete
+	}
+	return cloneSets, nil
+}
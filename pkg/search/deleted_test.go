@@ -0,0 +1,98 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+func TestSearchDeleted(t *testing.T) {
+	from := searchTestTree(t, searchTestFiles())
+	// The loop of sumA (L5-L9 in the base tree), whose copies remain in sumB and sumC
+	const loop = "\tfor _, v := range values {\n\t\tif v > 0 {\n\t\t\ttotal += v\n\t\t}\n\t}\n"
+	tests := []struct {
+		name         string
+		old, new     string
+		wantChanged  *domain.Source
+		wantMissings []string
+	}{
+		{"deletion", loop, "", &domain.Source{Filename: "a.go", StartL: 5, EndL: 9}, []string{"a.go", "b/b.go"}},
+		{"modification", loop, "\ttotal = len(values)\n", &domain.Source{Filename: "a.go", StartL: 5, EndL: 9}, []string{"a.go", "b/b.go"}},
+		// The remaining copy in sumB is near the deleted location, but is not regarded as the deleted location itself
+		{"deletion next to a copy", fmt.Sprintf(searchTestFunc, "A"), "", &domain.Source{Filename: "a.go", StartL: 3, EndL: 11}, []string{"a.go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := searchTestFiles()
+			files["a.go"] = strings.Replace(files["a.go"], tt.old, tt.new, 1)
+			to := searchTestTree(t, files)
+			filePatches, err := domain.DiffTrees(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sets, err := SearchDeleted(context.Background(), "token", filePatches, from, to, &Config{Matcher: domain.DefaultMatcherRules()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sets) != 1 {
+				t.Fatalf("expected 1 clone set, got %d: %v", len(sets), sets)
+			}
+			set := sets[0]
+			if !set.Deleted || len(set.Changed) != 1 || len(set.Missing) != len(tt.wantMissings) {
+				t.Fatalf("expected deleted clone set with 1 changed and %d missing clones, got %v and %v",
+					len(tt.wantMissings), set.Changed, set.Missing)
+			}
+			if c := set.Changed[0]; c.Filename != tt.wantChanged.Filename || c.StartL != tt.wantChanged.StartL || c.EndL != tt.wantChanged.EndL {
+				t.Errorf("expected deleted region %v:L%d-L%d in the base tree, got %v:L%d-L%d",
+					tt.wantChanged.Filename, tt.wantChanged.StartL, tt.wantChanged.EndL, c.Filename, c.StartL, c.EndL)
+			}
+			// Remaining copies in sumB and sumC
+			for i, filename := range tt.wantMissings {
+				if m := set.Missing[i]; m.Filename != filename {
+					t.Errorf("expected remaining copy in %v, got %v:L%d-L%d", filename, m.Filename, m.StartL, m.EndL)
+				}
+			}
+		})
+	}
+
+	// Nothing deleted
+	filePatches, err := domain.DiffTrees(from, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets, err := SearchDeleted(context.Background(), "token", filePatches, from, from, &Config{Matcher: domain.DefaultMatcherRules()})
+	if err != nil || len(sets) != 0 {
+		t.Errorf("expected no clone sets without deletions, got %v, %v", sets, err)
+	}
+}
+
+func TestSearchDeleted_Algorithms(t *testing.T) {
+	from := searchTestTree(t, searchTestFiles())
+	files := searchTestFiles()
+	files["a.go"] = strings.Replace(files["a.go"], "\tfor _, v := range values {\n\t\tif v > 0 {\n\t\t\ttotal += v\n\t\t}\n\t}\n", "", 1)
+	to := searchTestTree(t, files)
+	filePatches, err := domain.DiffTrees(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remaining copies are attributed to the deleted code by Sources of clones, so every algorithm must report them
+	for _, algorithm := range []string{"ncdsearch", "token"} {
+		t.Run(algorithm, func(t *testing.T) {
+			sets, err := SearchDeleted(context.Background(), algorithm, filePatches, from, to, &Config{Matcher: domain.DefaultMatcherRules()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sets) != 1 || len(sets[0].Changed) != 1 || len(sets[0].Missing) != 2 {
+				t.Fatalf("expected 1 deleted clone set with 1 changed and 2 missing clones, got %v", sets)
+			}
+			if c := sets[0].Changed[0]; c.Filename != "a.go" || c.StartL != 5 || c.EndL != 9 {
+				t.Errorf("expected deleted region a.go:L5-L9 in the base tree, got %v:L%d-L%d", c.Filename, c.StartL, c.EndL)
+			}
+		})
+	}
+}
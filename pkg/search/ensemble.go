@@ -0,0 +1,263 @@
+package search
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/sourcegraph/conc/pool"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/ds"
+)
+
+const ensembleName = "ensemble"
+
+// Voting modes of the ensemble algorithm, in addition to an integer k (found by at least k algorithms).
+const (
+	voteUnion        = "union"
+	voteIntersection = "intersection"
+)
+
+// fusions fuse distances of a clone given by each algorithm into one.
+// Distances are on the scale of each algorithm (1 - similarity for fleccs, token and goast,
+// normalized compression distance for ncdsearch), and are not normalized.
+var fusions = map[string]func(distances []float64) float64{
+	"mean": func(distances []float64) float64 { return lo.Sum(distances) / float64(len(distances)) },
+	"min":  lo.Min[float64],
+	"max":  lo.Max[float64],
+}
+
+// ensembleMembers parses the comma-separated names of algorithms to run.
+func ensembleMembers(value string) ([]string, error) {
+	names := splitAlgorithmNames(value)
+	for _, name := range names {
+		if name == "" {
+			return nil, errors.Errorf("expected comma-separated algorithm names, got %q", value)
+		}
+		if name == ensembleName {
+			return nil, errors.Errorf("%v cannot run itself", ensembleName)
+		}
+		if _, err := GetAlgorithm(name); err != nil {
+			return nil, err
+		}
+	}
+	if dup := lo.FindDuplicates(names); len(dup) > 0 {
+		return nil, errors.Errorf("algorithm %v is given more than once", dup[0])
+	}
+	return names, nil
+}
+
+func validateEnsembleMembers(value string) error {
+	_, err := ensembleMembers(value)
+	return err
+}
+
+// parseVote returns the minimum number of algorithms which must find a clone, out of n algorithms.
+// n can be 0 if not known yet, in which case k is not checked against n.
+func parseVote(value string, n int) (int, error) {
+	switch value {
+	case voteUnion:
+		return 1, nil
+	case voteIntersection:
+		return n, nil
+	}
+	k, err := strconv.Atoi(value)
+	if err != nil || k < 1 {
+		return 0, errors.Errorf("expected %v, %v, or a positive integer k, got %q", voteUnion, voteIntersection, value)
+	}
+	if n > 0 && k > n {
+		return 0, errors.Errorf("vote %d exceeds the number of algorithms (%d)", k, n)
+	}
+	return k, nil
+}
+
+func validateVote(value string) error {
+	_, err := parseVote(value, 0)
+	return err
+}
+
+func validateFusion(value string) error {
+	if _, ok := fusions[value]; !ok {
+		names := lo.Keys(fusions)
+		slices.Sort(names)
+		return errors.Errorf("expected one of %s, got %q", strings.Join(names, ", "), value)
+	}
+	return nil
+}
+
+// nestedParams returns the parameters of the algorithm given in the form of '<algorithm>.<name>'.
+func nestedParams(params map[string]string, algorithm string) map[string]string {
+	ret := make(map[string]string)
+	for name, value := range params {
+		if i := strings.LastIndex(name, "."); i >= 0 && name[:i] == algorithm {
+			ret[name[i+1:]] = value
+		}
+	}
+	return ret
+}
+
+// ensembleSearch runs the member algorithms concurrently on the same trees, and merges their clones by voting.
+func ensembleSearch(
+	ctx context.Context,
+	sourceTree domain.Searcher,
+	sources []*domain.Source,
+	searchTree domain.Searcher,
+	c *Config,
+) ([]*domain.Clone, error) {
+	params, err := parseParams(ensembleName, c)
+	if err != nil {
+		return nil, err
+	}
+	members, err := ensembleMembers(params.String("algorithms"))
+	if err != nil {
+		return nil, err
+	}
+	minVotes, err := parseVote(params.String("vote"), len(members))
+	if err != nil {
+		return nil, err
+	}
+	fuse := fusions[params.String("fusion")]
+
+	results := make([][]*domain.Clone, len(members))
+	incompletes := make([]*domain.IncompleteError, len(members))
+	p := pool.New().
+		WithErrors().
+		WithContext(ctx).
+		WithCancelOnError().
+		WithFirstError()
+	for i, member := range members {
+		p.Go(func(ctx context.Context) error {
+			algorithm, err := GetAlgorithm(member)
+			if err != nil {
+				return err
+			}
+			memberConf := *c
+			memberConf.AlgoParams = nestedParams(c.AlgoParams, member)
+			clones, err := algorithm.Search(ctx, sourceTree, sources, searchTree, &memberConf)
+			if incomplete, ok := domain.AsIncomplete(err); ok {
+				incompletes[i] = incomplete
+			} else if err != nil {
+				return errors.Wrapf(err, "running %v", member)
+			}
+			results[i] = clones
+			return nil
+		})
+	}
+	if err = p.Wait(); err != nil {
+		return nil, err
+	}
+
+	clones := voteClones(results, minVotes, fuse)
+	// Report coverage of the least complete search
+	incomplete := lo.MinBy(lo.Compact(incompletes), func(a, b *domain.IncompleteError) bool { return a.Percent() < b.Percent() })
+	if incomplete != nil {
+		return clones, incomplete
+	}
+	return clones, nil
+}
+
+// minVoteOverlap is the minimum overlap of lines (intersection over union) for clones found by algorithms to agree.
+const minVoteOverlap = 0.5
+
+// lineOverlap returns the intersection over union of lines of the clones.
+func lineOverlap(a, b *domain.Clone) float64 {
+	if a.Filename != b.Filename {
+		return 0
+	}
+	intersection := min(a.EndL, b.EndL) - max(a.StartL, b.StartL) + 1
+	if intersection <= 0 {
+		return 0
+	}
+	union := max(a.EndL, b.EndL) - min(a.StartL, b.StartL) + 1
+	return float64(intersection) / float64(union)
+}
+
+// voteClones merges clones found by each algorithm which agree with each other (overlap by at least minVoteOverlap),
+// and keeps the ones found by at least minVotes algorithms.
+//
+// Clones are not chained transitively: each merged clone keeps the region of the clone with the most votes
+// (and the smallest distance among them), and absorbs the clones agreeing with that clone.
+// Distance of a merged clone is fused from the smallest distance given by each algorithm which found it.
+func voteClones(results [][]*domain.Clone, minVotes int, fuse func(distances []float64) float64) []*domain.Clone {
+	type vote struct {
+		algorithm int
+		clone     *domain.Clone
+		agreeing  []int // Indices of votes agreeing with this, including itself
+		count     int   // Number of algorithms agreeing with this, before merging any
+		used      bool
+	}
+	var votes []*vote
+	for i, clones := range results {
+		for _, cl := range clones {
+			votes = append(votes, &vote{algorithm: i, clone: cl})
+		}
+	}
+	slices.SortStableFunc(votes, ds.SortCompose(
+		ds.SortAsc(func(v *vote) string { return v.clone.Filename }),
+		ds.SortAsc(func(v *vote) int { return v.clone.StartL }),
+	))
+	for i, v := range votes {
+		v.agreeing = append(v.agreeing, i)
+		for j := i + 1; j < len(votes) && votes[j].clone.Filename == v.clone.Filename && votes[j].clone.StartL <= v.clone.EndL; j++ {
+			if lineOverlap(v.clone, votes[j].clone) >= minVoteOverlap {
+				v.agreeing = append(v.agreeing, j)
+				votes[j].agreeing = append(votes[j].agreeing, i)
+			}
+		}
+	}
+
+	// countVotes counts algorithms of the unused agreeing votes.
+	countVotes := func(v *vote) int {
+		return len(lo.Uniq(ds.Map(
+			lo.Filter(v.agreeing, func(j int, _ int) bool { return !votes[j].used }),
+			func(j int) int { return votes[j].algorithm },
+		)))
+	}
+	for _, v := range votes {
+		v.count = countVotes(v)
+	}
+	order := slices.Clone(votes)
+	slices.SortStableFunc(order, ds.SortCompose(
+		ds.SortDesc(func(v *vote) int { return v.count }),
+		ds.SortAsc(func(v *vote) float64 { return v.clone.Distance }),
+	))
+
+	var merged []*domain.Clone
+	for _, v := range order {
+		if v.used || countVotes(v) < minVotes {
+			continue
+		}
+		members := ds.Map(
+			lo.Filter(v.agreeing, func(j int, _ int) bool { return !votes[j].used }),
+			func(j int) *vote { return votes[j] },
+		)
+		best := make([]float64, len(results))
+		for i := range best {
+			best[i] = math.Inf(1)
+		}
+		for _, m := range members {
+			best[m.algorithm] = min(best[m.algorithm], m.clone.Distance)
+			m.used = true
+		}
+		merged = append(merged, &domain.Clone{
+			Filename: v.clone.Filename,
+			StartL:   v.clone.StartL,
+			EndL:     v.clone.EndL,
+			Distance: fuse(lo.Filter(best, func(d float64, _ int) bool { return !math.IsInf(d, 1) })),
+			Sources: lo.UniqBy(
+				ds.FlatMap(members, func(m *vote) []*domain.Source { return m.clone.Sources }),
+				func(s *domain.Source) string { return s.Key() },
+			),
+		})
+	}
+	slices.SortFunc(merged, ds.SortCompose(
+		ds.SortAsc(func(c *domain.Clone) string { return c.Filename }),
+		ds.SortAsc(func(c *domain.Clone) int { return c.StartL }),
+	))
+	return merged
+}
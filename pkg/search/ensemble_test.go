@@ -0,0 +1,153 @@
+package search
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+func TestVoteClones(t *testing.T) {
+	q1 := &domain.Source{Filename: "a.go", StartL: 1, EndL: 3}
+	q2 := &domain.Source{Filename: "b.go", StartL: 1, EndL: 3}
+	results := [][]*domain.Clone{
+		{
+			{Filename: "a.go", StartL: 10, EndL: 19, Distance: 0.25, Sources: []*domain.Source{q1}},
+			{Filename: "a.go", StartL: 30, EndL: 35, Distance: 0.4, Sources: []*domain.Source{q1}},
+			{Filename: "b.go", StartL: 1, EndL: 10, Distance: 0.1, Sources: []*domain.Source{q1}},
+		},
+		{
+			// Agrees with a.go:L10-L19 (overlap 8/12)
+			{Filename: "a.go", StartL: 12, EndL: 21, Distance: 0.75, Sources: []*domain.Source{q2}},
+			// Overlaps b.go:L1-L10 by only 2 lines, which is not an agreement
+			{Filename: "b.go", StartL: 9, EndL: 30, Distance: 0.1, Sources: []*domain.Source{q2}},
+			{Filename: "c.go", StartL: 1, EndL: 3, Distance: 0.1, Sources: []*domain.Source{q2}},
+		},
+	}
+	type clone struct {
+		filename   string
+		startL     int
+		endL       int
+		distance   float64
+		numSources int
+	}
+	tests := []struct {
+		name     string
+		minVotes int
+		fusion   string
+		want     []clone
+	}{
+		{"union", 1, "mean", []clone{
+			{"a.go", 10, 19, 0.5, 2},
+			{"a.go", 30, 35, 0.4, 1},
+			{"b.go", 1, 10, 0.1, 1},
+			{"b.go", 9, 30, 0.1, 1},
+			{"c.go", 1, 3, 0.1, 1},
+		}},
+		{"intersection", 2, "mean", []clone{{"a.go", 10, 19, 0.5, 2}}},
+		{"intersection max", 2, "max", []clone{{"a.go", 10, 19, 0.75, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := voteClones(results, tt.minVotes, fusions[tt.fusion])
+			if len(merged) != len(tt.want) {
+				t.Fatalf("expected %d clones, got %d", len(tt.want), len(merged))
+			}
+			for i, want := range tt.want {
+				got := merged[i]
+				if got.Filename != want.filename || got.StartL != want.startL || got.EndL != want.endL ||
+					got.Distance != want.distance || len(got.Sources) != want.numSources {
+					t.Errorf("clone #%d: expected %v, got %v:L%d-L%d (distance %v, %d sources)",
+						i, want, got.Filename, got.StartL, got.EndL, got.Distance, len(got.Sources))
+				}
+			}
+		})
+	}
+}
+
+func TestVoteClones_NoChaining(t *testing.T) {
+	// Sliding windows of one algorithm overlapping each other by 1 line must not grow into one region
+	var windows []*domain.Clone
+	for start := 1; start < 100; start += 9 {
+		windows = append(windows, &domain.Clone{Filename: "a.go", StartL: start, EndL: start + 9})
+	}
+	other := []*domain.Clone{{Filename: "a.go", StartL: 20, EndL: 29}}
+	merged := voteClones([][]*domain.Clone{windows, other}, 2, fusions["mean"])
+	if len(merged) != 1 {
+		t.Fatalf("expected 1 clone, got %d", len(merged))
+	}
+	if m := merged[0]; m.EndL-m.StartL+1 != 10 {
+		t.Errorf("expected region of a single window, got L%d-L%d", m.StartL, m.EndL)
+	}
+}
+
+func TestValidateParam_Ensemble(t *testing.T) {
+	if err := ValidateParams("ensemble", map[string]string{
+		"algorithms":       "fleccs, token",
+		"vote":             "2",
+		"fusion":           "min",
+		"fleccs.threshold": "0.8",
+		"token.normalize":  "false",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		param       string
+		value       string
+		errContains string
+	}{
+		{"unknown algorithm", "algorithms", "fleccs,nsdsearch", `invalid algorithm name: nsdsearch, did you mean "ncdsearch"?`},
+		{"itself", "algorithms", "fleccs,ensemble", "ensemble cannot run itself"},
+		{"duplicate", "algorithms", "fleccs,fleccs", "algorithm fleccs is given more than once"},
+		{"invalid vote", "vote", "0", "expected union, intersection, or a positive integer k"},
+		{"invalid fusion", "fusion", "avg", "expected one of max, mean, min"},
+		{"nested out of range", "fleccs.threshold", "2", `parameter "threshold" of fleccs must be 0 to 1`},
+		{"nested unknown algorithm", "flecs.threshold", "0.5", `parameter "flecs.threshold" of ensemble: invalid algorithm name: flecs`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateParam("ensemble", tt.param, tt.value)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("expected error to contain %q, got %q", tt.errContains, err.Error())
+			}
+		})
+	}
+}
+
+func TestSearch_Ensemble(t *testing.T) {
+	t.Setenv(execTestEnv, "ok")
+	tree := execTestTree(t)
+	queries := []*domain.Source{{Filename: "a.go", StartL: 5, EndL: 7}}
+	sets, err := Search(context.Background(), "ensemble", queries, tree, &Config{
+		Matcher: domain.DefaultMatcherRules(),
+		AlgoParams: map[string]string{
+			"algorithms":      "goast," + ExecPrefix + os.Args[0],
+			"vote":            "intersection",
+			"goast.min-nodes": "0",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 || len(sets[0].Missing) != 1 {
+		t.Fatalf("expected 1 clone set with 1 missing clone, got %v", sets)
+	}
+	if m := sets[0].Missing[0]; m.StartL > 13 || m.EndL < 15 {
+		t.Errorf("expected clone in b() to be missing, got L%d-L%d", m.StartL, m.EndL)
+	}
+
+	_, err = Search(context.Background(), "ensemble", queries, tree, &Config{
+		Matcher:    domain.DefaultMatcherRules(),
+		AlgoParams: map[string]string{"vote": "3"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "vote 3 exceeds the number of algorithms (2)") {
+		t.Errorf("expected error for vote exceeding the number of algorithms, got %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/files"
+)
+
+// ExecPrefix is the prefix of names of external algorithms, followed by the path of the executable
+// (such as "exec:./bin/my-detector"). The executable is looked up in PATH if the path has no separators,
+// and relative paths are resolved against the current directory (see ResolveExecPath for config files).
+//
+// The executable is run once per search. It reads an ExecRequest as JSON from stdin,
+// and writes an ExecResponse as JSON to stdout. Non-zero exit status fails the search.
+// Returned clones go through the same deduplication and grouping into clone sets as built-in algorithms.
+const ExecPrefix = "exec:"
+
+// ExecProtocolVersion is the version of ExecRequest and ExecResponse.
+// It is incremented on incompatible changes.
+const ExecProtocolVersion = 1
+
+// ExecRequest is written to stdin of external algorithms.
+type ExecRequest struct {
+	Version int `json:"version"`
+	// Params are the algorithm parameters (given by --algorithm-param or config files), as strings.
+	Params map[string]string `json:"params"`
+	// Queries are the regions to search for clones of.
+	Queries []*ExecQuery `json:"queries"`
+	// Files are the files to search in, excluding binary files and files ignored entirely by ignore rules.
+	Files []*ExecFile `json:"files"`
+}
+
+// ExecQuery is a region to search for clones of.
+type ExecQuery struct {
+	Filename string `json:"filename"`
+	// StartL and EndL are 1-indexed, inclusive line numbers.
+	StartL int `json:"start_l"`
+	EndL   int `json:"end_l"`
+	// Content is the content of the lines from StartL to EndL.
+	// Queries can be of files not in Files, such as deleted files.
+	Content string `json:"content"`
+}
+
+// ExecFile is a file to search in.
+type ExecFile struct {
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+	// IgnoredLines are 1-indexed line numbers ignored by ignore rules.
+	// Clones including any of them are dropped from the response.
+	IgnoredLines []int `json:"ignored_lines,omitempty"`
+}
+
+// ExecResponse is read from stdout of external algorithms.
+type ExecResponse struct {
+	Clones []*ExecClone `json:"clones"`
+	// Error fails the search with the message, if not empty.
+	Error string `json:"error,omitempty"`
+}
+
+// ExecClone is a clone found in one of the requested files.
+type ExecClone struct {
+	Filename string `json:"filename"`
+	// StartL and EndL are 1-indexed, inclusive line numbers.
+	StartL int `json:"start_l"`
+	EndL   int `json:"end_l"`
+	// Distance is 0 for identical code, larger for less similar code.
+	Distance float64 `json:"distance"`
+	// Queries are the indices of queries in the request this is a clone of.
+	Queries []int `json:"queries"`
+}
+
+// ResolveExecPath resolves the relative path of the external algorithm against dir.
+// Other names, and paths looked up in PATH are returned as is.
+func ResolveExecPath(name, dir string) string {
+	path, ok := strings.CutPrefix(name, ExecPrefix)
+	if !ok || path == "" || filepath.IsAbs(path) || !strings.ContainsAny(path, "/"+string(filepath.Separator)) {
+		return name
+	}
+	return ExecPrefix + filepath.Join(dir, path)
+}
+
+func newExecAlgorithm(name, path string) *Algorithm {
+	return &Algorithm{
+		Name:            name,
+		Description:     fmt.Sprintf("External algorithm running %v.", path),
+		ArbitraryParams: true,
+		Search: func(ctx context.Context, sourceTree domain.Searcher, sources []*domain.Source, searchTree domain.Searcher, c *Config) ([]*domain.Clone, error) {
+			return execSearch(ctx, path, sourceTree, sources, searchTree, c)
+		},
+	}
+}
+
+func execSearch(
+	ctx context.Context,
+	path string,
+	sourceTree domain.Searcher,
+	sources []*domain.Source,
+	searchTree domain.Searcher,
+	c *Config,
+) ([]*domain.Clone, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	req, ignoreRules, err := execRequest(sourceTree, sources, searchTree, c)
+	if err != nil {
+		return nil, err
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding request to external algorithm")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	slog.Debug("Running external algorithm", "path", path, "queries", len(req.Queries), "files", len(req.Files))
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = errors.Errorf("%v: %s", err, msg)
+		}
+		return nil, errors.Wrapf(err, "running external algorithm %v", path)
+	}
+	if stderr.Len() > 0 {
+		slog.Debug("External algorithm stderr", "path", path, "stderr", stderr.String())
+	}
+
+	var res ExecResponse
+	if err = json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		return nil, errors.Wrapf(err, "decoding response of external algorithm %v", path)
+	}
+	if res.Error != "" {
+		return nil, errors.Errorf("external algorithm %v: %s", path, res.Error)
+	}
+	return execClones(res.Clones, req, sources, ignoreRules)
+}
+
+// execRequest builds the request, and returns it with ignore rules of each file.
+func execRequest(
+	sourceTree domain.Searcher,
+	sources []*domain.Source,
+	searchTree domain.Searcher,
+	c *Config,
+) (*ExecRequest, map[string]*domain.IgnoreLineRule, error) {
+	req := &ExecRequest{
+		Version: ExecProtocolVersion,
+		Params:  lo.Ternary(c.AlgoParams != nil, c.AlgoParams, map[string]string{}),
+	}
+
+	// Queries
+	sourceContents := make(map[string][]byte)
+	for _, src := range sources {
+		content, ok := sourceContents[src.Filename]
+		if !ok {
+			file, err := sourceTree.Open(src.Filename)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "opening query file %v", src.Filename)
+			}
+			content, err = file.Content()
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "reading query file %v", src.Filename)
+			}
+			sourceContents[src.Filename] = content
+		}
+		lines, err := lineRange(content, src.StartL, src.EndL)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "reading query %v", src.Key())
+		}
+		req.Queries = append(req.Queries, &ExecQuery{
+			Filename: src.Filename,
+			StartL:   src.StartL,
+			EndL:     src.EndL,
+			Content:  string(lines),
+		})
+	}
+
+	// Files
+	filenames, err := searchTree.Files()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "listing files")
+	}
+	ignoreRules := make(map[string]*domain.IgnoreLineRule)
+	for _, filename := range filenames {
+		file, err := searchTree.Open(filename)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "opening search target file %v", filename)
+		}
+		isBinary, err := file.IsBinary()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "calculating binary status of search target file %v", filename)
+		}
+		if isBinary {
+			continue
+		}
+		content, err := file.Content()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "reading search target file %v", filename)
+		}
+		skipEntireFile, ignoreRule := c.Matcher.Match(filename, content)
+		if skipEntireFile {
+			continue
+		}
+		ignoreRules[filename] = ignoreRule
+		// IgnoreLines are 0-indexed
+		ignored := ds.Map(lo.Keys(ignoreRule.IgnoreLines), func(l int) int { return l + 1 })
+		slices.Sort(ignored)
+		req.Files = append(req.Files, &ExecFile{
+			Filename:     filename,
+			Content:      string(content),
+			IgnoredLines: ignored,
+		})
+	}
+	return req, ignoreRules, nil
+}
+
+// execClones validates clones of the response, and maps them to domain clones.
+func execClones(
+	clones []*ExecClone,
+	req *ExecRequest,
+	sources []*domain.Source,
+	ignoreRules map[string]*domain.IgnoreLineRule,
+) ([]*domain.Clone, error) {
+	lineCounts := make(map[string]int, len(req.Files))
+	for _, f := range req.Files {
+		lineCounts[f.Filename] = len(files.LineStartIndices([]byte(f.Content)))
+	}
+
+	ret := make([]*domain.Clone, 0, len(clones))
+	for i, cl := range clones {
+		lines, ok := lineCounts[cl.Filename]
+		if !ok {
+			return nil, errors.Errorf("clone #%d of external algorithm: %v is not one of the requested files", i, cl.Filename)
+		}
+		if cl.StartL < 1 || cl.EndL < cl.StartL || lines < cl.EndL {
+			return nil, errors.Errorf("clone #%d of external algorithm: invalid lines L%d-L%d of %v (%d lines)", i, cl.StartL, cl.EndL, cl.Filename, lines)
+		}
+		if cl.Distance < 0 {
+			return nil, errors.Errorf("clone #%d of external algorithm: distance must not be negative, got %v", i, cl.Distance)
+		}
+		if len(cl.Queries) == 0 {
+			return nil, errors.Errorf("clone #%d of external algorithm: no queries are given", i)
+		}
+		for _, q := range cl.Queries {
+			if q < 0 || len(sources) <= q {
+				return nil, errors.Errorf("clone #%d of external algorithm: query index %d out of range", i, q)
+			}
+		}
+
+		// CanSkip takes 0-indexed lines
+		if canSkip, _ := ignoreRules[cl.Filename].CanSkip(cl.StartL-1, cl.EndL-cl.StartL+1); canSkip {
+			continue
+		}
+		ret = append(ret, &domain.Clone{
+			Filename: cl.Filename,
+			StartL:   cl.StartL,
+			EndL:     cl.EndL,
+			Distance: cl.Distance,
+			Sources:  ds.Map(lo.Uniq(cl.Queries), func(q int) *domain.Source { return sources[q] }),
+		})
+	}
+	return ret, nil
+}
+
+// lineRange returns the content of lines from startL to endL (1-indexed, inclusive).
+func lineRange(content []byte, startL, endL int) ([]byte, error) {
+	indices := files.LineStartIndices(content)
+	if startL < 1 || endL < startL || len(indices) < endL {
+		return nil, errors.Errorf("invalid lines L%d-L%d (%d lines)", startL, endL, len(indices))
+	}
+	end := len(content)
+	if endL < len(indices) {
+		end = indices[endL]
+	}
+	return content[indices[startL-1]:end], nil
+}
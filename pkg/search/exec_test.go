@@ -0,0 +1,193 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+// execTestEnv makes the test binary itself act as an external algorithm.
+const execTestEnv = "ICCHECK_TEST_EXEC_ALGORITHM"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(execTestEnv); mode != "" {
+		os.Exit(runExecTestAlgorithm(mode))
+	}
+	os.Exit(m.Run())
+}
+
+// runExecTestAlgorithm finds exact copies of queries, or returns a response broken according to mode.
+func runExecTestAlgorithm(mode string) int {
+	var req ExecRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		_, _ = os.Stderr.WriteString(err.Error())
+		return 1
+	}
+	switch mode {
+	case "fail":
+		_, _ = os.Stderr.WriteString("something went wrong")
+		return 2
+	case "error":
+		_ = json.NewEncoder(os.Stdout).Encode(&ExecResponse{Error: "unsupported language"})
+		return 0
+	}
+
+	var res ExecResponse
+	for _, f := range req.Files {
+		lines := strings.SplitAfter(f.Content, "\n")
+		for qi, q := range req.Queries {
+			n := strings.Count(q.Content, "\n")
+			for start := 0; start+n <= len(lines); start++ {
+				if strings.Join(lines[start:start+n], "") != q.Content {
+					continue
+				}
+				res.Clones = append(res.Clones, &ExecClone{
+					Filename: f.Filename,
+					StartL:   start + 1,
+					EndL:     start + n,
+					Queries:  []int{qi},
+				})
+			}
+		}
+	}
+	if mode == "bad-query" {
+		for _, cl := range res.Clones {
+			cl.Queries = []int{len(req.Queries)}
+		}
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(&res)
+	return 0
+}
+
+// execTestFile has exact copies of the nil check at L5-L7 and L13-L15.
+const execTestFile = `package a
+
+func a() {
+	x := get()
+	if x == nil {
+		return
+	}
+	use(x)
+}
+
+func b() {
+	x := get()
+	if x == nil {
+		return
+	}
+	use(x)
+}
+`
+
+func execTestTree(t *testing.T) domain.Tree {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(execTestFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func execTestSearch(t *testing.T, mode string) ([]*domain.CloneSet, error) {
+	t.Helper()
+	t.Setenv(execTestEnv, mode)
+	tree := execTestTree(t)
+	queries := []*domain.Source{{Filename: "a.go", StartL: 5, EndL: 7}}
+	return Search(context.Background(), ExecPrefix+os.Args[0], queries, tree, &Config{
+		Matcher: domain.DefaultMatcherRules(),
+	})
+}
+
+func TestSearch_Exec(t *testing.T) {
+	sets, err := execTestSearch(t, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 {
+		t.Fatalf("expected 1 clone set, got %d", len(sets))
+	}
+	set := sets[0]
+	if len(set.Changed) != 1 || len(set.Missing) != 1 {
+		t.Fatalf("expected 1 changed and 1 missing clone, got %v and %v", set.Changed, set.Missing)
+	}
+	if m := set.Missing[0]; m.StartL != 13 || m.EndL != 15 {
+		t.Errorf("expected clone in b() to be missing, got L%d-L%d", m.StartL, m.EndL)
+	}
+}
+
+func TestSearch_ExecInvalid(t *testing.T) {
+	tests := []struct {
+		mode        string
+		errContains string
+	}{
+		{"fail", "something went wrong"},
+		{"error", "unsupported language"},
+		{"bad-query", "query index 1 out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			_, err := execTestSearch(t, tt.mode)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("expected error to contain %q, got %q", tt.errContains, err.Error())
+			}
+		})
+	}
+}
+
+func TestResolveExecPath(t *testing.T) {
+	dir := filepath.Join("home", "user")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"exec:./bin/detector", ExecPrefix + filepath.Join(dir, "bin", "detector")},
+		{"exec:bin/detector", ExecPrefix + filepath.Join(dir, "bin", "detector")},
+		{"exec:detector", "exec:detector"}, // looked up in PATH
+		{"exec:/usr/bin/detector", "exec:/usr/bin/detector"},
+		{"fleccs", "fleccs"},
+	}
+	for _, tt := range tests {
+		if got := ResolveExecPath(tt.name, dir); got != tt.want {
+			t.Errorf("ResolveExecPath(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolveParam(t *testing.T) {
+	resolve := func(name string) (string, error) {
+		if strings.HasPrefix(name, ExecPrefix) {
+			return "", errors.New("external algorithm not allowed")
+		}
+		return strings.ToUpper(name), nil
+	}
+	tests := []struct {
+		algorithm, name, value string
+		wantName, wantValue    string
+		wantErr                bool
+	}{
+		{"fleccs", "threshold", "0.5", "threshold", "0.5", false},
+		{"ensemble", "algorithms", "fleccs, token", "algorithms", "FLECCS,TOKEN", false},
+		{"ensemble", "algorithms", "fleccs,exec:./x", "", "", true},
+		{"ensemble", "fleccs.threshold", "0.5", "FLECCS.threshold", "0.5", false},
+		{"ensemble", "exec:./x.threshold", "0.5", "", "", true},
+	}
+	for _, tt := range tests {
+		name, value, err := ResolveParam(tt.algorithm, tt.name, tt.value, resolve)
+		if (err != nil) != tt.wantErr || name != tt.wantName || value != tt.wantValue {
+			t.Errorf("ResolveParam(%q, %q, %q) = %q, %q, %v", tt.algorithm, tt.name, tt.value, name, value, err)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/strs"
+	"github.com/samber/lo"
+)
+
+// ParamType is the type of algorithm parameter values.
+type ParamType string
+
+const (
+	ParamInt    ParamType = "int"
+	ParamFloat  ParamType = "float"
+	ParamBool   ParamType = "bool"
+	ParamString ParamType = "string"
+)
+
+// Param describes a parameter of an algorithm.
+type Param struct {
+	Name string
+	Type ParamType
+	// Min and Max are the inclusive range of int and float values.
+	// Use math.Inf for unbounded ranges.
+	Min, Max float64
+	// Default is the value used when the parameter is not given, of the type corresponding to Type (int, float64, bool or string).
+	Default     any
+	Description string
+	// Validate validates values of string parameters, if not nil.
+	Validate func(value string) error
+	// ListsAlgorithms tells that values of the string parameter are comma-separated names of algorithms,
+	// which are resolved by ResolveParam.
+	ListsAlgorithms bool
+}
+
+// Range returns a human-readable range of values, or empty string if unbounded.
+func (p *Param) Range() string {
+	if p.Type == ParamBool || p.Type == ParamString {
+		return ""
+	}
+	format := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+	switch {
+	case !math.IsInf(p.Min, -1) && !math.IsInf(p.Max, 1):
+		return fmt.Sprintf("%s to %s", format(p.Min), format(p.Max))
+	case !math.IsInf(p.Min, -1):
+		return fmt.Sprintf(">= %s", format(p.Min))
+	case !math.IsInf(p.Max, 1):
+		return fmt.Sprintf("<= %s", format(p.Max))
+	default:
+		return ""
+	}
+}
+
+// parse parses and validates the value of the parameter.
+func (p *Param) parse(algorithm, value string) (any, error) {
+	var (
+		v   any
+		f   float64
+		err error
+	)
+	switch p.Type {
+	case ParamInt:
+		var i int
+		i, err = strconv.Atoi(value)
+		v, f = i, float64(i)
+	case ParamFloat:
+		f, err = strconv.ParseFloat(value, 64)
+		v = f
+	case ParamBool:
+		v, err = strconv.ParseBool(value)
+	case ParamString:
+		if p.Validate != nil {
+			if err = p.Validate(value); err != nil {
+				return nil, errors.Errorf("parameter %q of %v: %v", p.Name, algorithm, err)
+			}
+		}
+		return value, nil
+	default:
+		panic(fmt.Sprintf("unknown param type: %v", p.Type))
+	}
+	if err != nil {
+		return nil, errors.Errorf("parameter %q of %v expects %s value, got %q", p.Name, algorithm, p.Type, value)
+	}
+	if p.Type != ParamBool && (f < p.Min || p.Max < f) {
+		return nil, errors.Errorf("parameter %q of %v must be %s, got %v", p.Name, algorithm, p.Range(), value)
+	}
+	return v, nil
+}
+
+// check checks that the declaration of the parameter is valid.
+func (p *Param) check() error {
+	if p.Name == "" {
+		return errors.New("parameter name must not be empty")
+	}
+	var ok bool
+	switch p.Type {
+	case ParamInt:
+		var i int
+		i, ok = p.Default.(int)
+		ok = ok && p.Min <= float64(i) && float64(i) <= p.Max
+	case ParamFloat:
+		var f float64
+		f, ok = p.Default.(float64)
+		ok = ok && p.Min <= f && f <= p.Max
+	case ParamBool:
+		_, ok = p.Default.(bool)
+	case ParamString:
+		var str string
+		str, ok = p.Default.(string)
+		ok = ok && (p.Validate == nil || p.Validate(str) == nil)
+	default:
+		return errors.Errorf("parameter %q has unknown type %q", p.Name, p.Type)
+	}
+	if !ok {
+		return errors.Errorf("default value %v of parameter %q is not a valid %s value in range %s", p.Default, p.Name, p.Type, p.Range())
+	}
+	return nil
+}
+
+// ParamValues are the validated values of all parameters of an algorithm, including default values.
+type ParamValues map[string]any
+
+func (v ParamValues) Int(name string) int {
+	return v[name].(int)
+}
+
+func (v ParamValues) Float(name string) float64 {
+	return v[name].(float64)
+}
+
+func (v ParamValues) Bool(name string) bool {
+	return v[name].(bool)
+}
+
+func (v ParamValues) String(name string) string {
+	return v[name].(string)
+}
+
+// Algorithm is a clone search algorithm and its parameters.
+type Algorithm struct {
+	Name        string
+	Description string
+	Params      []*Param
+	// ArbitraryParams accepts parameters not declared in Params, whose values are kept as strings.
+	ArbitraryParams bool
+	// NestedParams accepts parameters of other algorithms in the form of '<algorithm>.<name>',
+	// which are validated by the other algorithm and kept as strings.
+	NestedParams bool
+	// Search searches for clones of the sources (read from sourceTree) in searchTree.
+	// Returned clones are deduplicated and grouped into clone sets by Search.
+	Search AlgorithmFunc
+}
+
+// Param returns the parameter of the given name, if declared.
+func (a *Algorithm) Param(name string) (*Param, bool) {
+	return lo.Find(a.Params, func(p *Param) bool { return p.Name == name })
+}
+
+// ParseParams validates the given parameters in string forms, and fills in default values.
+func (a *Algorithm) ParseParams(params map[string]string) (ParamValues, error) {
+	values := make(ParamValues, len(a.Params))
+	for _, p := range a.Params {
+		values[p.Name] = p.Default
+	}
+	names := lo.Keys(params)
+	slices.Sort(names)
+	for _, name := range names {
+		v, err := a.ParseParam(name, params[name])
+		if err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+// ParseParam validates a parameter in string form.
+func (a *Algorithm) ParseParam(name, value string) (any, error) {
+	p, ok := a.Param(name)
+	if !ok && a.ArbitraryParams {
+		return value, nil
+	}
+	// Parameter names do not contain '.', while algorithm names (of external algorithms) may
+	if i := strings.LastIndex(name, "."); !ok && a.NestedParams && i >= 0 {
+		other, err := GetAlgorithm(name[:i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parameter %q of %v", name, a.Name)
+		}
+		if _, err = other.ParseParam(name[i+1:], value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+	if !ok {
+		paramNames := lo.Map(a.Params, func(p *Param, _ int) string { return p.Name })
+		if len(paramNames) == 0 {
+			return nil, errors.Errorf("unknown parameter %q, %v has no parameters", name, a.Name)
+		}
+		msg := fmt.Sprintf("unknown parameter %q of %v (available: %s)", name, a.Name, strings.Join(paramNames, ", "))
+		if closest, ok := strs.Closest(name, paramNames); ok {
+			msg += fmt.Sprintf(", did you mean %q?", closest)
+		}
+		return nil, errors.New(msg)
+	}
+	return p.parse(a.Name, value)
+}
+
+// RegisterAlgorithm registers the algorithm, which can then be used by its name like the built-in algorithms.
+// Returns an error if the name is already registered, or parameters are not declared properly.
+// Usually called from init functions of packages embedding ICCheck.
+func RegisterAlgorithm(a *Algorithm) error {
+	if a.Name == "" || strings.HasPrefix(a.Name, ExecPrefix) {
+		return errors.Errorf("invalid algorithm name %q", a.Name)
+	}
+	if a.Search == nil {
+		return errors.Errorf("algorithm %v has no Search function", a.Name)
+	}
+	for i, p := range a.Params {
+		if err := p.check(); err != nil {
+			return errors.Wrapf(err, "algorithm %v", a.Name)
+		}
+		if lo.ContainsBy(a.Params[:i], func(other *Param) bool { return other.Name == p.Name }) {
+			return errors.Errorf("algorithm %v declares parameter %q more than once", a.Name, p.Name)
+		}
+	}
+
+	algorithmsLock.Lock()
+	defer algorithmsLock.Unlock()
+	if _, ok := algorithms[a.Name]; ok {
+		return errors.Errorf("algorithm %v is already registered", a.Name)
+	}
+	algorithms[a.Name] = a
+	return nil
+}
+
+// Algorithms returns all registered algorithms, in the order of names.
+// External algorithms (see ExecPrefix) are not included.
+func Algorithms() []*Algorithm {
+	algorithmsLock.RLock()
+	algos := lo.Values(algorithms)
+	algorithmsLock.RUnlock()
+	slices.SortFunc(algos, func(a, b *Algorithm) int { return strings.Compare(a.Name, b.Name) })
+	return algos
+}
+
+// AlgorithmNames returns the names of registered algorithms, in sorted order.
+func AlgorithmNames() []string {
+	return lo.Map(Algorithms(), func(a *Algorithm, _ int) string { return a.Name })
+}
+
+// GetAlgorithm returns the algorithm of the given name.
+// Names with ExecPrefix return external algorithms running the executable.
+func GetAlgorithm(name string) (*Algorithm, error) {
+	if path, ok := strings.CutPrefix(name, ExecPrefix); ok {
+		if path == "" {
+			return nil, errors.Errorf("invalid algorithm name: %v, specify the path of the executable after %q", name, ExecPrefix)
+		}
+		return newExecAlgorithm(name, path), nil
+	}
+
+	algorithmsLock.RLock()
+	a, ok := algorithms[name]
+	algorithmsLock.RUnlock()
+	if !ok {
+		msg := fmt.Sprintf("invalid algorithm name: %v", name)
+		if closest, ok := strs.Closest(name, AlgorithmNames()); ok {
+			msg += fmt.Sprintf(", did you mean %q?", closest)
+		}
+		return nil, errors.New(msg)
+	}
+	return a, nil
+}
+
+// ResolveParam resolves names of algorithms held by the parameter of the algorithm,
+// such as members of ensemble and algorithms of nested parameters, by resolve.
+// Returns the resolved name and value of the parameter.
+func ResolveParam(algorithm, name, value string, resolve func(algorithm string) (string, error)) (string, string, error) {
+	a, err := GetAlgorithm(algorithm)
+	if err != nil {
+		return "", "", err
+	}
+	if p, ok := a.Param(name); ok {
+		if !p.ListsAlgorithms {
+			return name, value, nil
+		}
+		names, err := ds.MapError(splitAlgorithmNames(value), resolve)
+		if err != nil {
+			return "", "", err
+		}
+		return name, strings.Join(names, ","), nil
+	}
+	if i := strings.LastIndex(name, "."); a.NestedParams && i >= 0 {
+		nested, err := resolve(name[:i])
+		if err != nil {
+			return "", "", err
+		}
+		return nested + name[i:], value, nil
+	}
+	return name, value, nil
+}
+
+// splitAlgorithmNames splits comma-separated names of algorithms.
+func splitAlgorithmNames(value string) []string {
+	return lo.Map(strings.Split(value, ","), func(name string, _ int) string { return strings.TrimSpace(name) })
+}
+
+// ValidateParams validates parameters of the algorithm.
+func ValidateParams(algorithm string, params map[string]string) error {
+	a, err := GetAlgorithm(algorithm)
+	if err != nil {
+		return err
+	}
+	_, err = a.ParseParams(params)
+	return err
+}
+
+// ValidateParam validates a parameter of the algorithm.
+func ValidateParam(algorithm, name, value string) error {
+	a, err := GetAlgorithm(algorithm)
+	if err != nil {
+		return err
+	}
+	_, err = a.ParseParam(name, value)
+	return err
+}
+
+// parseParams parses parameters of the algorithm, for use in algorithm implementations.
+func parseParams(algorithm string, c *Config) (ParamValues, error) {
+	a, err := GetAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return a.ParseParams(c.AlgoParams)
+}
@@ -0,0 +1,126 @@
+package search
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+func TestParseParams(t *testing.T) {
+	a, err := GetAlgorithm("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := a.ParseParams(map[string]string{"threshold": "0.5", "normalize": "false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values.Float("threshold") != 0.5 || values.Bool("normalize") {
+		t.Errorf("expected given values to be parsed, got %v", values)
+	}
+	if values.Int("ngram") != 3 || values.Int("context-lines") != 4 {
+		t.Errorf("expected default values to be filled, got %v", values)
+	}
+}
+
+func TestValidateParam_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		algorithm   string
+		param       string
+		value       string
+		errContains string
+	}{
+		{"unknown algorithm", "flecs", "threshold", "0.5", `invalid algorithm name: flecs, did you mean "fleccs"?`},
+		{"unknown param", "fleccs", "treshold", "0.5", `unknown parameter "treshold" of fleccs (available: threshold, context-lines), did you mean "threshold"?`},
+		{"wrong type", "fleccs", "context-lines", "1.5", `parameter "context-lines" of fleccs expects int value, got "1.5"`},
+		{"wrong bool", "token", "normalize", "maybe", `parameter "normalize" of token expects bool value`},
+		{"out of range", "fleccs", "threshold", "1.5", `parameter "threshold" of fleccs must be 0 to 1, got 1.5`},
+		{"below minimum", "token", "ngram", "0", `parameter "ngram" of token must be >= 1, got 0`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateParam(tt.algorithm, tt.param, tt.value)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("expected error to contain %q, got %q", tt.errContains, err.Error())
+			}
+		})
+	}
+}
+
+func TestAlgorithms_Defaults(t *testing.T) {
+	for _, a := range Algorithms() {
+		for _, p := range a.Params {
+			var ok bool
+			switch p.Type {
+			case ParamInt:
+				_, ok = p.Default.(int)
+			case ParamFloat:
+				_, ok = p.Default.(float64)
+			case ParamBool:
+				_, ok = p.Default.(bool)
+			case ParamString:
+				_, ok = p.Default.(string)
+			}
+			if !ok {
+				t.Errorf("default value %v of %v parameter %q is not of type %v", p.Default, a.Name, p.Name, p.Type)
+			}
+		}
+		if _, err := a.ParseParams(nil); err != nil {
+			t.Errorf("%v: %v", a.Name, err)
+		}
+	}
+}
+
+func TestRegisterAlgorithm(t *testing.T) {
+	search := func(context.Context, domain.Searcher, []*domain.Source, domain.Searcher, *Config) ([]*domain.Clone, error) {
+		return nil, nil
+	}
+	tests := []struct {
+		name        string
+		algorithm   *Algorithm
+		errContains string
+	}{
+		{"builtin name", &Algorithm{Name: "fleccs", Search: search}, "already registered"},
+		{"exec name", &Algorithm{Name: ExecPrefix + "foo", Search: search}, "invalid algorithm name"},
+		{"no search", &Algorithm{Name: "test-no-search"}, "no Search function"},
+		{"invalid default", &Algorithm{Name: "test-invalid-default", Search: search, Params: []*Param{
+			{Name: "threshold", Type: ParamFloat, Min: 0, Max: 1, Default: 2.0},
+		}}, `default value 2 of parameter "threshold"`},
+		{"duplicate param", &Algorithm{Name: "test-duplicate-param", Search: search, Params: []*Param{
+			{Name: "normalize", Type: ParamBool, Default: true},
+			{Name: "normalize", Type: ParamBool, Default: false},
+		}}, `declares parameter "normalize" more than once`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RegisterAlgorithm(tt.algorithm)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("expected error to contain %q, got %q", tt.errContains, err.Error())
+			}
+		})
+	}
+
+	a := &Algorithm{Name: "test-registered", Search: search, Params: []*Param{
+		{Name: "size", Type: ParamInt, Min: 1, Max: math.Inf(1), Default: 5},
+	}}
+	if err := RegisterAlgorithm(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateParam("test-registered", "size", "0"); err == nil {
+		t.Errorf("expected error for out-of-range parameter of registered algorithm")
+	}
+	if !slices.Contains(AlgorithmNames(), "test-registered") {
+		t.Errorf("expected registered algorithm to be listed, got %v", AlgorithmNames())
+	}
+}
@@ -3,6 +3,7 @@ package search
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"path/filepath"
 	"slices"
@@ -10,8 +11,10 @@ import (
 
 	"github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/pkg/errors"
+	"github.com/salab/iccheck/pkg/diskcache"
 	"github.com/salab/iccheck/pkg/domain"
 	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/salab/iccheck/pkg/utils/files"
 	"github.com/samber/lo"
 	"github.com/theodesp/unionfind"
 )
@@ -70,6 +73,19 @@ func (c *chunk) searchQuery() []*domain.Source {
 	}
 }
 
+// deletedSource returns the deleted region in the base tree,
+// if this chunk was a deletion or a modification (whose deleted half is the code before the modification).
+func (c *chunk) deletedSource() (*domain.Source, bool) {
+	if c.kind != changeKindDeletion && c.kind != changeKindModification {
+		return nil, false
+	}
+	return &domain.Source{
+		Filename: c.beforeFilename,
+		StartL:   c.beforeStartL,
+		EndL:     c.beforeEndL,
+	}, true
+}
+
 type chunkTracker struct {
 	beforeFilename string
 	afterFilename  string
@@ -224,8 +240,59 @@ func DiffTrees(
 	if err != nil {
 		return nil, 0, errors.Wrap(err, "diffing trees")
 	}
+	return PatchQueries(filePatches), len(filePatches), nil
+}
+
+// PatchQueries calculates queries to search in the target tree from the file patches, such as by domain.DiffTrees.
+func PatchQueries(filePatches []diff.FilePatch) []*domain.Source {
+	return ds.FlatMap(targetChunks(filePatchesToChunks(filePatches)), (*chunk).searchQuery)
+}
+
+// targetChunks filters chunks that exist in the target tree - that is, chunks of deleted files are excluded.
+func targetChunks(chunks []*chunk) []*chunk {
+	return lo.Filter(chunks, func(c *chunk, _ int) bool { return c.afterFilename != "" })
+}
+
+// DiffPatch reads unified diff from the given reader, and calculates queries to search in the target tree.
+// The given patch is expected to be already applied to the target tree.
+// strip specifies the number of leading path components to remove, like 'patch -p'.
+func DiffPatch(
+	_ context.Context,
+	patch io.Reader,
+	strip int,
+	toTree domain.Tree,
+) ([]*domain.Source, int, error) {
+	filePatches, err := domain.ParseUnifiedDiff(patch, strip)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "parsing patch")
+	}
+
+	// Prepare queries
+	queries := ds.FlatMap(targetChunks(filePatchesToChunks(filePatches)), (*chunk).searchQuery)
+
+	// Check that the patch matches the target tree, since queries are read from the target tree
+	lineCounts := make(map[string]int)
+	for _, q := range queries {
+		lines, ok := lineCounts[q.Filename]
+		if !ok {
+			content, err := files.ReadAll(toTree.Reader(q.Filename))
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "reading %v from target tree, does the patch apply to %v?", q.Filename, toTree)
+			}
+			lines = len(files.LineStartIndices(content))
+			lineCounts[q.Filename] = lines
+		}
+		if q.EndL > lines {
+			return nil, 0, errors.Errorf("patch refers to L%d of %v, but it only has %d lines in %v - does the patch apply?", q.EndL, q.Filename, lines, toTree)
+		}
+	}
+
+	return queries, len(filePatches), nil
+}
 
-	// Calculate the chunks
+// filePatchesToChunks categorizes file patch chunks (equal, add, delete) into
+// patch chunks (add, delete, modification).
+func filePatchesToChunks(filePatches []diff.FilePatch) []*chunk {
 	var patchChunks []*chunk
 	for _, filePatch := range filePatches {
 		from, to := filePatch.Files()
@@ -236,18 +303,17 @@ func DiffTrees(
 			continue
 		}
 
-		// Only handle file modifications (or renames) and additions
+		// Handle file modifications (or renames), additions and deletions
 		// When handling file additions, target lines are the added lines itself
-		if to == nil {
-			// File was deleted
-			continue
-		}
-
+		// When handling file deletions, afterFilename is left empty
 		// Windows support: We need to use filepath.Clean here, because Path() git may return "/"-delimited path
-		tracker := &chunkTracker{afterFilename: filepath.Clean(to.Path())}
+		tracker := &chunkTracker{}
 		if from != nil {
 			tracker.beforeFilename = filepath.Clean(from.Path())
 		}
+		if to != nil {
+			tracker.afterFilename = filepath.Clean(to.Path())
+		}
 
 		// Categorize file patch chunks (equal, add, delete) into
 		// patch categories (add, delete, modification)
@@ -313,15 +379,46 @@ func DiffTrees(
 		patchChunks = append(patchChunks, tracker.patches()...)
 	}
 
-	// Prepare queries
-	queries := ds.FlatMap(patchChunks, (*chunk).searchQuery)
-	return queries, len(filePatches), nil
+	return patchChunks
 }
 
 type Config struct {
+	// Cache is the persistent cache, which may be nil
+	Cache       *diskcache.Cache
 	Matcher     *domain.MatcherRules
 	DetectMicro bool
-	AlgoParams  map[string]string
+	// SnapBoundaries expands or shrinks detected clones to enclosing blocks, so that they do not cut functions in half
+	SnapBoundaries bool
+	// PartialResults continues with the clones found so far when ctx is done (such as by a timeout),
+	// instead of failing. Results are returned together with *domain.IncompleteError in that case.
+	PartialResults bool
+	AlgoParams     map[string]string
+}
+
+func runAlgorithm(
+	ctx context.Context,
+	algorithmName string,
+	sourceTree domain.Searcher,
+	sources []*domain.Source,
+	searchTree domain.Searcher,
+	c *Config,
+) ([]*domain.Clone, error) {
+	algorithm, err := GetAlgorithm(algorithmName)
+	if err != nil {
+		return nil, err
+	}
+	clones, err := algorithm.Search(ctx, sourceTree, sources, searchTree, c)
+	if incomplete, ok := domain.AsIncomplete(err); ok && c.PartialResults {
+		slog.Warn(fmt.Sprintf("Search was interrupted, continuing with partial results: %v", incomplete.Coverage))
+		return clones, incomplete
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("timed out searching for clones, try increasing timeout with --timeout-seconds or ICCHECK_TIMEOUT_SECONDS")
+		}
+		return nil, errors.Wrap(err, "searching for clones")
+	}
+	return clones, nil
 }
 
 func Search(
@@ -339,24 +436,31 @@ func Search(
 	}
 
 	// Search for clones
-	algorithmFn, ok := algorithms[algorithmName]
-	if !ok {
-		return nil, fmt.Errorf("invalid algorithm name: %v", algorithmName)
-	}
 	searcher := domain.NewSearcherFromTree(searchTree)
-	clones, err := algorithmFn(ctx, searcher, queries, searcher, c)
+	searchQueries, err := excludeSuppressedLines(searcher, queries)
 	if err != nil {
-		if errors.Is(err, context.Canceled) {
-			return nil, fmt.Errorf("timed out searching for clones, try increasing timeout with --timeout-seconds or ICCHECK_TIMEOUT_SECONDS")
-		}
-		return nil, errors.Wrap(err, "searching for clones")
+		return nil, err
+	}
+	clones, err := runAlgorithm(ctx, algorithmName, searcher, searchQueries, searcher, c)
+	incomplete, isIncomplete := domain.AsIncomplete(err)
+	if err != nil && !isIncomplete {
+		return nil, err
 	}
 
 	// Deduplicate overlapping clones
 	clones = dedupeDetectedClones(clones)
 
+	// Snap clones to block boundaries, which may make them overlap again
+	if c.SnapBoundaries {
+		clones, err = snapCloneBoundaries(searcher, clones)
+		if err != nil {
+			return nil, err
+		}
+		clones = dedupeDetectedClones(clones)
+	}
+
 	// Calculate clone sets
-	rawCloneSets := findCloneSets(clones, queries)
+	rawCloneSets := findCloneSets(clones, searchQueries)
 
 	// Calculate inconsistent changes by listing clones not modified by this patch
 	cloneSets := filterMissingChanges(rawCloneSets, queries)
@@ -371,5 +475,50 @@ func Search(
 	}
 
 	// Return the inconsistent changes found
+	if isIncomplete {
+		return cloneSets, incomplete
+	}
 	return cloneSets, nil
 }
+
+// excludeSuppressedLines removes lines suppressed by inline suppression comments (see domain.SuppressedLines)
+// from queries, splitting queries if necessary.
+func excludeSuppressedLines(sourceTree domain.Searcher, queries []*domain.Source) ([]*domain.Source, error) {
+	suppressedPerFile := make(map[string]map[int]struct{})
+	ret := make([]*domain.Source, 0, len(queries))
+	for _, q := range queries {
+		suppressed, ok := suppressedPerFile[q.Filename]
+		if !ok {
+			file, err := sourceTree.Open(q.Filename)
+			if err != nil {
+				return nil, errors.Wrapf(err, "opening query file %v", q.Filename)
+			}
+			content, err := file.Content()
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading query file %v", q.Filename)
+			}
+			suppressed = domain.SuppressedLines(q.Filename, content)
+			suppressedPerFile[q.Filename] = suppressed
+		}
+		if len(suppressed) == 0 {
+			ret = append(ret, q)
+			continue
+		}
+
+		startL := -1
+		for l := q.StartL; l <= q.EndL+1; l++ {
+			_, isSuppressed := suppressed[l-1] // 0-indexed
+			if l <= q.EndL && !isSuppressed {
+				if startL < 0 {
+					startL = l
+				}
+				continue
+			}
+			if startL >= 0 {
+				ret = append(ret, &domain.Source{Filename: q.Filename, StartL: startL, EndL: l - 1})
+				startL = -1
+			}
+		}
+	}
+	return ret, nil
+}
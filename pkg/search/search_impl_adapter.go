@@ -2,13 +2,17 @@ package search
 
 import (
 	"context"
+	"math"
 	"strconv"
+	"sync"
 
-	"github.com/pkg/errors"
+	"github.com/salab/iccheck/pkg/astsearch"
 	"github.com/salab/iccheck/pkg/domain"
 	"github.com/salab/iccheck/pkg/fleccs"
 	"github.com/salab/iccheck/pkg/ncdsearch"
+	"github.com/salab/iccheck/pkg/tokensearch"
 	"github.com/salab/iccheck/pkg/utils/ds"
+	"github.com/samber/lo"
 )
 
 type AlgorithmFunc = func(
@@ -19,9 +23,91 @@ type AlgorithmFunc = func(
 	c *Config,
 ) ([]*domain.Clone, error)
 
-var algorithms = map[string]AlgorithmFunc{
-	"fleccs":    fleccsSearchMulti,
-	"ncdsearch": ncdSearchReImpl,
+// algorithms are the registered algorithms, keyed by name.
+var (
+	algorithms     = make(map[string]*Algorithm)
+	algorithmsLock sync.RWMutex
+)
+
+// Registered in init, since the algorithm functions look up their own parameters.
+// ensemble is registered last, as it validates names of the other algorithms.
+func init() {
+	for _, a := range []*Algorithm{
+		{
+			Name:        "fleccs",
+			Description: "Line-based search of similar lines, with context lines around changes (FLeCCS).",
+			Params: []*Param{
+				{Name: "threshold", Type: ParamFloat, Min: 0, Max: 1, Default: fleccs.DefaultSimilarityThreshold,
+					Description: "Threshold for detecting clones."},
+				{Name: "context-lines", Type: ParamInt, Min: 0, Max: math.Inf(1), Default: fleccs.DefaultContextLines,
+					Description: "Number of context lines to use for detecting clones (0 for 'iccheck clones' by default)."},
+			},
+			Search: fleccsSearchMulti,
+		},
+		{
+			Name:        "ncdsearch",
+			Description: "Search by normalized compression distance (re-implementation of NCDSearch).",
+			Params: []*Param{
+				{Name: "overlap-ngram", Type: ParamInt, Min: 1, Max: math.Inf(1), Default: ncdsearch.DefaultOverlapNGram,
+					Description: "Number of n-grams to use for detecting clones."},
+				{Name: "filter-threshold", Type: ParamFloat, Min: 0, Max: 1, Default: ncdsearch.DefaultFilterThreshold,
+					Description: "Threshold for filtering out clones."},
+				{Name: "threshold", Type: ParamFloat, Min: 0, Max: 1, Default: 0.3,
+					Description: "Threshold for detecting clones."},
+				{Name: "window-size-mult", Type: ParamFloat, Min: 1, Max: math.Inf(1), Default: ncdsearch.DefaultWindowSizeMultiplier,
+					Description: "Window size multiplier."},
+			},
+			Search: ncdSearchReImpl,
+		},
+		{
+			Name:        "token",
+			Description: "Search of common token n-grams, detecting clones with renamed identifiers (Type-2 clones).",
+			Params: []*Param{
+				{Name: "threshold", Type: ParamFloat, Min: 0, Max: 1, Default: tokensearch.DefaultSearchThreshold,
+					Description: "Minimum ratio of common token n-grams for detecting clones."},
+				{Name: "ngram", Type: ParamInt, Min: 1, Max: math.Inf(1), Default: tokensearch.DefaultNGram,
+					Description: "Length of token n-grams to compare."},
+				{Name: "min-tokens", Type: ParamInt, Min: 0, Max: math.Inf(1), Default: tokensearch.DefaultMinTokens,
+					Description: "Queries with fewer tokens are not searched."},
+				{Name: "normalize", Type: ParamBool, Default: tokensearch.DefaultNormalize,
+					Description: "Normalize identifiers and literals, to detect clones with renamed variables."},
+				{Name: "context-lines", Type: ParamInt, Min: 0, Max: math.Inf(1), Default: tokensearch.DefaultContextLines,
+					Description: "Number of context lines to use for detecting clones (0 for 'iccheck clones' by default)."},
+			},
+			Search: tokenSearch,
+		},
+		{
+			Name:        "goast",
+			Description: "Search of similar syntax trees in Go files, reporting clones on statement boundaries. Other files are searched by fleccs.",
+			Params: []*Param{
+				{Name: "threshold", Type: ParamFloat, Min: 0, Max: 1, Default: astsearch.DefaultSimilarityThreshold,
+					Description: "Minimum similarity of syntax trees for detecting clones."},
+				{Name: "min-nodes", Type: ParamInt, Min: 0, Max: math.Inf(1), Default: astsearch.DefaultMinNodes,
+					Description: "Changes with fewer syntax tree nodes are expanded to the enclosing statement."},
+				{Name: "normalize", Type: ParamBool, Default: astsearch.DefaultNormalize,
+					Description: "Normalize local identifiers and literals, to detect clones with renamed variables."},
+				{Name: "context-lines", Type: ParamInt, Min: 0, Max: math.Inf(1), Default: fleccs.DefaultContextLines,
+					Description: "Number of context lines to use for non-Go files searched by fleccs."},
+			},
+			Search: goASTSearch,
+		},
+		{
+			Name:        ensembleName,
+			Description: "Runs several algorithms concurrently, and merges overlapping clones by voting. Parameters of each algorithm are given as '<algorithm>.<name>'.",
+			Params: []*Param{
+				{Name: "algorithms", Type: ParamString, Default: "fleccs,ncdsearch", Validate: validateEnsembleMembers, ListsAlgorithms: true,
+					Description: "Comma-separated names of algorithms to run."},
+				{Name: "vote", Type: ParamString, Default: voteUnion, Validate: validateVote,
+					Description: "Keeps clones found by any algorithm (union), by all algorithms (intersection), or by at least k algorithms (k)."},
+				{Name: "fusion", Type: ParamString, Default: "mean", Validate: validateFusion,
+					Description: "How to fuse distances given by the algorithms into one (mean, min, max). Distances are not normalized across algorithms."},
+			},
+			NestedParams: true,
+			Search:       ensembleSearch,
+		},
+	} {
+		lo.Must0(RegisterAlgorithm(a))
+	}
 }
 
 func ncdSearchReImpl(
@@ -39,37 +125,16 @@ func ncdSearchReImpl(
 		}
 	})
 
-	var opts []ncdsearch.ConfigFunc
-	opts = append(opts, ncdsearch.WithSearchThreshold(0.3))
-
-	// Algorithm parameters
-	if v, ok := c.AlgoParams["overlap-ngram"]; ok {
-		i, err := strconv.Atoi(v)
-		if err != nil {
-			return nil, errors.Wrapf(err, "could not parse overlap-ngram")
-		}
-		opts = append(opts, ncdsearch.WithOverlapNGram(i))
-	}
-	if v, ok := c.AlgoParams["filter-threshold"]; ok {
-		f, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not parse filter-threshold")
-		}
-		opts = append(opts, ncdsearch.WithFilterThreshold(f))
+	params, err := parseParams("ncdsearch", c)
+	if err != nil {
+		return nil, err
 	}
-	if v, ok := c.AlgoParams["threshold"]; ok {
-		f, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not parse threshold")
-		}
-		opts = append(opts, ncdsearch.WithSearchThreshold(f))
-	}
-	if v, ok := c.AlgoParams["window-size-mult"]; ok {
-		f, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not parse window-size-mult")
-		}
-		opts = append(opts, ncdsearch.WithWindowSizeMultiplier(f))
+	opts := []ncdsearch.ConfigFunc{
+		ncdsearch.WithPartialResults(c.PartialResults),
+		ncdsearch.WithOverlapNGram(params.Int("overlap-ngram")),
+		ncdsearch.WithFilterThreshold(params.Float("filter-threshold")),
+		ncdsearch.WithSearchThreshold(params.Float("threshold")),
+		ncdsearch.WithWindowSizeMultiplier(params.Float("window-size-mult")),
 	}
 
 	clones, err := ncdsearch.Search(
@@ -80,7 +145,7 @@ func ncdSearchReImpl(
 		c.Matcher,
 		opts...,
 	)
-	if err != nil {
+	if _, ok := domain.AsIncomplete(err); err != nil && !ok {
 		return nil, err
 	}
 
@@ -90,8 +155,13 @@ func ncdSearchReImpl(
 			StartL:   c.StartLine,
 			EndL:     c.EndLine,
 			Distance: c.Distance,
+			Sources: []*domain.Source{{
+				Filename: c.Source.Filename,
+				StartL:   c.Source.StartL,
+				EndL:     c.Source.EndL,
+			}},
 		}
-	}), nil
+	}), err
 }
 
 func fleccsSearchMulti(
@@ -109,25 +179,73 @@ func fleccsSearchMulti(
 		}
 	})
 
-	var opts []fleccs.ConfigFunc
+	params, err := parseParams("fleccs", c)
+	if err != nil {
+		return nil, err
+	}
+	opts := []fleccs.ConfigFunc{
+		fleccs.WithDiskCache(c.Cache),
+		fleccs.WithPartialResults(c.PartialResults),
+		fleccs.WithSimilarityThreshold(params.Float("threshold")),
+		fleccs.WithContextLines(params.Int("context-lines")),
+	}
 
-	// Algorithm parameters
-	if v, ok := c.AlgoParams["threshold"]; ok {
-		f, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not parse threshold")
-		}
-		opts = append(opts, fleccs.WithSimilarityThreshold(f))
+	candidates, err := fleccs.Search(
+		ctx,
+		sourceTree,
+		queries,
+		searchTree,
+		c.Matcher,
+		opts...,
+	)
+	if _, ok := domain.AsIncomplete(err); err != nil && !ok {
+		return nil, err
 	}
-	if v, ok := c.AlgoParams["context-lines"]; ok {
-		i, err := strconv.Atoi(v)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not parse context-lines")
+
+	return ds.Map(candidates, func(c *fleccs.Candidate) *domain.Clone {
+		return &domain.Clone{
+			Filename: c.Filename,
+			StartL:   c.StartLine,
+			EndL:     c.EndLine,
+			Distance: 1 - c.Similarity,
+			Sources: []*domain.Source{{
+				Filename: c.Source.Filename,
+				StartL:   c.Source.StartL,
+				EndL:     c.Source.EndL,
+			}},
+		}
+	}), err
+}
+
+func tokenSearch(
+	ctx context.Context,
+	sourceTree domain.Searcher,
+	sources []*domain.Source,
+	searchTree domain.Searcher,
+	c *Config,
+) ([]*domain.Clone, error) {
+	queries := ds.Map(sources, func(s *domain.Source) *tokensearch.Query {
+		return &tokensearch.Query{
+			Filename: s.Filename,
+			StartL:   s.StartL,
+			EndL:     s.EndL,
 		}
-		opts = append(opts, fleccs.WithContextLines(i))
+	})
+
+	params, err := parseParams("token", c)
+	if err != nil {
+		return nil, err
+	}
+	opts := []tokensearch.ConfigFunc{
+		tokensearch.WithPartialResults(c.PartialResults),
+		tokensearch.WithSearchThreshold(params.Float("threshold")),
+		tokensearch.WithNGram(params.Int("ngram")),
+		tokensearch.WithMinTokens(params.Int("min-tokens")),
+		tokensearch.WithNormalize(params.Bool("normalize")),
+		tokensearch.WithContextLines(params.Int("context-lines")),
 	}
 
-	candidates, err := fleccs.Search(
+	clones, err := tokensearch.Search(
 		ctx,
 		sourceTree,
 		queries,
@@ -135,11 +253,64 @@ func fleccsSearchMulti(
 		c.Matcher,
 		opts...,
 	)
+	if _, ok := domain.AsIncomplete(err); err != nil && !ok {
+		return nil, err
+	}
+
+	return ds.Map(clones, func(c *tokensearch.Clone) *domain.Clone {
+		return &domain.Clone{
+			Filename: c.Filename,
+			StartL:   c.StartLine,
+			EndL:     c.EndLine,
+			Distance: 1 - c.Similarity,
+			Sources: []*domain.Source{{
+				Filename: c.Source.Filename,
+				StartL:   c.Source.StartL,
+				EndL:     c.Source.EndL,
+			}},
+		}
+	}), err
+}
+
+// goASTSearch searches Go files by syntax trees, and falls back to fleccs for the other files.
+func goASTSearch(
+	ctx context.Context,
+	sourceTree domain.Searcher,
+	sources []*domain.Source,
+	searchTree domain.Searcher,
+	c *Config,
+) ([]*domain.Clone, error) {
+	queries := ds.Map(sources, func(s *domain.Source) *astsearch.Query {
+		return &astsearch.Query{
+			Filename: s.Filename,
+			StartL:   s.StartL,
+			EndL:     s.EndL,
+		}
+	})
+
+	params, err := parseParams("goast", c)
 	if err != nil {
 		return nil, err
 	}
+	opts := []astsearch.ConfigFunc{
+		astsearch.WithPartialResults(c.PartialResults),
+		astsearch.WithSimilarityThreshold(params.Float("threshold")),
+		astsearch.WithMinNodes(params.Int("min-nodes")),
+		astsearch.WithNormalize(params.Bool("normalize")),
+	}
 
-	return ds.Map(candidates, func(c *fleccs.Candidate) *domain.Clone {
+	clones, unsupported, err := astsearch.Search(
+		ctx,
+		sourceTree,
+		queries,
+		searchTree,
+		c.Matcher,
+		opts...,
+	)
+	if _, ok := domain.AsIncomplete(err); err != nil && !ok {
+		return nil, err
+	}
+	results := ds.Map(clones, func(c *astsearch.Clone) *domain.Clone {
 		return &domain.Clone{
 			Filename: c.Filename,
 			StartL:   c.StartLine,
@@ -151,5 +322,34 @@ func fleccsSearchMulti(
 				EndL:     c.Source.EndL,
 			}},
 		}
-	}), nil
+	})
+
+	// Fallback
+	if len(unsupported) > 0 {
+		// Parameters are specific to each algorithm - use the default parameters of fleccs
+		fallbackConf := *c
+		fallbackConf.AlgoParams = map[string]string{"context-lines": strconv.Itoa(params.Int("context-lines"))}
+		fallbackSources := ds.Map(unsupported, func(q *astsearch.Query) *domain.Source {
+			return &domain.Source{
+				Filename: q.Filename,
+				StartL:   q.StartL,
+				EndL:     q.EndL,
+			}
+		})
+		fallbackClones, fallbackErr := fleccsSearchMulti(ctx, sourceTree, fallbackSources, searchTree, &fallbackConf)
+		fallbackIncomplete, ok := domain.AsIncomplete(fallbackErr)
+		if fallbackErr != nil && !ok {
+			return nil, fallbackErr
+		}
+		results = append(results, fallbackClones...)
+		if ok {
+			// Report coverage of both searches
+			coverage := fallbackIncomplete.Coverage
+			if incomplete, ok := domain.AsIncomplete(err); ok {
+				coverage = coverage.Add(incomplete.Coverage)
+			}
+			err = &domain.IncompleteError{Coverage: coverage, Err: fallbackIncomplete.Err}
+		}
+	}
+	return results, err
 }
@@ -0,0 +1,81 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+// searchTestFunc is a function of 9 lines, whose loop (L3-L7 of the function) is copied in searchTestFiles.
+const searchTestFunc = `func sum%[1]s(values []int) int {
+	total := 0
+	for _, v := range values {
+		if v > 0 {
+			total += v
+		}
+	}
+	return total
+}
+`
+
+// searchTestFiles returns files with copies of searchTestFunc at a.go:L3-L11, a.go:L18-L26 and b/b.go:L5-L13.
+func searchTestFiles() map[string]string {
+	return map[string]string{
+		"a.go": "package a\n\n" + fmt.Sprintf(searchTestFunc, "A") +
+			"\nfunc hello() {\n\tprintln(\"hello, world\")\n\tos.Exit(1)\n}\n\n" +
+			fmt.Sprintf(searchTestFunc, "B"),
+		"b/b.go": "package b\n\nimport \"os\"\n\n" + fmt.Sprintf(searchTestFunc, "C"),
+		"c.go":   "package c\n\nvar x = map[string]int{\"a\": 1, \"b\": 2}\n",
+	}
+}
+
+func searchTestTree(t *testing.T, files map[string]string) domain.Tree {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestSearch_CloneSets(t *testing.T) {
+	tree := searchTestTree(t, searchTestFiles())
+	// Clones are grouped into clone sets by the queries they were found from, so every algorithm must report Sources
+	for _, algorithm := range []string{"ncdsearch", "token"} {
+		t.Run(algorithm, func(t *testing.T) {
+			// The loop of sumA
+			queries := []*domain.Source{{Filename: "a.go", StartL: 5, EndL: 9}}
+			sets, err := Search(context.Background(), algorithm, queries, tree, &Config{Matcher: domain.DefaultMatcherRules()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sets) != 1 {
+				t.Fatalf("expected 1 clone set, got %d", len(sets))
+			}
+			set := sets[0]
+			if len(set.Changed) != 1 || len(set.Missing) != 2 {
+				t.Fatalf("expected 1 changed and 2 missing clones, got %v and %v", set.Changed, set.Missing)
+			}
+			// The loops of sumB and sumC
+			for i, want := range []*domain.Source{{Filename: "a.go", StartL: 20, EndL: 24}, {Filename: "b/b.go", StartL: 7, EndL: 11}} {
+				if m := set.Missing[i]; m.Filename != want.Filename || m.StartL > want.StartL || m.EndL < want.EndL {
+					t.Errorf("expected clone around %v:L%d-L%d to be missing, got %v:L%d-L%d", want.Filename, want.StartL, want.EndL, m.Filename, m.StartL, m.EndL)
+				}
+			}
+		})
+	}
+}
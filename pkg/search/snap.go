@@ -0,0 +1,186 @@
+package search
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+// lineLevel is the nesting level of a line, estimated from brackets and indentation.
+type lineLevel struct {
+	blank bool
+	// closerOnly is true if the line only consists of closing brackets (such as "}" or "})"), which ends a block
+	closerOnly bool
+	// depth is the bracket depth at the start of the line, after leading closing brackets
+	depth int
+	// indent is the indentation width, for languages without brackets (such as Python) and continuation lines
+	indent int
+}
+
+// cmp compares nesting levels - a positive value means l is nested deeper than o.
+func (l lineLevel) cmp(o lineLevel) int {
+	if l.depth != o.depth {
+		return l.depth - o.depth
+	}
+	return l.indent - o.indent
+}
+
+func isOpener(c byte) bool { return c == '{' || c == '(' || c == '[' }
+func isCloser(c byte) bool { return c == '}' || c == ')' || c == ']' }
+
+// lineLevels calculates the nesting level of each line of the file.
+// This is a heuristic working for most languages, and does not precisely parse strings or comments.
+func lineLevels(content []byte) []lineLevel {
+	lines := strings.Split(string(content), "\n")
+	levels := make([]lineLevel, len(lines))
+	depth := 0
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			levels[i] = lineLevel{blank: true}
+			continue
+		}
+
+		indent := 0
+		for _, c := range line[:len(line)-len(strings.TrimLeft(line, " \t"))] {
+			indent += lo.Ternary(c == '\t', 4, 1)
+		}
+		leadingClosers := len(trimmed) - len(strings.TrimLeftFunc(trimmed, func(r rune) bool { return r < 0x80 && isCloser(byte(r)) }))
+		levels[i] = lineLevel{
+			closerOnly: strings.Trim(trimmed, "})];,") == "",
+			depth:      max(0, depth-leadingClosers),
+			indent:     indent,
+		}
+
+		// Count brackets outside of strings and line comments
+		var quote byte
+		for j := 0; j < len(trimmed); j++ {
+			c := trimmed[j]
+			switch {
+			case quote != 0:
+				if c == '\\' {
+					j++
+				} else if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'' || c == '`':
+				quote = c
+			case c == '#', c == '/' && j+1 < len(trimmed) && trimmed[j+1] == '/':
+				j = len(trimmed)
+			case isOpener(c):
+				depth++
+			case isCloser(c):
+				depth = max(0, depth-1)
+			}
+		}
+	}
+	return levels
+}
+
+// snapRegion expands or shrinks the region (1-indexed lines, inclusive) so that it does not cut blocks in half,
+// choosing whichever changes fewer lines at each end.
+func snapRegion(levels []lineLevel, startL, endL int) (int, int) {
+	at := func(l int) lineLevel { return levels[l-1] }
+	nonBlank := func(l, step int) int {
+		for 1 <= l && l <= len(levels) && at(l).blank {
+			l += step
+		}
+		return l
+	}
+	inFile := func(l int) bool { return 1 <= l && l <= len(levels) }
+
+	origStartL, origEndL := startL, endL
+	startL, endL = nonBlank(startL, 1), nonBlank(endL, -1)
+	if startL > endL {
+		return origStartL, origEndL
+	}
+	baseLevel := func(startL, endL int) lineLevel {
+		base := at(startL)
+		for l := startL; l <= endL; l++ {
+			if !at(l).blank && at(l).cmp(base) < 0 {
+				base = at(l)
+			}
+		}
+		return base
+	}
+
+	// Start: the region starts in the middle of a block (or with the end of a block)
+	base := baseLevel(startL, endL)
+	if at(startL).cmp(base) > 0 || at(startL).closerOnly {
+		expandL := startL - 1
+		for inFile(expandL) && (at(expandL).blank || at(expandL).cmp(base) > 0) {
+			expandL--
+		}
+		shrinkL := startL
+		for shrinkL <= endL && (at(shrinkL).blank || at(shrinkL).cmp(base) > 0 || at(shrinkL).closerOnly) {
+			shrinkL++
+		}
+		canExpand := inFile(expandL) && !at(startL).closerOnly
+		canShrink := shrinkL <= endL
+		switch {
+		case canExpand && (!canShrink || startL-expandL <= shrinkL-startL):
+			startL = expandL
+		case canShrink:
+			startL = shrinkL
+		}
+	}
+
+	// End: the region ends in the middle of a block
+	base = baseLevel(startL, endL)
+	next := nonBlank(endL+1, 1)
+	if inFile(next) && (at(next).cmp(base) > 0 || at(endL).cmp(base) > 0 && at(next).closerOnly && at(next).cmp(base) == 0) {
+		expandL := next
+		if at(next).cmp(base) > 0 {
+			for inFile(expandL+1) && (at(expandL+1).blank || at(expandL+1).cmp(base) > 0) {
+				expandL++
+			}
+			expandL = nonBlank(expandL, -1)
+			// Include the closing bracket of the block
+			if afterL := nonBlank(expandL+1, 1); inFile(afterL) && at(afterL).closerOnly && at(afterL).cmp(base) == 0 {
+				expandL = afterL
+			}
+		}
+		shrinkL := endL
+		for shrinkL >= startL && (at(shrinkL).blank || at(shrinkL).cmp(base) > 0) {
+			shrinkL--
+		}
+		// Exclude the header of the cut block too
+		shrinkL = nonBlank(shrinkL-1, -1)
+		canShrink := shrinkL >= startL
+		if !canShrink || expandL-endL <= endL-shrinkL {
+			endL = expandL
+		} else {
+			endL = shrinkL
+		}
+	}
+
+	return startL, endL
+}
+
+// snapCloneBoundaries expands or shrinks clones to enclosing blocks.
+func snapCloneBoundaries(searcher domain.Searcher, clones []*domain.Clone) ([]*domain.Clone, error) {
+	levelsCache := make(map[string][]lineLevel)
+	for _, c := range clones {
+		levels, ok := levelsCache[c.Filename]
+		if !ok {
+			f, err := searcher.Open(c.Filename)
+			if err != nil {
+				return nil, errors.Wrapf(err, "opening %v", c.Filename)
+			}
+			content, err := f.Content()
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading %v", c.Filename)
+			}
+			levels = lineLevels(content)
+			levelsCache[c.Filename] = levels
+		}
+		if c.EndL > len(levels) {
+			continue
+		}
+		c.StartL, c.EndL = snapRegion(levels, c.StartL, c.EndL)
+	}
+	return clones, nil
+}
@@ -0,0 +1,47 @@
+package search
+
+import "testing"
+
+func TestSnapRegion(t *testing.T) {
+	const goContent = `func a() {
+	x := 1
+	if x > 0 {
+		y()
+	}
+	z()
+}
+
+func b() {
+	w()
+}
+`
+	const pyContent = `def f(x):
+    if x:
+        g()
+    return x
+
+def h():
+    pass
+`
+	tests := []struct {
+		name         string
+		content      string
+		startL, endL int
+		wantS, wantE int
+	}{
+		{"starts in the middle of a block", goContent, 4, 6, 3, 6},
+		{"ends with a block header", goContent, 2, 3, 2, 2},
+		{"spans two functions", goContent, 6, 10, 9, 11},
+		{"does not cut blocks", goContent, 2, 5, 2, 5},
+		{"indentation block", pyContent, 3, 4, 2, 4},
+		{"spans two indentation blocks", pyContent, 4, 6, 6, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, e := snapRegion(lineLevels([]byte(tt.content)), tt.startL, tt.endL)
+			if s != tt.wantS || e != tt.wantE {
+				t.Errorf("expected L%d-L%d, got L%d-L%d", tt.wantS, tt.wantE, s, e)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package tokensearch
+
+const DefaultNGram = 3
+const DefaultSearchThreshold = 0.8
+const DefaultMinTokens = 5
+const DefaultNormalize = true
+const DefaultContextLines = 4
+
+type config struct {
+	nGram           int
+	searchThreshold float64
+	minTokens       int
+	normalize       bool
+	contextLines    int
+	partialResults  bool
+}
+
+func defaultConfig() *config {
+	return &config{
+		nGram:           DefaultNGram,
+		searchThreshold: DefaultSearchThreshold,
+		minTokens:       DefaultMinTokens,
+		normalize:       DefaultNormalize,
+		contextLines:    DefaultContextLines,
+	}
+}
+
+func applyConfig(options ...ConfigFunc) *config {
+	c := defaultConfig()
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+type ConfigFunc func(c *config)
+
+// WithNGram sets the length of token n-grams to compare.
+func WithNGram(nGram int) ConfigFunc {
+	return func(c *config) {
+		c.nGram = nGram
+	}
+}
+
+// WithSearchThreshold sets the minimum similarity (0 to 1) of token n-grams to detect as clones.
+func WithSearchThreshold(searchThreshold float64) ConfigFunc {
+	return func(c *config) {
+		c.searchThreshold = searchThreshold
+	}
+}
+
+// WithMinTokens sets the minimum number of tokens of queries.
+// Shorter queries (such as a single closing brace) are not searched, since they would match too many locations.
+func WithMinTokens(minTokens int) ConfigFunc {
+	return func(c *config) {
+		c.minTokens = minTokens
+	}
+}
+
+// WithNormalize sets whether to normalize identifiers and literals.
+func WithNormalize(normalize bool) ConfigFunc {
+	return func(c *config) {
+		c.normalize = normalize
+	}
+}
+
+// WithContextLines sets the number of lines before and after queries to include in comparison.
+// Context lines help detecting clones of small changes, such as a single modified line.
+func WithContextLines(contextLines int) ConfigFunc {
+	return func(c *config) {
+		c.contextLines = contextLines
+	}
+}
+
+// WithPartialResults sets whether to return results of the files already searched, when ctx is done.
+// See domain.SearchFiles.
+func WithPartialResults(partial bool) ConfigFunc {
+	return func(c *config) {
+		c.partialResults = partial
+	}
+}
@@ -0,0 +1,157 @@
+package tokensearch
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenIdentifier tokenKind = iota
+	tokenKeyword
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// line is the 1-indexed line number where the token starts
+	line int
+}
+
+// normalized returns the token text for comparison.
+// Identifiers and literals are replaced by placeholders, so that clones with renamed variables (Type-2 clones) match.
+func (t *token) normalized() string {
+	switch t.kind {
+	case tokenIdentifier:
+		return "$id"
+	case tokenNumber:
+		return "$num"
+	case tokenString:
+		return "$str"
+	default:
+		return t.text
+	}
+}
+
+// keywords lists keywords of major languages, which are kept as is when normalizing identifiers.
+// Since keywords shape the structure of code, keeping them reduces false positives.
+var keywords = toSet(
+	// Control flow
+	"if", "else", "elif", "elsif", "unless", "then", "fi", "for", "foreach", "while", "do", "done", "loop", "until",
+	"switch", "case", "default", "match", "when", "select", "break", "continue", "return", "yield", "goto",
+	"try", "catch", "except", "finally", "throw", "throws", "raise", "rescue", "ensure", "defer", "go", "await", "async",
+	// Declarations
+	"func", "function", "fn", "fun", "def", "lambda", "class", "struct", "interface", "enum", "trait", "impl",
+	"type", "var", "let", "const", "val", "mut", "static", "final", "public", "private", "protected", "internal",
+	"package", "import", "from", "export", "module", "namespace", "using", "extends", "implements", "new", "delete",
+	// Operators and values
+	"and", "or", "not", "in", "is", "as", "instanceof", "typeof", "sizeof",
+	"true", "false", "nil", "null", "None", "True", "False", "undefined", "this", "self", "super", "void",
+)
+
+func toSet(words ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		set[w] = struct{}{}
+	}
+	return set
+}
+
+// hashCommentExts lists file extensions of languages using "#" for line comments.
+// "#" in other languages (such as C preprocessor directives) is lexed as a punctuation.
+var hashCommentExts = toSet(
+	".py", ".rb", ".pl", ".r", ".sh", ".bash", ".zsh", ".yaml", ".yml", ".toml", ".php", ".cmake", ".ex", ".exs", ".nim",
+)
+
+// lex splits source code into tokens, with a generic lexer which works reasonably well for most languages.
+// Whitespaces and comments are skipped.
+func lex(filename string, content []byte) []*token {
+	_, hashComment := hashCommentExts[strings.ToLower(filepath.Ext(filename))]
+
+	var tokens []*token
+	line := 1
+	n := len(content)
+	for i := 0; i < n; {
+		c := content[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case c == '/' && i+1 < n && content[i+1] == '/', hashComment && c == '#':
+			// Line comment
+			for i < n && content[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < n && content[i+1] == '*':
+			// Block comment
+			i += 2
+			for i < n && !(content[i] == '*' && i+1 < n && content[i+1] == '/') {
+				if content[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			i += 2
+		case isIdentStart(c):
+			start := i
+			for i < n && isIdentPart(content[i]) {
+				i++
+			}
+			text := string(content[start:i])
+			kind := tokenIdentifier
+			if _, ok := keywords[text]; ok {
+				kind = tokenKeyword
+			}
+			tokens = append(tokens, &token{kind: kind, text: text, line: line})
+		case isDigit(c) || c == '.' && i+1 < n && isDigit(content[i+1]):
+			start := i
+			// Loosely accept hex, floats, exponents and suffixes
+			for i < n && (isIdentPart(content[i]) || content[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, &token{kind: tokenNumber, text: string(content[start:i]), line: line})
+		case c == '"' || c == '\'' || c == '`':
+			start, startLine := i, line
+			i++
+			for i < n && content[i] != c {
+				if content[i] == '\\' && c != '`' {
+					i++
+				}
+				// Only backquoted strings can span lines, stop at the end of line otherwise (e.g. unpaired quotes in comments)
+				if i < n && content[i] == '\n' {
+					if c != '`' {
+						break
+					}
+					line++
+				}
+				i++
+			}
+			if i < n && content[i] == c {
+				i++
+			}
+			tokens = append(tokens, &token{kind: tokenString, text: string(content[start:min(i, n)]), line: startLine})
+		default:
+			tokens = append(tokens, &token{kind: tokenPunct, text: string(c), line: line})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,390 @@
+// Package tokensearch searches for clones by comparing token sequences.
+// Identifiers and literals are normalized, so that copies with renamed variables (Type-2 clones) are also detected.
+package tokensearch
+
+import (
+	"context"
+	"slices"
+
+	"github.com/cespare/xxhash"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"github.com/salab/iccheck/pkg/domain"
+	"github.com/salab/iccheck/pkg/utils/ds"
+)
+
+// maxAlignTokens limits the number of query tokens to align with the file tokens, since alignment is quadratic.
+const maxAlignTokens = 1000
+
+type Source struct {
+	Filename     string
+	StartL, EndL int
+}
+
+type Clone struct {
+	Filename  string
+	StartLine int
+	EndLine   int
+	// Similarity is the ratio of common token n-grams, from 0 to 1
+	Similarity float64
+	// Source indicates from which query this clone was detected
+	Source Source
+}
+
+type Query struct {
+	Filename     string
+	StartL, EndL int
+
+	// tokens are hashes of tokens in the query, including context lines
+	tokens []uint64
+	// nTokens is the number of tokens in the query, including context lines
+	nTokens int
+	// coreStart and coreEnd are token indices (inclusive) of the query itself, excluding context lines
+	coreStart, coreEnd int
+	// grams is the multiset of token n-gram hashes
+	grams map[uint64]int
+}
+
+func (q *Query) toSource() Source {
+	return Source{
+		Filename: q.Filename,
+		StartL:   q.StartL,
+		EndL:     q.EndL,
+	}
+}
+
+// tokenHashes lexes the content and returns hashes of (normalized) tokens, and their line numbers.
+func tokenHashes(c *config, filename string, content []byte) (hashes []uint64, lines []int) {
+	tokens := lex(filename, content)
+	hashes = make([]uint64, len(tokens))
+	lines = make([]int, len(tokens))
+	for i, t := range tokens {
+		text := t.text
+		if c.normalize {
+			text = t.normalized()
+		}
+		hashes[i] = xxhash.Sum64String(text)
+		lines[i] = t.line
+	}
+	return
+}
+
+// nGramHashes returns hashes of token n-grams.
+func nGramHashes(n int, tokens []uint64) []uint64 {
+	if len(tokens) < n {
+		return nil
+	}
+	grams := make([]uint64, len(tokens)-n+1)
+	for i := range grams {
+		var h uint64
+		for _, t := range tokens[i : i+n] {
+			h = h*1_000_003 ^ t
+		}
+		grams[i] = h
+	}
+	return grams
+}
+
+func (q *Query) readContents(c *config, queryTree domain.Searcher) error {
+	f, err := queryTree.Open(q.Filename)
+	if err != nil {
+		return err
+	}
+	content, err := f.Content()
+	if err != nil {
+		return err
+	}
+
+	hashes, lines := tokenHashes(c, q.Filename, content)
+	// StartL and EndL are 1-indexed
+	startIdx, _ := slices.BinarySearch(lines, q.StartL-c.contextLines)
+	coreStartIdx, _ := slices.BinarySearch(lines, q.StartL)
+	coreEndIdx, _ := slices.BinarySearch(lines, q.EndL+1)
+	endIdx, _ := slices.BinarySearch(lines, q.EndL+c.contextLines+1)
+	queryTokens := hashes[startIdx:endIdx]
+
+	q.tokens = queryTokens
+	q.nTokens = len(queryTokens)
+	q.coreStart, q.coreEnd = coreStartIdx-startIdx, coreEndIdx-startIdx-1
+	q.grams = lo.CountValues(nGramHashes(min(c.nGram, q.nTokens), queryTokens))
+	return nil
+}
+
+// codeSearch slides a window of the query's length over the file tokens,
+// and detects windows sharing most of the n-grams with the query.
+func codeSearch(
+	c *config,
+	q *Query,
+	fileTokens []uint64,
+	fileGrams []uint64,
+	fileLines []int,
+	filename string,
+	ignoreRule *domain.IgnoreLineRule,
+) (clones []*Clone) {
+	n := min(c.nGram, q.nTokens)
+	windowGrams := q.nTokens - n + 1
+	if windowGrams <= 0 || len(fileGrams) == 0 {
+		return nil
+	}
+
+	// Sliding window: maintain the multiset intersection of n-grams between the query and the window
+	window := make(map[uint64]int)
+	intersection := 0
+	add := func(h uint64) {
+		window[h]++
+		if window[h] <= q.grams[h] {
+			intersection++
+		}
+	}
+	remove := func(h uint64) {
+		if window[h] <= q.grams[h] {
+			intersection--
+		}
+		window[h]--
+	}
+	for _, h := range fileGrams[:min(windowGrams, len(fileGrams))] {
+		add(h)
+	}
+
+	type match struct {
+		start      int
+		similarity float64
+	}
+	var matches []match
+	// Windows shrink at the end of file, since the clone might be shorter than the query
+	for start := 0; start < len(fileGrams); start++ {
+		similarity := float64(intersection) / float64(windowGrams)
+		if similarity >= c.searchThreshold {
+			matches = append(matches, match{start, similarity})
+		}
+		remove(fileGrams[start])
+		if start+windowGrams < len(fileGrams) {
+			add(fileGrams[start+windowGrams])
+		}
+	}
+
+	// Adjacent windows of a clone are similar to the query too.
+	// Process windows from the most similar one, and skip windows close to already processed ones, or ending up in the same location.
+	slices.SortStableFunc(matches, ds.SortDesc(func(m match) float64 { return m.similarity }))
+	var processed []int
+	for _, m := range matches {
+		if lo.SomeBy(processed, func(start int) bool { return 4*abs(m.start-start) < q.nTokens }) {
+			continue
+		}
+		processed = append(processed, m.start)
+
+		// Report the lines corresponding to the query itself, excluding context lines
+		startLine, endLine := locateCore(q, fileTokens, fileLines, m.start)
+		if lo.SomeBy(clones, func(cl *Clone) bool { return cl.StartLine <= endLine && startLine <= cl.EndLine }) {
+			continue
+		}
+		// CanSkip takes 0-indexed lines
+		if canSkip, _ := ignoreRule.CanSkip(startLine-1, endLine-startLine+1); canSkip {
+			continue
+		}
+		clones = append(clones, &Clone{
+			Filename:   filename,
+			StartLine:  startLine,
+			EndLine:    endLine,
+			Similarity: m.similarity,
+			Source:     q.toSource(),
+		})
+	}
+	return clones
+}
+
+// locateCore aligns the query tokens with the file tokens around the matched window,
+// and returns the lines corresponding to the query itself (excluding context lines).
+// Queries longer than maxAlignTokens are not aligned, and the query itself is located at the same offset in the window.
+func locateCore(q *Query, fileTokens []uint64, fileLines []int, windowStart int) (startLine, endLine int) {
+	if q.nTokens > maxAlignTokens {
+		first := min(windowStart+q.coreStart, len(fileTokens)-1)
+		last := min(max(windowStart+q.coreEnd, first), len(fileTokens)-1)
+		return fileLines[first], fileLines[last]
+	}
+
+	// Allow some slack, since the clone might be longer than the query
+	from := max(0, windowStart-q.nTokens/4)
+	to := min(len(fileTokens), windowStart+q.nTokens+q.nTokens/4)
+	matched := align(q.tokens, fileTokens[from:to])
+
+	first, last := -1, -1
+	for i := q.coreStart; i <= q.coreEnd; i++ {
+		if matched[i] >= 0 {
+			first = lo.Ternary(first < 0, matched[i], first)
+			last = matched[i]
+		}
+	}
+	if first < 0 {
+		// The query itself is not in the clone (e.g. inserted lines) - locate by the surrounding context
+		prev, next := -1, to-from
+		for i := q.coreStart - 1; i >= 0 && prev < 0; i-- {
+			prev = matched[i]
+		}
+		for i := q.coreEnd + 1; i < q.nTokens && next == to-from; i++ {
+			if matched[i] >= 0 {
+				next = matched[i]
+			}
+		}
+		first, last = min(prev+1, to-from-1), max(next-1, prev+1)
+		last = min(max(first, last), to-from-1)
+	}
+	return fileLines[from+first], fileLines[from+last]
+}
+
+// align calculates the longest common subsequence of tokens,
+// and returns the index of the matched token in b for each token in a, or -1 if not matched.
+func align(a, b []uint64) []int {
+	// dp[i][j] = LCS length of a[i:] and b[j:]
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+
+	matched := lo.RepeatBy(len(a), func(_ int) int { return -1 })
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j] && dp[i][j] == dp[i+1][j+1]+1:
+			matched[i] = j
+			i++
+			j++
+		case dp[i+1][j] >= dp[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matched
+}
+
+func abs(x int) int {
+	return max(x, -x)
+}
+
+func fileSearch(
+	ctx context.Context,
+	c *config,
+	queries []*Query,
+	searchTree domain.Searcher,
+	searchFilename string,
+	matcher *domain.MatcherRules,
+) ([]*Clone, error) {
+	if ctx.Err() != nil { // check for deadline
+		return nil, ctx.Err()
+	}
+
+	searchFile, err := searchTree.Open(searchFilename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening search target file %v", searchFilename)
+	}
+
+	// Skip binary file search because it is rarely needed and consumes cpu
+	isBinary, err := searchFile.IsBinary()
+	if err != nil {
+		return nil, errors.Wrapf(err, "calculating binary status of search target file %v", searchFilename)
+	}
+	if isBinary {
+		return nil, nil
+	}
+
+	fileContent, err := searchFile.Content()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading search target file %v", searchFilename)
+	}
+
+	// Check ignore settings
+	skipEntireFile, ignoreRule := matcher.Match(searchFilename, fileContent)
+	if skipEntireFile {
+		return nil, nil
+	}
+
+	fileTokens, fileLines := tokenHashes(c, searchFilename, fileContent)
+	// n-grams are computed per length, since short queries use shorter n-grams
+	fileGramsCache := make(map[int][]uint64)
+	var fileGramSet map[uint64]struct{}
+
+	var clones []*Clone
+	for _, q := range queries {
+		if ctx.Err() != nil { // check for deadline
+			return nil, ctx.Err()
+		}
+		n := min(c.nGram, q.nTokens)
+		fileGrams, ok := fileGramsCache[n]
+		if !ok {
+			fileGrams = nGramHashes(n, fileTokens)
+			fileGramsCache[n] = fileGrams
+		}
+
+		// Fast path: skip the file if it does not contain enough n-grams of the query in the first place
+		if n == c.nGram {
+			if fileGramSet == nil {
+				fileGramSet = make(map[uint64]struct{}, len(fileGrams))
+				for _, h := range fileGrams {
+					fileGramSet[h] = struct{}{}
+				}
+			}
+			common := 0
+			for h, count := range q.grams {
+				if _, ok := fileGramSet[h]; ok {
+					common += count
+				}
+			}
+			if float64(common) < c.searchThreshold*float64(q.nTokens-n+1) {
+				continue
+			}
+		}
+
+		clones = append(clones, codeSearch(c, q, fileTokens, fileGrams, fileLines, searchFilename, ignoreRule)...)
+	}
+
+	return clones, nil
+}
+
+func Search(
+	ctx context.Context,
+	queriesTree domain.Searcher,
+	queries []*Query,
+	searchTree domain.Searcher,
+	matcher *domain.MatcherRules,
+	options ...ConfigFunc,
+) ([]*Clone, error) {
+	c := applyConfig(options...)
+
+	// Read and tokenize the queries
+	for _, q := range queries {
+		err := q.readContents(c, queriesTree)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading contents of query")
+		}
+	}
+	queries = lo.Filter(queries, func(q *Query, _ int) bool { return q.nTokens >= max(1, c.minTokens) })
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	// List all file names from search root directory
+	searchFiles, err := searchTree.Files()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing search tree files")
+	}
+
+	clones, err := domain.SearchFiles(ctx, searchFiles, c.partialResults, func(ctx context.Context, searchFile string) ([]*Clone, error) {
+		return fileSearch(ctx, c, queries, searchTree, searchFile, matcher)
+	})
+	if _, ok := domain.AsIncomplete(err); err != nil && !ok {
+		return nil, err
+	}
+
+	slices.SortFunc(clones, ds.SortDesc(func(c *Clone) float64 { return c.Similarity }))
+	return clones, err
+}
@@ -0,0 +1,115 @@
+package tokensearch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/samber/lo"
+
+	"github.com/salab/iccheck/pkg/domain"
+)
+
+func TestLex(t *testing.T) {
+	tokens := lex("a.go", []byte("x := foo(\"bar\", 42) // comment\n/* block\ncomment */ return x"))
+	var got []string
+	for _, tok := range tokens {
+		got = append(got, tok.normalized())
+	}
+	expected := []string{"$id", ":", "=", "$id", "(", "$str", ",", "$num", ")", "return", "$id"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("token %d: expected %v, got %v", i, expected[i], got[i])
+		}
+	}
+	if last := tokens[len(tokens)-1]; last.line != 3 {
+		t.Errorf("expected last token on line 3, got %d", last.line)
+	}
+}
+
+func TestSearch_RenamedClone(t *testing.T) {
+	const content = `package m
+
+func sumPositive(items []int) int {
+	total := 0
+	for _, item := range items {
+		if item > 0 && item < 100 {
+			total += item
+		}
+	}
+	return total
+}
+
+func countValid(values []int) int {
+	acc := 0
+	for _, v := range values {
+		if v > 0 {
+			acc += v
+		}
+	}
+	return acc
+}
+`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "m.go"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := domain.NewFileSystemTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := domain.ReadMatcherRules(dir, false, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searcher := domain.NewSearcherFromTree(tree)
+
+	clones, err := Search(context.Background(), searcher, []*Query{{Filename: "m.go", StartL: 6, EndL: 6}}, searcher, matcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, c := range clones {
+		if c.StartLine == 16 && c.EndLine == 16 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected renamed clone at L16 to be found, got %+v", clones)
+	}
+}
+
+func TestLocateCore(t *testing.T) {
+	// Each token is on its own line
+	lines := func(n int) []int { return lo.RepeatBy(n, func(i int) int { return i + 1 }) }
+
+	t.Run("aligned", func(t *testing.T) {
+		// Query tokens 2, 3, 4 with context tokens 1 and 5, and token 7 inserted in the clone
+		q := &Query{tokens: []uint64{1, 2, 3, 4, 5}, nTokens: 5, coreStart: 1, coreEnd: 3}
+		fileTokens := []uint64{9, 1, 2, 7, 3, 4, 5, 9}
+		startLine, endLine := locateCore(q, fileTokens, lines(len(fileTokens)), 1)
+		if startLine != 3 || endLine != 6 {
+			t.Errorf("expected L3-L6, got L%d-L%d", startLine, endLine)
+		}
+	})
+
+	t.Run("too long to align", func(t *testing.T) {
+		n := maxAlignTokens + 1
+		q := &Query{tokens: lo.RepeatBy(n, func(i int) uint64 { return uint64(i) }), nTokens: n, coreStart: 2, coreEnd: n - 3}
+		fileTokens := make([]uint64, n+10)
+		startLine, endLine := locateCore(q, fileTokens, lines(len(fileTokens)), 5)
+		if startLine != 8 || endLine != n+3 {
+			t.Errorf("expected window boundaries L8-L%d, got L%d-L%d", n+3, startLine, endLine)
+		}
+
+		// Window at the end of file
+		startLine, endLine = locateCore(q, fileTokens, lines(len(fileTokens)), len(fileTokens)-1)
+		if startLine != len(fileTokens) || endLine != len(fileTokens) {
+			t.Errorf("expected the last line, got L%d-L%d", startLine, endLine)
+		}
+	})
+}
@@ -78,3 +78,32 @@ func BigramIntersectionCount(s1, s2 BigramSet) int {
 	}
 	return cnt
 }
+
+// EditDistance calculates the Levenshtein distance between two strings, in bytes.
+func EditDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := lo.Ternary(a[i-1] == b[j-1], 0, 1)
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Closest returns the candidate closest to s in edit distance, if any is reasonably close.
+func Closest(s string, candidates []string) (string, bool) {
+	best, bestDist := "", len(s)/2+1
+	for _, c := range candidates {
+		if d := EditDistance(s, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best, best != ""
+}